@@ -0,0 +1,437 @@
+// Package backing binds a cache to a backing store.
+//
+// In write-through mode Put and Delete update the store before the cache
+// and return the error of the store.
+// In write-behind mode Put and Delete update the cache and mark the key dirty.
+// Dirty keys are coalesced, i.e. only the last write of a key is kept,
+// and flushed to the store in batches by a background goroutine.
+//
+// A dirty record evicted from the cache stays dirty until the store accepts it,
+// so that a write is never lost and Get keeps returning it in the meantime.
+// The eviction only triggers a background flush and never waits for the store.
+// For that Evicted must be registered as an eviction hook of the cache:
+//
+//	var b *backing.Cache
+//	c, _ := lru.New(1000, lru.WithEvictionHook(func(key string, value interface{}) {
+//		b.Evicted(key, value)
+//	}))
+//	b, _ = backing.New(c, store, backing.WithWriteBehind(time.Second, 100))
+//	defer b.Close(context.Background())
+package backing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrNotFound is returned by Get for a key deleted in write-behind mode but not flushed yet.
+	// Store implementations may return it from Load as well.
+	ErrNotFound = errors.New("not found")
+
+	// ErrClosed is returned by writes in write-behind mode after the cache is closed
+	ErrClosed = errors.New("cache is closed")
+)
+
+// Store represents a backing store
+type Store interface {
+	Load(ctx context.Context, key string) (interface{}, error)
+	Store(ctx context.Context, key string, value interface{}) error
+	Delete(ctx context.Context, key string) error
+}
+
+// Policy represents a cache bound to a store, e.g. lru.Cache or lfu.Cache
+type Policy interface {
+	Get(key string) (interface{}, bool)
+	Put(key string, value interface{})
+	PutIfAbsent(key string, value interface{}) bool
+	Delete(key string)
+}
+
+// Cache represents safe for concurrent use cache bound to a backing store
+type Cache struct {
+	cache Policy
+	store Store
+
+	writeBehind bool
+	interval    time.Duration
+	batchSize   int
+	retries     int
+	backoff     time.Duration
+
+	m       *sync.Mutex
+	dirty   map[string]entry
+	version uint64
+	closed  bool
+
+	// writing serializes writes of dirty entries,
+	// so that an older value never overwrites a newer one in the store
+	writing *sync.Mutex
+
+	// generations count writes of keys by their hash, so that Get does not cache
+	// a value loaded before a concurrent Put or Delete of the key
+	generations []uint64
+	filling     *sync.Mutex
+
+	// locks serialize writes of keys sharing a lock,
+	// so that the cache and the store end up with the value of the same write
+	locks []sync.Mutex
+
+	full      chan struct{}
+	stop      chan struct{}
+	done      chan struct{}
+	closeOnce *sync.Once
+}
+
+// Option configures a cache instance
+type Option func(*Cache)
+
+// WithWriteBehind switches the cache to write-behind mode.
+// Dirty keys are flushed every interval or as soon as there are batchSize of them.
+func WithWriteBehind(interval time.Duration, batchSize int) Option {
+	return func(c *Cache) {
+		c.writeBehind = true
+		c.interval = interval
+		c.batchSize = batchSize
+	}
+}
+
+// WithRetries makes the cache retry failed writes to the store.
+// The delay before a retry starts with backoff and doubles with every attempt.
+func WithRetries(retries int, backoff time.Duration) Option {
+	return func(c *Cache) {
+		c.retries = retries
+		c.backoff = backoff
+	}
+}
+
+// New returns an initialized cache instance bound to the store.
+// Write-behind mode starts a background goroutine which is stopped by Close.
+func New(cache Policy, store Store, options ...Option) (*Cache, error) {
+	c := &Cache{
+		cache:       cache,
+		store:       store,
+		m:           &sync.Mutex{},
+		dirty:       make(map[string]entry),
+		writing:     &sync.Mutex{},
+		generations: make([]uint64, generationsCount),
+		filling:     &sync.Mutex{},
+		locks:       make([]sync.Mutex, locksCount),
+		full:        make(chan struct{}, 1),
+		stop:        make(chan struct{}),
+		done:        make(chan struct{}),
+		closeOnce:   &sync.Once{},
+	}
+
+	for _, option := range options {
+		option(c)
+	}
+
+	if c.retries < 0 {
+		return nil, fmt.Errorf("retries can't be negative")
+	}
+
+	if !c.writeBehind {
+		close(c.done)
+		return c, nil
+	}
+
+	if c.interval <= 0 {
+		return nil, fmt.Errorf("flush interval can't be negative")
+	}
+	if c.batchSize <= 0 {
+		return nil, fmt.Errorf("batch size can't be negative")
+	}
+
+	go c.run()
+	return c, nil
+}
+
+// Get returns the value for a given key.
+// On a miss it returns a dirty value not flushed yet or loads the value from the store
+// and caches it unless the key is written in the meantime.
+func (c *Cache) Get(ctx context.Context, key string) (interface{}, error) {
+	if value, ok := c.cache.Get(key); ok {
+		return value, nil
+	}
+
+	generation := c.generation(key)
+
+	c.m.Lock()
+	e, dirty := c.dirty[key]
+	c.m.Unlock()
+
+	if dirty {
+		if e.deleted {
+			return nil, ErrNotFound
+		}
+		c.fill(key, generation, e.value)
+		return e.value, nil
+	}
+
+	value, err := c.store.Load(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	c.fill(key, generation, value)
+	return value, nil
+}
+
+// Put inserts a new record into the cache.
+// In write-through mode the value is written to the store first
+// and is not cached if the store fails.
+func (c *Cache) Put(ctx context.Context, key string, value interface{}) error {
+	lock := c.lock(key)
+	lock.Lock()
+	defer lock.Unlock()
+
+	if !c.writeBehind {
+		if err := c.retry(ctx, func() error { return c.store.Store(ctx, key, value) }); err != nil {
+			return err
+		}
+		c.update(key, func() { c.cache.Put(key, value) })
+		return nil
+	}
+
+	if err := c.markDirty(key, entry{value: value}); err != nil {
+		return err
+	}
+	c.update(key, func() { c.cache.Put(key, value) })
+	return nil
+}
+
+// Delete removes the record associated with the specified key from the cache.
+// In write-through mode the key is deleted from the store first
+// and is kept in the cache if the store fails.
+func (c *Cache) Delete(ctx context.Context, key string) error {
+	lock := c.lock(key)
+	lock.Lock()
+	defer lock.Unlock()
+
+	if !c.writeBehind {
+		if err := c.retry(ctx, func() error { return c.store.Delete(ctx, key) }); err != nil {
+			return err
+		}
+		c.update(key, func() { c.cache.Delete(key) })
+		return nil
+	}
+
+	if err := c.markDirty(key, entry{deleted: true}); err != nil {
+		return err
+	}
+	c.update(key, func() { c.cache.Delete(key) })
+	return nil
+}
+
+// Evicted triggers a background flush if the evicted record is dirty.
+// It is meant to be registered as an eviction hook of the cache
+// and does not wait for the store, since it is called under the lock of the cache.
+func (c *Cache) Evicted(key string, _ interface{}) {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	if e, dirty := c.dirty[key]; dirty && !e.deleted {
+		c.flushSoon()
+	}
+}
+
+// Flush writes all dirty records to the store.
+// Returns the first error of the store, failed records stay dirty.
+func (c *Cache) Flush(ctx context.Context) error {
+	c.writing.Lock()
+	defer c.writing.Unlock()
+
+	c.m.Lock()
+	keys := make([]string, 0, len(c.dirty))
+	for key := range c.dirty {
+		keys = append(keys, key)
+	}
+	c.m.Unlock()
+
+	var firstErr error
+	for _, key := range keys {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		c.m.Lock()
+		e, dirty := c.dirty[key]
+		c.m.Unlock()
+
+		if !dirty {
+			continue
+		}
+
+		if err := c.write(ctx, key, e); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// Dirty returns the number of records not written to the store yet
+func (c *Cache) Dirty() int {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	return len(c.dirty)
+}
+
+// Close stops the background flushes and flushes the dirty records.
+// Writes in write-behind mode fail with ErrClosed after Close is called.
+func (c *Cache) Close(ctx context.Context) error {
+	c.closeOnce.Do(func() {
+		c.m.Lock()
+		c.closed = true
+		c.m.Unlock()
+
+		if c.writeBehind {
+			close(c.stop)
+		}
+	})
+
+	select {
+	case <-c.done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	return c.Flush(ctx)
+}
+
+func (c *Cache) run() {
+	defer close(c.done)
+
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-c.full:
+		case <-c.stop:
+			return
+		}
+
+		// failed records stay dirty and are retried on the next flush
+		_ = c.Flush(context.Background())
+	}
+}
+
+// lock returns the lock serializing writes of the key
+func (c *Cache) lock(key string) *sync.Mutex {
+	return &c.locks[hash(key)%locksCount]
+}
+
+// generation returns the number of writes of keys sharing the hash with the key
+func (c *Cache) generation(key string) uint64 {
+	c.filling.Lock()
+	defer c.filling.Unlock()
+
+	return c.generations[hash(key)%generationsCount]
+}
+
+// update applies the write to the cache and makes loads of the key in progress stale
+func (c *Cache) update(key string, write func()) {
+	c.filling.Lock()
+	defer c.filling.Unlock()
+
+	c.generations[hash(key)%generationsCount]++
+	write()
+}
+
+// fill caches the value read by Get unless the key was written since the generation was taken
+func (c *Cache) fill(key string, generation uint64, value interface{}) {
+	c.filling.Lock()
+	defer c.filling.Unlock()
+
+	if c.generations[hash(key)%generationsCount] == generation {
+		c.cache.PutIfAbsent(key, value)
+	}
+}
+
+func (c *Cache) markDirty(key string, e entry) error {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	if c.closed {
+		return ErrClosed
+	}
+
+	c.version++
+	e.version = c.version
+	c.dirty[key] = e
+
+	if len(c.dirty) >= c.batchSize {
+		c.flushSoon()
+	}
+	return nil
+}
+
+// flushSoon wakes up the background flush without waiting for it
+func (c *Cache) flushSoon() {
+	select {
+	case c.full <- struct{}{}:
+	default:
+	}
+}
+
+// write writes the dirty entry to the store and marks it clean
+// unless the key was written again in the meantime.
+// The caller must hold c.writing.
+func (c *Cache) write(ctx context.Context, key string, e entry) error {
+	err := c.retry(ctx, func() error {
+		if e.deleted {
+			return c.store.Delete(ctx, key)
+		}
+		return c.store.Store(ctx, key, e.value)
+	})
+	if err != nil {
+		return err
+	}
+
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	if current, ok := c.dirty[key]; ok && current.version == e.version {
+		delete(c.dirty, key)
+	}
+	return nil
+}
+
+func (c *Cache) retry(ctx context.Context, f func() error) error {
+	backoff := c.backoff
+	for attempt := 0; ; attempt++ {
+		err := f()
+		if err == nil || attempt >= c.retries {
+			return err
+		}
+
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		backoff *= 2
+	}
+}
+
+const generationsCount = 256
+
+const locksCount = 64
+
+func hash(key string) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return h.Sum32()
+}
+
+type entry struct {
+	value   interface{}
+	deleted bool
+	version uint64
+}
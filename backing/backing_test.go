@@ -0,0 +1,373 @@
+package backing_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/faroyam/caches/backing"
+	"github.com/faroyam/caches/lfu"
+	"github.com/faroyam/caches/lru"
+)
+
+var errStore = errors.New("store is unavailable")
+
+// mapStore represents an in-memory store which fails the first failures writes
+type mapStore struct {
+	m        sync.Mutex
+	values   map[string]interface{}
+	writes   int
+	failures int
+}
+
+func newMapStore() *mapStore {
+	return &mapStore{values: make(map[string]interface{})}
+}
+
+func (s *mapStore) Load(_ context.Context, key string) (interface{}, error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	value, ok := s.values[key]
+	if !ok {
+		return nil, backing.ErrNotFound
+	}
+	return value, nil
+}
+
+func (s *mapStore) Store(_ context.Context, key string, value interface{}) error {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	if s.failures > 0 {
+		s.failures--
+		return errStore
+	}
+
+	s.writes++
+	s.values[key] = value
+	return nil
+}
+
+func (s *mapStore) Delete(_ context.Context, key string) error {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	if s.failures > 0 {
+		s.failures--
+		return errStore
+	}
+
+	s.writes++
+	delete(s.values, key)
+	return nil
+}
+
+func (s *mapStore) get(key string) (interface{}, bool) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	value, ok := s.values[key]
+	return value, ok
+}
+
+func (s *mapStore) setFailures(failures int) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	s.failures = failures
+}
+
+func (s *mapStore) writeCount() int {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	return s.writes
+}
+
+func newLRU(t *testing.T, store backing.Store, capacity int, options ...backing.Option) *backing.Cache {
+	var b *backing.Cache
+	c, _ := lru.New(capacity, lru.WithEvictionHook(func(key string, value interface{}) {
+		b.Evicted(key, value)
+	}))
+
+	b, err := backing.New(c, store, options...)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	return b
+}
+
+func TestNew(t *testing.T) {
+	c, _ := lru.New(1)
+
+	if _, err := backing.New(c, newMapStore(), backing.WithWriteBehind(0, 1)); err == nil {
+		t.Errorf("expected error")
+	}
+
+	if _, err := backing.New(c, newMapStore(), backing.WithWriteBehind(time.Second, 0)); err == nil {
+		t.Errorf("expected error")
+	}
+
+	if _, err := backing.New(c, newMapStore(), backing.WithRetries(-1, 0)); err == nil {
+		t.Errorf("expected error")
+	}
+}
+
+func TestCache_WriteThrough(t *testing.T) {
+	ctx := context.Background()
+	store := newMapStore()
+	cache := newLRU(t, store, 2)
+
+	if err := cache.Put(ctx, "key", "value"); err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+
+	if value, _ := store.get("key"); value != "value" {
+		t.Errorf("stored value %v, want %v", value, "value")
+	}
+
+	store.setFailures(1)
+
+	if err := cache.Put(ctx, "key", "value2"); err != errStore {
+		t.Errorf("error %v, want %v", err, errStore)
+	}
+
+	if value, _ := cache.Get(ctx, "key"); value != "value" {
+		t.Errorf("cached value %v, want %v", value, "value")
+	}
+
+	if err := cache.Delete(ctx, "key"); err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+
+	if _, err := cache.Get(ctx, "key"); err != backing.ErrNotFound {
+		t.Errorf("error %v, want %v", err, backing.ErrNotFound)
+	}
+}
+
+func TestCache_Get_Load(t *testing.T) {
+	ctx := context.Background()
+	store := newMapStore()
+	store.values["key"] = "value"
+	cache := newLRU(t, store, 2)
+
+	if value, err := cache.Get(ctx, "key"); err != nil || value != "value" {
+		t.Errorf("cached value %v, error %v, want %v, %v", value, err, "value", nil)
+	}
+
+	delete(store.values, "key")
+
+	if value, err := cache.Get(ctx, "key"); err != nil || value != "value" {
+		t.Errorf("cached value %v, error %v, want %v, %v", value, err, "value", nil)
+	}
+}
+
+// racingStore runs loaded after the next Load returns the old value
+// and stored after the next Store writes the value
+type racingStore struct {
+	*mapStore
+	loaded func()
+	stored func()
+}
+
+func (s *racingStore) Store(ctx context.Context, key string, value interface{}) error {
+	err := s.mapStore.Store(ctx, key, value)
+	if s.stored != nil {
+		stored := s.stored
+		s.stored = nil
+		stored()
+	}
+	return err
+}
+
+func (s *racingStore) Load(ctx context.Context, key string) (interface{}, error) {
+	value, err := s.mapStore.Load(ctx, key)
+	if s.loaded != nil {
+		loaded := s.loaded
+		s.loaded = nil
+		loaded()
+	}
+	return value, err
+}
+
+func TestCache_Get_Race(t *testing.T) {
+	ctx := context.Background()
+	store := &racingStore{mapStore: newMapStore()}
+	store.values["key"] = "value"
+	cache := newLRU(t, store, 2)
+
+	// the key is deleted while its old value is being loaded
+
+	store.loaded = func() { cache.Delete(ctx, "key") }
+
+	if value, err := cache.Get(ctx, "key"); err != nil || value != "value" {
+		t.Errorf("cached value %v, error %v, want %v, %v", value, err, "value", nil)
+	}
+
+	if _, err := cache.Get(ctx, "key"); err != backing.ErrNotFound {
+		t.Errorf("error %v, want %v", err, backing.ErrNotFound)
+	}
+}
+
+func TestCache_Put_Race(t *testing.T) {
+	ctx := context.Background()
+	store := &racingStore{mapStore: newMapStore()}
+	cache := newLRU(t, store, 2)
+
+	// the key is put again between the first write to the store and the cache
+
+	done := make(chan struct{})
+	store.stored = func() {
+		go func() {
+			defer close(done)
+			cache.Put(ctx, "key", 2)
+		}()
+		time.Sleep(time.Millisecond * 10)
+	}
+	cache.Put(ctx, "key", 1)
+	<-done
+
+	stored, _ := store.get("key")
+	if value, _ := cache.Get(ctx, "key"); value != stored {
+		t.Errorf("cached value %v, stored value %v", value, stored)
+	}
+}
+
+func TestCache_WriteBehind(t *testing.T) {
+	ctx := context.Background()
+	store := newMapStore()
+	cache := newLRU(t, store, 10, backing.WithWriteBehind(time.Hour, 100))
+	defer cache.Close(ctx)
+
+	cache.Put(ctx, "1", 1)
+	cache.Put(ctx, "1", 2)
+	cache.Put(ctx, "1", 3)
+	cache.Put(ctx, "2", 2)
+	cache.Delete(ctx, "2")
+
+	if cache.Dirty() != 2 || store.writeCount() != 0 {
+		t.Errorf("dirty %v, writes %v, want %v, %v", cache.Dirty(), store.writeCount(), 2, 0)
+	}
+
+	if _, err := cache.Get(ctx, "2"); err != backing.ErrNotFound {
+		t.Errorf("error %v, want %v", err, backing.ErrNotFound)
+	}
+
+	if err := cache.Flush(ctx); err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+
+	// writes are coalesced
+
+	if cache.Dirty() != 0 || store.writeCount() != 2 {
+		t.Errorf("dirty %v, writes %v, want %v, %v", cache.Dirty(), store.writeCount(), 0, 2)
+	}
+
+	if value, _ := store.get("1"); value != 3 {
+		t.Errorf("stored value %v, want %v", value, 3)
+	}
+}
+
+func TestCache_WriteBehind_Background(t *testing.T) {
+	ctx := context.Background()
+	store := newMapStore()
+	cache := newLRU(t, store, 10, backing.WithWriteBehind(time.Hour, 2))
+	defer cache.Close(ctx)
+
+	cache.Put(ctx, "1", 1)
+	cache.Put(ctx, "2", 2)
+
+	// the batch is full and flushed in background
+
+	for i := 0; i < 100 && cache.Dirty() != 0; i++ {
+		time.Sleep(time.Millisecond * 10)
+	}
+
+	if cache.Dirty() != 0 || store.writeCount() != 2 {
+		t.Errorf("dirty %v, writes %v, want %v, %v", cache.Dirty(), store.writeCount(), 0, 2)
+	}
+}
+
+func TestCache_WriteBehind_Retries(t *testing.T) {
+	ctx := context.Background()
+	store := newMapStore()
+	cache := newLRU(t, store, 10, backing.WithWriteBehind(time.Hour, 100), backing.WithRetries(1, time.Millisecond))
+	defer cache.Close(ctx)
+
+	cache.Put(ctx, "1", 1)
+	store.setFailures(2)
+
+	if err := cache.Flush(ctx); err != errStore {
+		t.Errorf("error %v, want %v", err, errStore)
+	}
+
+	if cache.Dirty() != 1 {
+		t.Errorf("dirty %v, want %v", cache.Dirty(), 1)
+	}
+
+	store.setFailures(1)
+
+	if err := cache.Flush(ctx); err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+
+	if value, _ := store.get("1"); value != 1 {
+		t.Errorf("stored value %v, want %v", value, 1)
+	}
+}
+
+func TestCache_WriteBehind_Evicted(t *testing.T) {
+	ctx := context.Background()
+	store := newMapStore()
+
+	var b *backing.Cache
+	c, _ := lfu.New(1, lfu.WithEvictionHook(func(key string, value interface{}) {
+		b.Evicted(key, value)
+	}))
+	b, _ = backing.New(c, store, backing.WithWriteBehind(time.Hour, 100))
+	defer b.Close(ctx)
+
+	b.Put(ctx, "1", 1)
+	b.Put(ctx, "2", 2)
+
+	// the evicted record stays dirty until the background flush writes it
+
+	if value, err := b.Get(ctx, "1"); err != nil || value != 1 {
+		t.Errorf("cached value %v, error %v, want %v, %v", value, err, 1, nil)
+	}
+
+	for i := 0; i < 100 && b.Dirty() != 0; i++ {
+		time.Sleep(time.Millisecond * 10)
+	}
+
+	if value, _ := store.get("1"); value != 1 {
+		t.Errorf("stored value %v, want %v", value, 1)
+	}
+
+	if b.Dirty() != 0 {
+		t.Errorf("dirty %v, want %v", b.Dirty(), 0)
+	}
+}
+
+func TestCache_Close(t *testing.T) {
+	ctx := context.Background()
+	store := newMapStore()
+	cache := newLRU(t, store, 10, backing.WithWriteBehind(time.Hour, 100))
+
+	cache.Put(ctx, "1", 1)
+
+	if err := cache.Close(ctx); err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+
+	if value, _ := store.get("1"); value != 1 {
+		t.Errorf("stored value %v, want %v", value, 1)
+	}
+
+	if err := cache.Put(ctx, "2", 2); err != backing.ErrClosed {
+		t.Errorf("error %v, want %v", err, backing.ErrClosed)
+	}
+}
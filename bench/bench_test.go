@@ -11,6 +11,8 @@ import (
 const (
 	size100k = 1000_000
 	size1kk  = 1_000_000
+
+	batchSize = 100
 )
 
 type cache interface {
@@ -18,25 +20,57 @@ type cache interface {
 	Put(key string, value interface{})
 }
 
+type batchCache interface {
+	cache
+	GetMany(keys []string) map[string]interface{}
+	PutMany(values map[string]interface{})
+}
+
 var result interface{}
 
 func BenchmarkMapGet100k(b *testing.B)      { benchmarkGet(initMap(size100k, size100k), size100k, b) }
 func BenchmarkLRUGet100k(b *testing.B)      { benchmarkGet(initLRUCache(size100k), size100k, b) }
 func BenchmarkLFUGet100k(b *testing.B)      { benchmarkGet(initLFUCache(size100k), size100k, b) }
 func BenchmarkExpiringGet100k(b *testing.B) { benchmarkGet(initExpiringCache(size100k), size100k, b) }
+func BenchmarkByteGet100k(b *testing.B)     { benchmarkGet(initByteCache(size100k), size100k, b) }
 func BenchmarkMapGet1kk(b *testing.B)       { benchmarkGet(initMap(size1kk, size1kk), size1kk, b) }
 func BenchmarkLRUGet1kk(b *testing.B)       { benchmarkGet(initLRUCache(size1kk), size1kk, b) }
 func BenchmarkLFUGet1kk(b *testing.B)       { benchmarkGet(initLFUCache(size1kk), size1kk, b) }
 func BenchmarkExpiringGet1kk(b *testing.B)  { benchmarkGet(initExpiringCache(size1kk), size1kk, b) }
+func BenchmarkByteGet1kk(b *testing.B)      { benchmarkGet(initByteCache(size1kk), size1kk, b) }
 
 func BenchmarkMapPut100k(b *testing.B)      { benchmarkPut(initMap(size100k, size100k*10), size100k, b) }
 func BenchmarkLRUPut100k(b *testing.B)      { benchmarkPut(initLRUCache(size100k), size100k, b) }
 func BenchmarkLFUPut100k(b *testing.B)      { benchmarkPut(initLFUCache(size100k), size100k, b) }
 func BenchmarkExpiringPut100k(b *testing.B) { benchmarkGet(initExpiringCache(size100k), size100k, b) }
+func BenchmarkBytePut100k(b *testing.B)     { benchmarkPut(initByteCache(size100k), size100k, b) }
 func BenchmarkMapPut1kk(b *testing.B)       { benchmarkPut(initMap(size1kk, size1kk*10), size1kk, b) }
 func BenchmarkLRUPut1kk(b *testing.B)       { benchmarkPut(initLRUCache(size1kk), size1kk, b) }
 func BenchmarkLFUPut1kk(b *testing.B)       { benchmarkPut(initLFUCache(size1kk), size1kk, b) }
 func BenchmarkExpiringPut1kk(b *testing.B)  { benchmarkGet(initExpiringCache(size1kk), size1kk, b) }
+func BenchmarkBytePut1kk(b *testing.B)      { benchmarkPut(initByteCache(size1kk), size1kk, b) }
+
+func BenchmarkLRUGetBatch100k(b *testing.B) { benchmarkGetBatch(initLRUCache(size100k), size100k, b) }
+func BenchmarkLRUGetMany100k(b *testing.B)  { benchmarkGetMany(initLRUCache(size100k), size100k, b) }
+func BenchmarkLFUGetBatch100k(b *testing.B) { benchmarkGetBatch(initLFUCache(size100k), size100k, b) }
+func BenchmarkLFUGetMany100k(b *testing.B)  { benchmarkGetMany(initLFUCache(size100k), size100k, b) }
+func BenchmarkExpiringGetBatch100k(b *testing.B) {
+	benchmarkGetBatch(initExpiringCache(size100k), size100k, b)
+}
+func BenchmarkExpiringGetMany100k(b *testing.B) {
+	benchmarkGetMany(initExpiringCache(size100k), size100k, b)
+}
+
+func BenchmarkLRUPutBatch100k(b *testing.B) { benchmarkPutBatch(initLRUCache(size100k), size100k, b) }
+func BenchmarkLRUPutMany100k(b *testing.B)  { benchmarkPutMany(initLRUCache(size100k), size100k, b) }
+func BenchmarkLFUPutBatch100k(b *testing.B) { benchmarkPutBatch(initLFUCache(size100k), size100k, b) }
+func BenchmarkLFUPutMany100k(b *testing.B)  { benchmarkPutMany(initLFUCache(size100k), size100k, b) }
+func BenchmarkExpiringPutBatch100k(b *testing.B) {
+	benchmarkPutBatch(initExpiringCache(size100k), size100k, b)
+}
+func BenchmarkExpiringPutMany100k(b *testing.B) {
+	benchmarkPutMany(initExpiringCache(size100k), size100k, b)
+}
 
 func benchmarkGet(cache cache, size int, b *testing.B) {
 	var v interface{}
@@ -57,6 +91,87 @@ func benchmarkPut(cache cache, size int, b *testing.B) {
 	result, _ = cache.Get(strconv.Itoa(size))
 }
 
+// benchmarkGetBatch looks up batchSize keys per operation with separate Get calls
+// from parallel goroutines
+func benchmarkGetBatch(cache cache, size int, b *testing.B) {
+	keys := batchKeys(size)
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for n := 0; pb.Next(); n++ {
+			for _, key := range keys[n%len(keys)] {
+				cache.Get(key)
+			}
+		}
+	})
+}
+
+// benchmarkGetMany looks up batchSize keys per operation with a single GetMany call
+// from parallel goroutines
+func benchmarkGetMany(cache batchCache, size int, b *testing.B) {
+	keys := batchKeys(size)
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for n := 0; pb.Next(); n++ {
+			cache.GetMany(keys[n%len(keys)])
+		}
+	})
+}
+
+// benchmarkPutBatch inserts batchSize records per operation with separate Put calls
+// from parallel goroutines
+func benchmarkPutBatch(cache cache, size int, b *testing.B) {
+	values := batchValues(size)
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for n := 0; pb.Next(); n++ {
+			for key, value := range values[n%len(values)] {
+				cache.Put(key, value)
+			}
+		}
+	})
+}
+
+// benchmarkPutMany inserts batchSize records per operation with a single PutMany call
+// from parallel goroutines
+func benchmarkPutMany(cache batchCache, size int, b *testing.B) {
+	values := batchValues(size)
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for n := 0; pb.Next(); n++ {
+			cache.PutMany(values[n%len(values)])
+		}
+	})
+}
+
+func batchKeys(size int) [][]string {
+	batches := make([][]string, 0, size/batchSize)
+	for i := 0; i+batchSize <= size; i += batchSize {
+		keys := make([]string, 0, batchSize)
+		for j := i; j < i+batchSize; j++ {
+			keys = append(keys, strconv.Itoa(j))
+		}
+		batches = append(batches, keys)
+	}
+	return batches
+}
+
+func batchValues(size int) []map[string]interface{} {
+	batches := make([]map[string]interface{}, 0, size/batchSize)
+	for _, keys := range batchKeys(size) {
+		values := make(map[string]interface{}, batchSize)
+		for _, key := range keys {
+			key = key + "'"
+			values[key] = key
+		}
+		batches = append(batches, values)
+	}
+	return batches
+}
+
 func initMap(size, cap int) *Map {
 	m := NewMap(cap)
 	for i := 0; i < size; i++ {
@@ -84,6 +199,16 @@ func initLFUCache(size int) *lfu.Cache {
 	return c
 }
 
+// initByteCache allocates 64 bytes per record which fits short keys and values
+func initByteCache(size int) *ByteCache {
+	c, _ := NewByteCache(size * 64)
+	for i := 0; i < size; i++ {
+		key := strconv.Itoa(i)
+		c.Put(key, key)
+	}
+	return c
+}
+
 func initExpiringCache(size int) *ExpiringMap {
 	c, _ := NewExpiringMap(size)
 	for i := 0; i < size; i++ {
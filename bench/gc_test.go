@@ -0,0 +1,35 @@
+package bench_test
+
+import (
+	"runtime"
+	"testing"
+)
+
+func BenchmarkMapGC1kk(b *testing.B)      { benchmarkGC(initMap(size1kk, size1kk), b) }
+func BenchmarkLRUGC1kk(b *testing.B)      { benchmarkGC(initLRUCache(size1kk), b) }
+func BenchmarkLFUGC1kk(b *testing.B)      { benchmarkGC(initLFUCache(size1kk), b) }
+func BenchmarkExpiringGC1kk(b *testing.B) { benchmarkGC(initExpiringCache(size1kk), b) }
+func BenchmarkByteGC1kk(b *testing.B)     { benchmarkGC(initByteCache(size1kk), b) }
+
+// benchmarkGC measures a full garbage collection cycle with the cache alive.
+// ns/op is the duration of the cycle, most of which is spent on scanning the heap,
+// pause-ns/gc is the stop-the-world time of the cycle.
+func benchmarkGC(cache cache, b *testing.B) {
+	runtime.GC()
+
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		runtime.GC()
+	}
+	b.StopTimer()
+
+	runtime.ReadMemStats(&after)
+	if cycles := after.NumGC - before.NumGC; cycles > 0 {
+		b.ReportMetric(float64(after.PauseTotalNs-before.PauseTotalNs)/float64(cycles), "pause-ns/gc")
+	}
+
+	runtime.KeepAlive(cache)
+}
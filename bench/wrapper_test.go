@@ -4,6 +4,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/faroyam/caches/bytecache"
 	"github.com/faroyam/caches/excache"
 )
 
@@ -67,3 +68,43 @@ func (c *ExpiringMap) Get(key string) (interface{}, bool) {
 func (c *ExpiringMap) Put(key string, value interface{}) {
 	c.cache.Put(key, value, time.Second)
 }
+
+// GetMany returns values for the given keys that exist in the cache
+func (c *ExpiringMap) GetMany(keys []string) map[string]interface{} {
+	return c.cache.GetMany(keys)
+}
+
+// PutMany inserts new records in the cache
+func (c *ExpiringMap) PutMany(values map[string]interface{}) {
+	c.cache.PutMany(values, time.Second)
+}
+
+// ByteCache represents an interface to byte cache storing string values as []byte
+type ByteCache struct {
+	cache *bytecache.Cache
+}
+
+// NewByteCache returns an initialized cache instance
+func NewByteCache(capacity int) (*ByteCache, error) {
+	cache, err := bytecache.New(capacity)
+	if err != nil {
+		return nil, err
+	}
+	return &ByteCache{
+		cache: cache,
+	}, nil
+}
+
+// Get returns (value, true) or (nil, false) for the given key
+func (c *ByteCache) Get(key string) (interface{}, bool) {
+	value, ok := c.cache.Get(key)
+	if !ok {
+		return nil, false
+	}
+	return value, true
+}
+
+// Put inserts new record in the cache
+func (c *ByteCache) Put(key string, value interface{}) {
+	c.cache.Put(key, []byte(value.(string)))
+}
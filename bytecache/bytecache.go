@@ -0,0 +1,351 @@
+// Package bytecache implements a cache of []byte values designed to keep GC pauses short
+// with millions of records.
+//
+// Records are serialized into large preallocated ring buffers, one per shard,
+// and indexed by pointer-free map[uint64]uint32 maps from key hashes to buffer offsets,
+// so the garbage collector has nothing to scan inside the cache.
+// When a buffer is full the oldest records are overwritten.
+// Deleted and overwritten records occupy the buffer until they are overwritten as well.
+package bytecache
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+)
+
+// record header: key hash, key length, value length
+const headerSize = 8 + 2 + 4
+
+// ErrTooLarge is returned by Put if a record does not fit a shard
+var ErrTooLarge = errors.New("record is too large")
+
+// Policy defines which records are overwritten when a shard is full
+type Policy int
+
+const (
+	// FIFO overwrites the oldest inserted records
+	FIFO Policy = iota
+	// LRU moves records read while being among the oldest ones to the head of the buffer,
+	// which approximates Least Recently Used eviction
+	LRU
+)
+
+// Stats represents statistics of the cache
+type Stats struct {
+	Hits       int64
+	Misses     int64
+	Evictions  int64
+	Collisions int64
+}
+
+// Cache represents safe for concurrent use cache of []byte values
+type Cache struct {
+	shards []*shard
+	mask   uint64
+
+	shardsCount int
+	policy      Policy
+}
+
+// Option configures a cache instance
+type Option func(*Cache)
+
+// WithShards sets the number of shards, it must be a power of two. 64 by default.
+// More shards mean less lock contention and smaller buffers.
+func WithShards(n int) Option {
+	return func(c *Cache) {
+		c.shardsCount = n
+	}
+}
+
+// WithPolicy sets the eviction policy. FIFO by default.
+func WithPolicy(policy Policy) Option {
+	return func(c *Cache) {
+		c.policy = policy
+	}
+}
+
+// New returns an initialized cache instance.
+// capacity is the total size of the buffers in bytes.
+func New(capacity int, options ...Option) (*Cache, error) {
+	if capacity <= 0 {
+		return nil, fmt.Errorf("capacity can't be negative")
+	}
+	c := &Cache{
+		shardsCount: 64,
+	}
+
+	for _, option := range options {
+		option(c)
+	}
+
+	if c.shardsCount <= 0 || c.shardsCount&(c.shardsCount-1) != 0 {
+		return nil, fmt.Errorf("shards count must be a power of two")
+	}
+	shardCapacity := capacity / c.shardsCount
+	if shardCapacity < headerSize {
+		return nil, fmt.Errorf("capacity is too small for %d shards", c.shardsCount)
+	}
+	if shardCapacity > math.MaxUint32 {
+		return nil, fmt.Errorf("capacity is too large for %d shards", c.shardsCount)
+	}
+
+	c.mask = uint64(c.shardsCount - 1)
+	c.shards = make([]*shard, c.shardsCount)
+	for i := range c.shards {
+		c.shards[i] = newShard(shardCapacity, c.policy)
+	}
+	return c, nil
+}
+
+// Get returns a copy of the value and true or (nil, false) for a given key
+func (c *Cache) Get(key string) ([]byte, bool) {
+	h := hash(key)
+	return c.shard(h).get(key, h)
+}
+
+// Put inserts a copy of the value into the cache.
+// Returns ErrTooLarge if the record does not fit a shard.
+func (c *Cache) Put(key string, value []byte) error {
+	if len(key) > math.MaxUint16 {
+		return ErrTooLarge
+	}
+
+	h := hash(key)
+	return c.shard(h).put(key, h, value)
+}
+
+// Delete removes the record associated with the specified key
+func (c *Cache) Delete(key string) {
+	h := hash(key)
+	c.shard(h).delete(key, h)
+}
+
+// Clear removes all saved records
+func (c *Cache) Clear() {
+	for _, s := range c.shards {
+		s.clear()
+	}
+}
+
+// Len returns the number of records in the cache
+func (c *Cache) Len() int {
+	n := 0
+	for _, s := range c.shards {
+		s.m.Lock()
+		n += len(s.index)
+		s.m.Unlock()
+	}
+	return n
+}
+
+// Cap returns the total size of the buffers in bytes
+func (c *Cache) Cap() int {
+	return len(c.shards) * len(c.shards[0].buf)
+}
+
+// Stats returns statistics of the cache
+func (c *Cache) Stats() Stats {
+	var stats Stats
+	for _, s := range c.shards {
+		s.m.Lock()
+		stats.Hits += s.stats.Hits
+		stats.Misses += s.stats.Misses
+		stats.Evictions += s.stats.Evictions
+		stats.Collisions += s.stats.Collisions
+		s.m.Unlock()
+	}
+	return stats
+}
+
+func (c *Cache) shard(h uint64) *shard {
+	return c.shards[h&c.mask]
+}
+
+// shard represents a ring buffer of records.
+// Records are never split: a record that does not fit the end of the buffer is written at its start.
+// Live bytes are [head, tail) or [head, end) followed by [0, tail) once the buffer wraps.
+type shard struct {
+	m      *sync.Mutex
+	policy Policy
+
+	buf     []byte
+	index   map[uint64]uint32
+	head    uint32
+	tail    uint32
+	end     uint32
+	wrapped bool
+	count   int // number of records in the buffer including deleted ones
+
+	stats Stats
+}
+
+func newShard(capacity int, policy Policy) *shard {
+	return &shard{
+		m:      &sync.Mutex{},
+		policy: policy,
+		buf:    make([]byte, capacity),
+		index:  make(map[uint64]uint32),
+	}
+}
+
+func (s *shard) get(key string, h uint64) ([]byte, bool) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	offset, ok := s.index[h]
+	if !ok || !s.matches(offset, key) {
+		s.stats.Misses++
+		return nil, false
+	}
+	s.stats.Hits++
+
+	value := append([]byte(nil), s.value(offset)...)
+	if s.policy == LRU && s.old(offset) {
+		s.write(key, h, value)
+	}
+	return value, true
+}
+
+func (s *shard) put(key string, h uint64, value []byte) error {
+	if headerSize+len(key)+len(value) > len(s.buf) {
+		return ErrTooLarge
+	}
+
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	if offset, ok := s.index[h]; ok && !s.matches(offset, key) {
+		s.stats.Collisions++
+	}
+	s.write(key, h, value)
+	return nil
+}
+
+func (s *shard) delete(key string, h uint64) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	if offset, ok := s.index[h]; ok && s.matches(offset, key) {
+		delete(s.index, h)
+	}
+}
+
+func (s *shard) clear() {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	s.index = make(map[uint64]uint32)
+	s.reset()
+}
+
+func (s *shard) reset() {
+	s.head, s.tail, s.end = 0, 0, 0
+	s.wrapped = false
+	s.count = 0
+}
+
+// write appends the record to the buffer overwriting the oldest records if necessary
+func (s *shard) write(key string, h uint64, value []byte) {
+	size := uint32(headerSize + len(key) + len(value))
+	s.allocate(size)
+
+	b := s.buf[s.tail : s.tail+size]
+	binary.LittleEndian.PutUint64(b[0:8], h)
+	binary.LittleEndian.PutUint16(b[8:10], uint16(len(key)))
+	binary.LittleEndian.PutUint32(b[10:14], uint32(len(value)))
+	copy(b[headerSize:], key)
+	copy(b[headerSize+len(key):], value)
+
+	s.index[h] = s.tail
+	s.tail += size
+	s.count++
+}
+
+// allocate moves the tail to a place with size free bytes
+func (s *shard) allocate(size uint32) {
+	capacity := uint32(len(s.buf))
+	for {
+		switch {
+		case s.count == 0:
+			s.reset()
+			return
+		case !s.wrapped && capacity-s.tail >= size:
+			return
+		case !s.wrapped && s.head >= size:
+			s.end = s.tail
+			s.tail = 0
+			s.wrapped = true
+			return
+		case s.wrapped && s.head-s.tail >= size:
+			return
+		}
+		s.evict()
+	}
+}
+
+// evict removes the oldest record from the buffer
+func (s *shard) evict() {
+	h := binary.LittleEndian.Uint64(s.buf[s.head:])
+	if offset, ok := s.index[h]; ok && offset == s.head {
+		delete(s.index, h)
+		s.stats.Evictions++
+	}
+
+	s.head += s.size(s.head)
+	s.count--
+	if s.wrapped && s.head == s.end {
+		s.head = 0
+		s.wrapped = false
+	}
+}
+
+// old reports whether the record is among the oldest quarter of the buffer
+func (s *shard) old(offset uint32) bool {
+	used := s.tail - s.head
+	age := offset - s.head
+	if s.wrapped {
+		used = s.end - s.head + s.tail
+		if offset < s.head {
+			age = s.end - s.head + offset
+		}
+	}
+	return age < used/4
+}
+
+func (s *shard) size(offset uint32) uint32 {
+	keyLen := uint32(binary.LittleEndian.Uint16(s.buf[offset+8:]))
+	valueLen := binary.LittleEndian.Uint32(s.buf[offset+10:])
+	return headerSize + keyLen + valueLen
+}
+
+// matches reports whether the record at the offset has the key, hashes of different keys may collide
+func (s *shard) matches(offset uint32, key string) bool {
+	keyLen := uint32(binary.LittleEndian.Uint16(s.buf[offset+8:]))
+	return string(s.buf[offset+headerSize:offset+headerSize+keyLen]) == key
+}
+
+func (s *shard) value(offset uint32) []byte {
+	keyLen := uint32(binary.LittleEndian.Uint16(s.buf[offset+8:]))
+	valueLen := binary.LittleEndian.Uint32(s.buf[offset+10:])
+	start := offset + headerSize + keyLen
+	return s.buf[start : start+valueLen]
+}
+
+// hash returns 64-bit FNV-1a hash of the key without allocations
+func hash(key string) uint64 {
+	const (
+		offset64 = 14695981039346656037
+		prime64  = 1099511628211
+	)
+
+	h := uint64(offset64)
+	for i := 0; i < len(key); i++ {
+		h ^= uint64(key[i])
+		h *= prime64
+	}
+	return h
+}
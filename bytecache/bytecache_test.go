@@ -0,0 +1,188 @@
+package bytecache_test
+
+import (
+	"bytes"
+	"math/rand"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/faroyam/caches/bytecache"
+)
+
+func TestNew(t *testing.T) {
+	for _, options := range [][]bytecache.Option{
+		{bytecache.WithShards(0)},
+		{bytecache.WithShards(3)},
+		{bytecache.WithShards(1024)},
+	} {
+		if _, err := bytecache.New(1024, options...); err == nil {
+			t.Errorf("expected error")
+		}
+	}
+
+	if _, err := bytecache.New(0); err == nil {
+		t.Errorf("expected error")
+	}
+}
+
+func TestCache_Get(t *testing.T) {
+	cache, _ := bytecache.New(1024, bytecache.WithShards(1))
+
+	cache.Put("1", []byte("value1"))
+	cache.Put("2", []byte("value2"))
+	cache.Put("1", []byte("value1'"))
+
+	if value, ok := cache.Get("1"); !ok || string(value) != "value1'" {
+		t.Errorf("cached value %s, want %v", value, "value1'")
+	}
+
+	if value, ok := cache.Get("non-existing-key"); ok {
+		t.Errorf("cached value %s, want %v", value, "nil")
+	}
+
+	if cache.Len() != 2 {
+		t.Errorf("cache len %v, want %v", cache.Len(), 2)
+	}
+
+	if stats := cache.Stats(); stats.Hits != 1 || stats.Misses != 1 {
+		t.Errorf("stats %+v", stats)
+	}
+
+	// values are copied
+
+	value := []byte("value3")
+	cache.Put("3", value)
+	value[0] = 'V'
+	got, _ := cache.Get("3")
+	got[1] = 'A'
+
+	if value, _ := cache.Get("3"); string(value) != "value3" {
+		t.Errorf("cached value %s, want %v", value, "value3")
+	}
+}
+
+func TestCache_Put(t *testing.T) {
+	cache, _ := bytecache.New(64, bytecache.WithShards(1))
+
+	if err := cache.Put("key", make([]byte, 64)); err != bytecache.ErrTooLarge {
+		t.Errorf("error %v, want %v", err, bytecache.ErrTooLarge)
+	}
+
+	if err := cache.Put("key", make([]byte, 64-14-3)); err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+}
+
+func TestCache_Delete(t *testing.T) {
+	cache, _ := bytecache.New(1024, bytecache.WithShards(1))
+
+	cache.Put("1", []byte("value1"))
+	cache.Put("2", []byte("value2"))
+	cache.Delete("1")
+	cache.Delete("non-existing-key")
+
+	if _, ok := cache.Get("1"); ok || cache.Len() != 1 {
+		t.Errorf("cache len %v, want %v", cache.Len(), 1)
+	}
+
+	cache.Clear()
+
+	if _, ok := cache.Get("2"); ok || cache.Len() != 0 {
+		t.Errorf("cache len %v, want %v", cache.Len(), 0)
+	}
+}
+
+func TestCache_FIFO(t *testing.T) {
+	// each record takes 14 + 1 + 5 = 20 bytes
+	cache, _ := bytecache.New(100, bytecache.WithShards(1))
+
+	for i := 0; i < 7; i++ {
+		if i == 5 {
+			cache.Get("0")
+		}
+		cache.Put(strconv.Itoa(i), []byte("value"))
+	}
+
+	// "0" is overwritten despite being read
+
+	for i, want := range []bool{false, false, true, true, true, true, true} {
+		if _, ok := cache.Get(strconv.Itoa(i)); ok != want {
+			t.Errorf("cached %v %v, want %v", i, ok, want)
+		}
+	}
+
+	if stats := cache.Stats(); stats.Evictions != 2 {
+		t.Errorf("evictions %v, want %v", stats.Evictions, 2)
+	}
+}
+
+func TestCache_LRU(t *testing.T) {
+	cache, _ := bytecache.New(100, bytecache.WithShards(1), bytecache.WithPolicy(bytecache.LRU))
+
+	for i := 0; i < 7; i++ {
+		if i == 5 {
+			cache.Get("0")
+		}
+		cache.Put(strconv.Itoa(i), []byte("value"))
+	}
+
+	// "0" is moved to the head of the buffer when read
+
+	for i, want := range []bool{true, false, false, true, true, true, true} {
+		if _, ok := cache.Get(strconv.Itoa(i)); ok != want {
+			t.Errorf("cached %v %v, want %v", i, ok, want)
+		}
+	}
+}
+
+func TestCache_Random(t *testing.T) {
+	for _, policy := range []bytecache.Policy{bytecache.FIFO, bytecache.LRU} {
+		cache, _ := bytecache.New(4096, bytecache.WithShards(4), bytecache.WithPolicy(policy))
+		values := make(map[string][]byte)
+		r := rand.New(rand.NewSource(1))
+
+		for i := 0; i < 100_000; i++ {
+			key := strconv.Itoa(r.Intn(500))
+			switch r.Intn(4) {
+			case 0:
+				cache.Delete(key)
+				delete(values, key)
+			case 1:
+				value := make([]byte, r.Intn(64))
+				r.Read(value)
+				cache.Put(key, value)
+				values[key] = value
+			default:
+				value, ok := cache.Get(key)
+				if ok && !bytes.Equal(value, values[key]) {
+					t.Fatalf("cached value %v, want %v", value, values[key])
+				}
+			}
+		}
+
+		if cache.Len() == 0 || cache.Len() > len(values) {
+			t.Errorf("cache len %v, want <= %v", cache.Len(), len(values))
+		}
+	}
+}
+
+func TestCache_Race(t *testing.T) {
+	cache, _ := bytecache.New(1 << 16)
+	wg := &sync.WaitGroup{}
+
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				key := strconv.Itoa(j)
+				cache.Put(key, []byte(key))
+				if value, ok := cache.Get(key); ok && string(value) != key {
+					t.Errorf("cached value %s, want %v", value, key)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,440 @@
+// Package cluster shares a cache between nodes of a cluster, like groupcache does.
+//
+// Each key is owned by a single node chosen by a Picker, a consistent hash ring by default.
+// The owner loads missing values with the Loader and caches them,
+// other nodes fetch values from the owner over HTTP, so a value is loaded once per cluster.
+// Keys fetched from an owner often enough are replicated in a small hot cache of the node
+// for a limited time to spread the load of popular keys.
+// If the owner can't be reached, the value is loaded locally and kept as a hot replica,
+// while errors of the owner, e.g. of its Loader, are returned to the caller.
+//
+// Nodes serve each other under BasePath:
+//
+//	node, _ := cluster.New("http://10.0.0.1:8080", 100000, loader)
+//	node.SetPeers("http://10.0.0.1:8080", "http://10.0.0.2:8080", "http://10.0.0.3:8080")
+//	http.Handle(cluster.BasePath, node)
+package cluster
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/faroyam/caches/excache"
+	"github.com/faroyam/caches/lru"
+)
+
+// BasePath is the path under which nodes serve each other
+const BasePath = "/_cluster/"
+
+// ErrNotFound may be returned by Loader for a missing value, Get returns it on every node
+var ErrNotFound = errors.New("not found")
+
+// Loader loads the value of a key owned by the node
+type Loader func(ctx context.Context, key string) ([]byte, error)
+
+// Node represents a member of a cluster safe for concurrent use
+type Node struct {
+	self   string
+	loader Loader
+	client *http.Client
+
+	m      *sync.RWMutex
+	picker Picker
+
+	main   *lru.Cache
+	hot    *excache.Cache
+	counts *lru.Cache
+	flight *flight
+
+	virtualNodes int
+	newPicker    func(peers ...string) Picker
+	hotCapacity  int
+	hotTTL       time.Duration
+	hotThreshold int
+
+	stats Stats
+}
+
+// Stats represents counters of a node
+type Stats struct {
+	// Gets is the number of Get calls
+	Gets int64
+	// Hits is the number of Get calls served from the cache of owned keys
+	Hits int64
+	// HotHits is the number of Get calls served from the hot cache
+	HotHits int64
+	// Loads is the number of Loader calls
+	Loads int64
+	// PeerFetches is the number of values requested from owners
+	PeerFetches int64
+	// PeerErrors is the number of failed requests to owners
+	PeerErrors int64
+	// Served is the number of requests served to other nodes
+	Served int64
+}
+
+// Option configures a node instance
+type Option func(*Node)
+
+// WithVirtualNodes sets the number of virtual nodes placed on the ring for each node. 50 by default.
+func WithVirtualNodes(n int) Option {
+	return func(node *Node) {
+		node.virtualNodes = n
+	}
+}
+
+// WithPicker sets the function building a picker of the peers passed to SetPeers.
+// A ring with virtual nodes is used by default.
+// All nodes of a cluster must use the same picker.
+func WithPicker(newPicker func(peers ...string) Picker) Option {
+	return func(node *Node) {
+		node.newPicker = newPicker
+	}
+}
+
+// WithHotCache configures replication of hot keys: a key owned by another node
+// is replicated after being fetched threshold times and the replica expires after ttl, however often it is read.
+// The hot cache holds up to an eighth of the capacity by default,
+// keys are replicated after 2 fetches and expire after a minute.
+// Zero capacity disables replication.
+func WithHotCache(capacity int, ttl time.Duration, threshold int) Option {
+	return func(node *Node) {
+		node.hotCapacity = capacity
+		node.hotTTL = ttl
+		node.hotThreshold = threshold
+	}
+}
+
+// WithHTTPClient sets the client used to fetch values from other nodes. http.DefaultClient by default.
+// Fetches shared by concurrent calls are not canceled by contexts of the callers,
+// so the client should have a timeout.
+func WithHTTPClient(client *http.Client) Option {
+	return func(node *Node) {
+		node.client = client
+	}
+}
+
+// New returns a node identified by the base URL of its HTTP server,
+// caching up to capacity values of owned keys.
+// The node owns all keys until SetPeers is called.
+func New(self string, capacity int, loader Loader, options ...Option) (*Node, error) {
+	node := &Node{
+		self:         strings.TrimSuffix(self, "/"),
+		loader:       loader,
+		client:       http.DefaultClient,
+		m:            &sync.RWMutex{},
+		flight:       &flight{m: &sync.Mutex{}, calls: make(map[string]*call)},
+		virtualNodes: 50,
+		hotCapacity:  capacity / 8,
+		hotTTL:       time.Minute,
+		hotThreshold: 2,
+	}
+
+	for _, option := range options {
+		option(node)
+	}
+
+	if node.virtualNodes <= 0 {
+		return nil, fmt.Errorf("number of virtual nodes must be positive")
+	}
+	if node.hotCapacity < 0 {
+		return nil, fmt.Errorf("hot cache capacity can't be negative")
+	}
+	if node.hotCapacity > 0 && (node.hotTTL <= 0 || node.hotThreshold <= 0) {
+		return nil, fmt.Errorf("hot cache TTL and threshold must be positive")
+	}
+
+	var err error
+	if node.main, err = lru.New(capacity); err != nil {
+		return nil, err
+	}
+	if node.hotCapacity > 0 {
+		node.hot, _ = excache.New(node.hotCapacity)
+		// fetch counts of more keys than there are replicas are kept to tell hot keys apart
+		node.counts, _ = lru.New(4 * node.hotCapacity)
+	}
+
+	if node.newPicker == nil {
+		node.newPicker = func(peers ...string) Picker {
+			return NewRing(node.virtualNodes, peers...)
+		}
+	}
+
+	node.picker = node.newPicker(node.self)
+	return node, nil
+}
+
+// SetPeers replaces the members of the cluster, peers are base URLs of nodes including this one
+func (n *Node) SetPeers(peers ...string) {
+	trimmed := make([]string, len(peers))
+	for i, peer := range peers {
+		trimmed[i] = strings.TrimSuffix(peer, "/")
+	}
+	picker := n.newPicker(trimmed...)
+
+	n.m.Lock()
+	defer n.m.Unlock()
+
+	n.picker = picker
+}
+
+// Owner returns the node owning the key
+func (n *Node) Owner(key string) string {
+	n.m.RLock()
+	defer n.m.RUnlock()
+
+	return n.picker.Pick(key)
+}
+
+// Get returns the value of the key from the cache, the owner or the Loader.
+// The value is loaded locally only if the owner can't be reached,
+// an error returned by the owner is returned as is.
+// The returned value must not be modified.
+func (n *Node) Get(ctx context.Context, key string) ([]byte, error) {
+	atomic.AddInt64(&n.stats.Gets, 1)
+
+	if value, ok := n.main.Get(key); ok {
+		atomic.AddInt64(&n.stats.Hits, 1)
+		return value.([]byte), nil
+	}
+
+	if n.hot != nil {
+		// peeking keeps the TTL of the replica from being extended by reads
+		if value, ok := n.hot.Peek(key); ok {
+			atomic.AddInt64(&n.stats.HotHits, 1)
+			return value.([]byte), nil
+		}
+	}
+
+	owner := n.Owner(key)
+	if owner == n.self {
+		return n.load(ctx, key)
+	}
+
+	return n.flight.do(ctx, owner+"\x00"+key, func(ctx context.Context) ([]byte, error) {
+		value, err := n.fetch(ctx, owner, key)
+		if err != nil && !errors.Is(err, ErrNotFound) {
+			atomic.AddInt64(&n.stats.PeerErrors, 1)
+		}
+
+		var unreachable *unreachableError
+		if errors.As(err, &unreachable) {
+			return n.loadReplica(ctx, key)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if n.isHot(key) {
+			n.hot.Put(key, value, n.hotTTL)
+		}
+		return value, nil
+	})
+}
+
+// Stats returns the counters of the node
+func (n *Node) Stats() Stats {
+	return Stats{
+		Gets:        atomic.LoadInt64(&n.stats.Gets),
+		Hits:        atomic.LoadInt64(&n.stats.Hits),
+		HotHits:     atomic.LoadInt64(&n.stats.HotHits),
+		Loads:       atomic.LoadInt64(&n.stats.Loads),
+		PeerFetches: atomic.LoadInt64(&n.stats.PeerFetches),
+		PeerErrors:  atomic.LoadInt64(&n.stats.PeerErrors),
+		Served:      atomic.LoadInt64(&n.stats.Served),
+	}
+}
+
+// ServeHTTP serves values of owned keys to other nodes.
+// Keys are loaded even if the node doesn't consider itself the owner,
+// so that requests are never forwarded while peers disagree on membership.
+func (n *Node) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	escaped := r.URL.EscapedPath()
+	if !strings.HasPrefix(escaped, BasePath) {
+		http.NotFound(w, r)
+		return
+	}
+
+	key, err := url.PathUnescape(strings.TrimPrefix(escaped, BasePath))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	atomic.AddInt64(&n.stats.Served, 1)
+
+	value, err := n.load(r.Context(), key)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, ErrNotFound) {
+			status = http.StatusNotFound
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Write(value)
+}
+
+// load returns the cached value of an owned key or loads it once for concurrent calls
+func (n *Node) load(ctx context.Context, key string) ([]byte, error) {
+	if value, ok := n.main.Get(key); ok {
+		return value.([]byte), nil
+	}
+
+	return n.flight.do(ctx, key, func(ctx context.Context) ([]byte, error) {
+		// the value may have been cached by a flight completed after the check above
+		if value, ok := n.main.Get(key); ok {
+			return value.([]byte), nil
+		}
+
+		atomic.AddInt64(&n.stats.Loads, 1)
+		value, err := n.loader(ctx, key)
+		if err != nil {
+			return nil, err
+		}
+
+		n.main.Put(key, value)
+		return value, nil
+	})
+}
+
+// loadReplica loads the value of a key owned by an unreachable node keeping it as a hot replica
+func (n *Node) loadReplica(ctx context.Context, key string) ([]byte, error) {
+	atomic.AddInt64(&n.stats.Loads, 1)
+	value, err := n.loader(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	if n.hot != nil {
+		n.hot.Put(key, value, n.hotTTL)
+	}
+	return value, nil
+}
+
+func (n *Node) fetch(ctx context.Context, owner, key string) ([]byte, error) {
+	atomic.AddInt64(&n.stats.PeerFetches, 1)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, owner+BasePath+url.PathEscape(key), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return nil, &unreachableError{err: err}
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		value, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			return nil, &unreachableError{err: err}
+		}
+		return value, nil
+	case http.StatusNotFound:
+		return nil, ErrNotFound
+	default:
+		message, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, fmt.Errorf("owner %s: %s: %s", owner, resp.Status, strings.TrimSpace(string(message)))
+	}
+}
+
+// unreachableError represents a failure to get a response of the owner
+type unreachableError struct {
+	err error
+}
+
+func (e *unreachableError) Error() string { return e.err.Error() }
+
+func (e *unreachableError) Unwrap() error { return e.err }
+
+// isHot counts a fetch of the key and reports whether the key should be replicated
+func (n *Node) isHot(key string) bool {
+	if n.hot == nil {
+		return false
+	}
+
+	count, _ := n.counts.Compute(key, func(old interface{}, exists bool) (interface{}, bool) {
+		if !exists {
+			return 1, true
+		}
+		return old.(int) + 1, true
+	})
+	if count.(int) < n.hotThreshold {
+		return false
+	}
+
+	n.counts.Delete(key)
+	return true
+}
+
+// flight deduplicates concurrent calls for the same key
+type flight struct {
+	m     *sync.Mutex
+	calls map[string]*call
+}
+
+type call struct {
+	done  chan struct{}
+	value []byte
+	err   error
+}
+
+// do calls fn once for concurrent calls with the same key and shares its result.
+// fn runs with a context detached from the callers, so that a caller giving up doesn't fail the others,
+// while every caller stops waiting when its own ctx is done.
+func (f *flight) do(ctx context.Context, key string, fn func(ctx context.Context) ([]byte, error)) ([]byte, error) {
+	f.m.Lock()
+	c, ok := f.calls[key]
+	if !ok {
+		c = &call{done: make(chan struct{})}
+		f.calls[key] = c
+
+		go func() {
+			c.value, c.err = fn(detached{ctx})
+			close(c.done)
+
+			f.m.Lock()
+			delete(f.calls, key)
+			f.m.Unlock()
+		}()
+	}
+	f.m.Unlock()
+
+	select {
+	case <-c.done:
+		return c.value, c.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// detached keeps the values of a context dropping its deadline and cancellation
+type detached struct {
+	context.Context
+}
+
+func (detached) Deadline() (time.Time, bool) { return time.Time{}, false }
+
+func (detached) Done() <-chan struct{} { return nil }
+
+func (detached) Err() error { return nil }
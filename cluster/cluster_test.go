@@ -0,0 +1,313 @@
+package cluster_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/faroyam/caches/cluster"
+)
+
+var errLoad = errors.New("load failed")
+
+// loader counts loads of each key, doesn't find keys starting with "missing"
+// and fails for keys starting with "failing"
+type loader struct {
+	m     sync.Mutex
+	loads map[string]int
+	delay time.Duration
+}
+
+func (l *loader) load(_ context.Context, key string) ([]byte, error) {
+	time.Sleep(l.delay)
+
+	l.m.Lock()
+	defer l.m.Unlock()
+
+	l.loads[key]++
+	if len(key) >= 7 && key[:7] == "missing" {
+		return nil, cluster.ErrNotFound
+	}
+	if len(key) >= 7 && key[:7] == "failing" {
+		return nil, errLoad
+	}
+	return []byte("value of " + key), nil
+}
+
+func (l *loader) count(key string) int {
+	l.m.Lock()
+	defer l.m.Unlock()
+
+	return l.loads[key]
+}
+
+// newCluster starts n nodes on loopback HTTP servers sharing the loader
+func newCluster(t *testing.T, n int, l *loader, options ...cluster.Option) ([]*cluster.Node, []*httptest.Server) {
+	nodes := make([]*cluster.Node, n)
+	servers := make([]*httptest.Server, n)
+	peers := make([]string, n)
+
+	for i := range nodes {
+		i := i
+		servers[i] = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			nodes[i].ServeHTTP(w, r)
+		}))
+		t.Cleanup(servers[i].Close)
+		peers[i] = servers[i].URL
+
+		node, err := cluster.New(peers[i], 100, l.load, options...)
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		nodes[i] = node
+	}
+
+	for _, node := range nodes {
+		node.SetPeers(peers...)
+	}
+	return nodes, servers
+}
+
+func TestNew(t *testing.T) {
+	l := &loader{loads: make(map[string]int)}
+
+	if _, err := cluster.New("http://node", 0, l.load); err == nil {
+		t.Errorf("expected error")
+	}
+
+	if _, err := cluster.New("http://node", 10, l.load, cluster.WithVirtualNodes(0)); err == nil {
+		t.Errorf("expected error")
+	}
+
+	if _, err := cluster.New("http://node", 10, l.load, cluster.WithHotCache(-1, time.Second, 1)); err == nil {
+		t.Errorf("expected error")
+	}
+
+	if _, err := cluster.New("http://node", 10, l.load, cluster.WithHotCache(10, 0, 1)); err == nil {
+		t.Errorf("expected error")
+	}
+}
+
+func TestNode_Get(t *testing.T) {
+	l := &loader{loads: make(map[string]int)}
+	nodes, _ := newCluster(t, 3, l, cluster.WithHotCache(0, 0, 0))
+
+	ctx := context.Background()
+	for i := 0; i < 30; i++ {
+		key := "key" + strconv.Itoa(i)
+		for _, node := range nodes {
+			value, err := node.Get(ctx, key)
+			if err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+			if string(value) != "value of "+key {
+				t.Errorf("cached value %s, want %v", value, "value of "+key)
+			}
+		}
+
+		// every key is loaded once by its owner
+		if loads := l.count(key); loads != 1 {
+			t.Errorf("key %v is loaded %v times, want 1", key, loads)
+		}
+	}
+
+	var served, fetches int64
+	for _, node := range nodes {
+		stats := node.Stats()
+		served += stats.Served
+		fetches += stats.PeerFetches
+
+		if stats.Loads == 0 {
+			t.Errorf("node owns no keys")
+		}
+	}
+	if served != 60 || fetches != 60 {
+		t.Errorf("served %v, fetched %v, want %v", served, fetches, 60)
+	}
+}
+
+func TestNode_NotFound(t *testing.T) {
+	l := &loader{loads: make(map[string]int)}
+	nodes, _ := newCluster(t, 2, l)
+
+	for _, node := range nodes {
+		if _, err := node.Get(context.Background(), "missing"); !errors.Is(err, cluster.ErrNotFound) {
+			t.Errorf("error %v, want %v", err, cluster.ErrNotFound)
+		}
+	}
+}
+
+func TestNode_HotCache(t *testing.T) {
+	l := &loader{loads: make(map[string]int)}
+	nodes, servers := newCluster(t, 2, l, cluster.WithHotCache(10, time.Minute, 2))
+
+	// a key owned by the second node
+	key := "key"
+	for i := 0; nodes[0].Owner(key) != servers[1].URL; i++ {
+		key = "key" + strconv.Itoa(i)
+	}
+
+	for i := 0; i < 5; i++ {
+		if _, err := nodes[0].Get(context.Background(), key); err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+	}
+
+	// the key is replicated after the second fetch
+	if served := nodes[1].Stats().Served; served != 2 {
+		t.Errorf("owner served %v requests, want %v", served, 2)
+	}
+	if hits := nodes[0].Stats().HotHits; hits != 3 {
+		t.Errorf("hot hits %v, want %v", hits, 3)
+	}
+}
+
+func TestNode_HotCache_TTL(t *testing.T) {
+	l := &loader{loads: make(map[string]int)}
+	nodes, servers := newCluster(t, 2, l, cluster.WithHotCache(10, 200*time.Millisecond, 1))
+
+	// a key owned by the second node
+	key := "key"
+	for i := 0; nodes[0].Owner(key) != servers[1].URL; i++ {
+		key = "key" + strconv.Itoa(i)
+	}
+
+	// reads of the replica don't extend its TTL
+	for i := 0; i < 6; i++ {
+		if _, err := nodes[0].Get(context.Background(), key); err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	if served := nodes[1].Stats().Served; served != 2 {
+		t.Errorf("owner served %v requests, want %v", served, 2)
+	}
+}
+
+func TestNode_Get_Cancel(t *testing.T) {
+	l := &loader{loads: make(map[string]int), delay: 100 * time.Millisecond}
+	nodes, servers := newCluster(t, 2, l)
+
+	// a key owned by the second node
+	key := "key"
+	for i := 0; nodes[0].Owner(key) != servers[1].URL; i++ {
+		key = "key" + strconv.Itoa(i)
+	}
+
+	// the caller starting the fetch gives up, the one waiting for it gets the value
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	errs := make(chan error, 1)
+	go func() {
+		_, err := nodes[0].Get(ctx, key)
+		errs <- err
+	}()
+	time.Sleep(5 * time.Millisecond)
+
+	if value, err := nodes[0].Get(context.Background(), key); err != nil || string(value) != "value of "+key {
+		t.Errorf("cached value %s, %v, want %v", value, err, "value of "+key)
+	}
+	if err := <-errs; !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("error %v, want %v", err, context.DeadlineExceeded)
+	}
+	if stats := nodes[0].Stats(); stats.PeerFetches != 1 || stats.Loads != 0 {
+		t.Errorf("stats %+v", stats)
+	}
+}
+
+func TestNode_OwnerDown(t *testing.T) {
+	l := &loader{loads: make(map[string]int)}
+	nodes, servers := newCluster(t, 2, l)
+
+	// a key owned by the second node
+	key := "key"
+	for i := 0; nodes[0].Owner(key) != servers[1].URL; i++ {
+		key = "key" + strconv.Itoa(i)
+	}
+
+	servers[1].Close()
+
+	// the key is loaded locally and kept as a hot replica
+	for i := 0; i < 2; i++ {
+		if value, err := nodes[0].Get(context.Background(), key); err != nil || string(value) != "value of "+key {
+			t.Errorf("cached value %s, %v, want %v", value, err, "value of "+key)
+		}
+	}
+
+	if stats := nodes[0].Stats(); stats.PeerErrors != 1 || stats.Loads != 1 || stats.HotHits != 1 {
+		t.Errorf("stats %+v", stats)
+	}
+}
+
+func TestNode_OwnerError(t *testing.T) {
+	l := &loader{loads: make(map[string]int)}
+	nodes, servers := newCluster(t, 2, l)
+
+	// a key owned by the second node
+	key := "failing"
+	for i := 0; nodes[0].Owner(key) != servers[1].URL; i++ {
+		key = "failing" + strconv.Itoa(i)
+	}
+
+	// the error of the owner is returned instead of loading the key locally
+	if _, err := nodes[0].Get(context.Background(), key); err == nil {
+		t.Errorf("expected error")
+	}
+
+	if stats := nodes[0].Stats(); stats.PeerErrors != 1 || stats.Loads != 0 {
+		t.Errorf("stats %+v", stats)
+	}
+	if loads := l.count(key); loads != 1 {
+		t.Errorf("key %v is loaded %v times, want 1", key, loads)
+	}
+}
+
+func TestNode_Concurrent(t *testing.T) {
+	l := &loader{loads: make(map[string]int), delay: 20 * time.Millisecond}
+	nodes, _ := newCluster(t, 3, l)
+
+	var errs int64
+	wg := &sync.WaitGroup{}
+	for i := 0; i < 30; i++ {
+		wg.Add(1)
+		go func(node *cluster.Node) {
+			defer wg.Done()
+			if _, err := node.Get(context.Background(), "key"); err != nil {
+				atomic.AddInt64(&errs, 1)
+			}
+		}(nodes[i%len(nodes)])
+	}
+	wg.Wait()
+
+	if errs != 0 {
+		t.Errorf("%v gets failed", errs)
+	}
+
+	// concurrent gets share a single load
+	if loads := l.count("key"); loads != 1 {
+		t.Errorf("key is loaded %v times, want 1", loads)
+	}
+}
+
+func TestNode_ServeHTTP(t *testing.T) {
+	l := &loader{loads: make(map[string]int)}
+	_, servers := newCluster(t, 1, l)
+
+	resp, err := http.Post(servers[0].URL+cluster.BasePath+"key", "", nil)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("status %v, want %v", resp.StatusCode, http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,184 @@
+package cluster
+
+import (
+	"fmt"
+	"math"
+	"sort"
+)
+
+// Picker maps keys to nodes.
+// Nodes are arbitrary names, e.g. base URLs of peers or names of in-process shards.
+// Pickers are not safe for concurrent use while nodes are added or removed.
+type Picker interface {
+	// Pick returns the node owning the key or an empty string if there are no nodes
+	Pick(key string) string
+}
+
+// Rendezvous maps keys to nodes with weighted rendezvous hashing, also known as highest random weight hashing:
+// a key is owned by the node with the highest score computed from the hash of the key and the node.
+// Each node owns a share of keys proportional to its weight,
+// and only keys of a removed node or keys taken over by an added node move.
+// Pick takes O(n) time for n nodes.
+// The zero value is a picker without nodes ready to use.
+type Rendezvous struct {
+	nodes   []string
+	weights map[string]float64
+}
+
+// NewRendezvous returns a picker of the nodes with the weights, all weights must be positive
+func NewRendezvous(weights map[string]float64) (*Rendezvous, error) {
+	r := &Rendezvous{weights: make(map[string]float64, len(weights))}
+	for node, weight := range weights {
+		if err := r.Add(node, weight); err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+// Add adds the node with the weight or updates the weight of an existing node
+func (r *Rendezvous) Add(node string, weight float64) error {
+	if weight <= 0 || math.IsInf(weight, 0) || math.IsNaN(weight) {
+		return fmt.Errorf("weight of node %q must be positive", node)
+	}
+
+	if r.weights == nil {
+		r.weights = make(map[string]float64)
+	}
+	if _, ok := r.weights[node]; !ok {
+		r.nodes = append(r.nodes, node)
+		sort.Strings(r.nodes)
+	}
+	r.weights[node] = weight
+	return nil
+}
+
+// Remove removes the node
+func (r *Rendezvous) Remove(node string) {
+	if _, ok := r.weights[node]; !ok {
+		return
+	}
+
+	delete(r.weights, node)
+	i := sort.SearchStrings(r.nodes, node)
+	r.nodes = append(r.nodes[:i], r.nodes[i+1:]...)
+}
+
+// Pick returns the node with the highest score for the key
+func (r *Rendezvous) Pick(key string) string {
+	owner := ""
+	best := math.Inf(-1)
+
+	for _, node := range r.nodes {
+		// uniform in (0, 1)
+		u := (float64(hash(node+"\x00"+key)>>11) + 0.5) / (1 << 53)
+		// -w/ln(u) keeps the share of keys proportional to weights
+		score := -r.weights[node] / math.Log(u)
+		if score > best {
+			owner, best = node, score
+		}
+	}
+	return owner
+}
+
+// Jump maps keys to nodes with jump consistent hashing.
+// It needs no memory besides the list of nodes and spreads keys evenly,
+// but nodes are numbered buckets: appending a node moves 1/(n+1) of keys to it,
+// while removing a node replaces it with the last one,
+// so keys of the removed and the last nodes move, about 2/n of all keys.
+// Pick takes O(log n) time for n nodes.
+type Jump struct {
+	nodes []string
+}
+
+// NewJump returns a picker of the nodes
+func NewJump(nodes ...string) *Jump {
+	j := &Jump{}
+	j.Add(nodes...)
+	return j
+}
+
+// Add appends the nodes
+func (j *Jump) Add(nodes ...string) {
+	j.nodes = append(j.nodes, nodes...)
+}
+
+// Remove removes the node replacing it with the last one
+func (j *Jump) Remove(node string) {
+	for i, n := range j.nodes {
+		if n == node {
+			last := len(j.nodes) - 1
+			j.nodes[i] = j.nodes[last]
+			j.nodes = j.nodes[:last]
+			return
+		}
+	}
+}
+
+// Pick returns the node of the bucket of the key
+func (j *Jump) Pick(key string) string {
+	if len(j.nodes) == 0 {
+		return ""
+	}
+	return j.nodes[jump(hash(key), len(j.nodes))]
+}
+
+// jump returns the bucket of the key, see "A Fast, Minimal Memory, Consistent Hash Algorithm" by Lamping and Veach
+func jump(key uint64, buckets int) int {
+	b, j := int64(-1), int64(0)
+	for j < int64(buckets) {
+		b = j
+		key = key*2862933555777941757 + 1
+		j = int64(float64(b+1) * (float64(int64(1)<<31) / float64((key>>33)+1)))
+	}
+	return int(b)
+}
+
+// Shard represents an in-process cache routed to by Sharded, e.g. lru.Cache or lfu.Cache
+type Shard interface {
+	Get(key string) (interface{}, bool)
+	Put(key string, value interface{})
+	Delete(key string)
+}
+
+// Sharded routes keys to in-process caches named as nodes of a picker
+type Sharded struct {
+	picker Picker
+	shards map[string]Shard
+}
+
+// NewSharded returns a router of keys to the shards by the picker,
+// the picker must pick only names of the shards
+func NewSharded(picker Picker, shards map[string]Shard) *Sharded {
+	return &Sharded{picker: picker, shards: shards}
+}
+
+// Shard returns (shard, true) for the shard owning the key,
+// or (nil, false) if the picker has no nodes or picks a name without a shard
+func (s *Sharded) Shard(key string) (Shard, bool) {
+	shard, ok := s.shards[s.picker.Pick(key)]
+	return shard, ok
+}
+
+// Get returns the value of the key from its shard
+func (s *Sharded) Get(key string) (interface{}, bool) {
+	shard, ok := s.Shard(key)
+	if !ok {
+		return nil, false
+	}
+	return shard.Get(key)
+}
+
+// Put saves the value of the key in its shard, the value is dropped if there is no shard
+func (s *Sharded) Put(key string, value interface{}) {
+	if shard, ok := s.Shard(key); ok {
+		shard.Put(key, value)
+	}
+}
+
+// Delete removes the key from its shard
+func (s *Sharded) Delete(key string) {
+	if shard, ok := s.Shard(key); ok {
+		shard.Delete(key)
+	}
+}
@@ -0,0 +1,263 @@
+package cluster_test
+
+import (
+	"context"
+	"strconv"
+	"testing"
+
+	"github.com/faroyam/caches/cluster"
+	"github.com/faroyam/caches/lfu"
+	"github.com/faroyam/caches/lru"
+)
+
+const keys = 10000
+
+func owners(p cluster.Picker) map[string]string {
+	owners := make(map[string]string, keys)
+	for i := 0; i < keys; i++ {
+		key := "key" + strconv.Itoa(i)
+		owners[key] = p.Pick(key)
+	}
+	return owners
+}
+
+// moved returns the number of keys whose owners changed checking that only keys of the nodes moved
+func moved(t *testing.T, before, after map[string]string, nodes ...string) int {
+	t.Helper()
+
+	allowed := make(map[string]bool)
+	for _, node := range nodes {
+		allowed[node] = true
+	}
+
+	n := 0
+	for key, owner := range before {
+		if after[key] == owner {
+			continue
+		}
+		if !allowed[owner] && !allowed[after[key]] {
+			t.Fatalf("key %v moved from %v to %v", key, owner, after[key])
+		}
+		n++
+	}
+	return n
+}
+
+func shares(owners map[string]string) map[string]int {
+	shares := make(map[string]int)
+	for _, owner := range owners {
+		shares[owner]++
+	}
+	return shares
+}
+
+func TestRing_Remove(t *testing.T) {
+	r := cluster.NewRing(100, "node1", "node2", "node3", "node4")
+	before := owners(r)
+
+	r.Remove("node2")
+	after := owners(r)
+
+	if n := moved(t, before, after, "node2"); n != shares(before)["node2"] {
+		t.Errorf("%v keys moved, want %v", n, shares(before)["node2"])
+	}
+	if shares(after)["node2"] != 0 {
+		t.Errorf("removed node owns keys")
+	}
+}
+
+func TestRendezvous(t *testing.T) {
+	if _, err := cluster.NewRendezvous(map[string]float64{"node1": 0}); err == nil {
+		t.Errorf("expected error")
+	}
+
+	r, _ := cluster.NewRendezvous(map[string]float64{})
+	if owner := r.Pick("key"); owner != "" {
+		t.Errorf("owner %q without nodes, want %q", owner, "")
+	}
+
+	r, _ = cluster.NewRendezvous(map[string]float64{"node1": 1, "node2": 1, "node3": 1, "node4": 1})
+	before := owners(r)
+	for node, share := range shares(before) {
+		if share < 2000 || share > 3000 {
+			t.Errorf("node %v owns %v of %v keys", node, share, keys)
+		}
+	}
+
+	// added nodes take over about 1/(n+1) of keys
+	r.Add("node5", 1)
+	after := owners(r)
+	if n := moved(t, before, after, "node5"); n != shares(after)["node5"] || n < 1500 || n > 2500 {
+		t.Errorf("%v keys moved, node5 owns %v", n, shares(after)["node5"])
+	}
+
+	// only keys of removed nodes move
+	r.Remove("node2")
+	before, after = after, owners(r)
+	if n := moved(t, before, after, "node2"); n != shares(before)["node2"] {
+		t.Errorf("%v keys moved, want %v", n, shares(before)["node2"])
+	}
+}
+
+func TestRendezvous_Weights(t *testing.T) {
+	r, _ := cluster.NewRendezvous(map[string]float64{"small": 1, "medium": 2, "large": 5})
+	before := owners(r)
+
+	s := shares(before)
+	if s["small"] < 1000 || s["small"] > 1500 || s["medium"] < 2200 || s["medium"] > 2800 || s["large"] < 5900 || s["large"] > 6600 {
+		t.Errorf("shares %v are not proportional to weights 1, 2, 5", s)
+	}
+
+	// increasing a weight moves keys only to the node
+	r.Add("small", 3)
+	after := owners(r)
+	for key, owner := range after {
+		if owner != before[key] && owner != "small" {
+			t.Fatalf("key %v moved from %v to %v", key, before[key], owner)
+		}
+	}
+}
+
+func TestJump(t *testing.T) {
+	if owner := cluster.NewJump().Pick("key"); owner != "" {
+		t.Errorf("owner %q without nodes, want %q", owner, "")
+	}
+
+	j := cluster.NewJump("node1", "node2", "node3", "node4")
+	before := owners(j)
+	for node, share := range shares(before) {
+		if share < 2200 || share > 2800 {
+			t.Errorf("node %v owns %v of %v keys", node, share, keys)
+		}
+	}
+
+	// appended nodes take over 1/(n+1) of keys
+	j.Add("node5")
+	after := owners(j)
+	if n := moved(t, before, after, "node5"); n != shares(after)["node5"] || n < 1700 || n > 2300 {
+		t.Errorf("%v keys moved, node5 owns %v", n, shares(after)["node5"])
+	}
+
+	// removing the last node moves only its keys
+	j.Remove("node5")
+	if n := moved(t, after, owners(j), "node5"); n != shares(after)["node5"] {
+		t.Errorf("%v keys moved, want %v", n, shares(after)["node5"])
+	}
+
+	// removing another node moves keys of the node and the last node replacing it
+	before = owners(j)
+	j.Remove("node2")
+	after = owners(j)
+	if n := moved(t, before, after, "node2", "node4"); n > shares(before)["node2"]+shares(before)["node4"] {
+		t.Errorf("%v keys moved", n)
+	}
+	if shares(after)["node2"] != 0 {
+		t.Errorf("removed node owns keys")
+	}
+}
+
+func TestSharded(t *testing.T) {
+	shards := make(map[string]cluster.Shard)
+	for _, name := range []string{"lru1", "lru2"} {
+		shards[name], _ = lru.New(100)
+	}
+	shards["lfu"], _ = lfu.New(100)
+
+	r, _ := cluster.NewRendezvous(map[string]float64{"lru1": 1, "lru2": 1, "lfu": 1})
+	s := cluster.NewSharded(r, shards)
+
+	for i := 0; i < 30; i++ {
+		s.Put("key"+strconv.Itoa(i), i)
+	}
+
+	for i := 0; i < 30; i++ {
+		key := "key" + strconv.Itoa(i)
+		if value, ok := s.Get(key); !ok || value != i {
+			t.Errorf("cached value %v, want %v", value, i)
+		}
+
+		// keys are kept only by their shards
+		for name, shard := range shards {
+			if _, ok := shard.Get(key); ok != (name == r.Pick(key)) {
+				t.Errorf("key %v is in shard %v, owner %v", key, name, r.Pick(key))
+			}
+		}
+	}
+
+	s.Delete("key1")
+	if _, ok := s.Get("key1"); ok {
+		t.Errorf("deleted key is found")
+	}
+
+	// a zero picker has no nodes and routes keys nowhere
+	s = cluster.NewSharded(&cluster.Rendezvous{}, shards)
+	s.Put("key", 1)
+	if shard, ok := s.Shard("key"); ok {
+		t.Errorf("shard %v, want %v", shard, nil)
+	}
+	if value, ok := s.Get("key"); ok {
+		t.Errorf("cached value %v, want %v", value, nil)
+	}
+}
+
+func TestRendezvous_Zero(t *testing.T) {
+	var r cluster.Rendezvous
+	if node := r.Pick("key"); node != "" {
+		t.Errorf("node %v, want %v", node, "")
+	}
+
+	if err := r.Add("node", 1); err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+	r.Remove("other")
+	if node := r.Pick("key"); node != "node" {
+		t.Errorf("node %v, want %v", node, "node")
+	}
+}
+
+func TestNode_WithPicker(t *testing.T) {
+	for name, newPicker := range map[string]func(peers ...string) cluster.Picker{
+		"rendezvous": func(peers ...string) cluster.Picker {
+			weights := make(map[string]float64)
+			for _, peer := range peers {
+				weights[peer] = 1
+			}
+			r, _ := cluster.NewRendezvous(weights)
+			return r
+		},
+		"jump": func(peers ...string) cluster.Picker {
+			return cluster.NewJump(peers...)
+		},
+	} {
+		l := &loader{loads: make(map[string]int)}
+		nodes, servers := newCluster(t, 3, l, cluster.WithPicker(newPicker))
+
+		for i := 0; i < 30; i++ {
+			key := "key" + strconv.Itoa(i)
+			for _, node := range nodes {
+				if node.Owner(key) != nodes[0].Owner(key) {
+					t.Fatalf("%s: nodes disagree on the owner of %v", name, key)
+				}
+				if _, err := node.Get(context.Background(), key); err != nil {
+					t.Fatalf("%s: unexpected error %v", name, err)
+				}
+			}
+
+			if loads := l.count(key); loads != 1 {
+				t.Errorf("%s: key %v is loaded %v times, want 1", name, key, loads)
+			}
+		}
+
+		owned := 0
+		for _, server := range servers {
+			for i := 0; i < 30; i++ {
+				if nodes[0].Owner("key"+strconv.Itoa(i)) == server.URL {
+					owned++
+				}
+			}
+		}
+		if owned != 30 {
+			t.Errorf("%s: %v keys are owned by peers, want 30", name, owned)
+		}
+	}
+}
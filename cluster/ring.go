@@ -0,0 +1,90 @@
+package cluster
+
+import (
+	"hash/fnv"
+	"sort"
+	"strconv"
+)
+
+// Ring maps keys to nodes with consistent hashing.
+// Each node is placed on the ring at several points, called virtual nodes,
+// so that keys are spread evenly and only about 1/n of them move when a node is added or removed.
+type Ring struct {
+	virtualNodes int
+	hashes       []uint64
+	nodes        map[uint64]string
+}
+
+// NewRing returns a ring placing each of the nodes at virtualNodes points
+func NewRing(virtualNodes int, nodes ...string) *Ring {
+	r := &Ring{
+		virtualNodes: virtualNodes,
+		nodes:        make(map[uint64]string),
+	}
+	r.Add(nodes...)
+	return r
+}
+
+// Add places the nodes on the ring
+func (r *Ring) Add(nodes ...string) {
+	for _, node := range nodes {
+		for i := 0; i < r.virtualNodes; i++ {
+			h := hash(strconv.Itoa(i) + node)
+			if _, ok := r.nodes[h]; ok {
+				// the point is taken by another virtual node
+				continue
+			}
+			r.nodes[h] = node
+			r.hashes = append(r.hashes, h)
+		}
+	}
+
+	sort.Slice(r.hashes, func(i, j int) bool { return r.hashes[i] < r.hashes[j] })
+}
+
+// Remove removes the nodes from the ring
+func (r *Ring) Remove(nodes ...string) {
+	removed := make(map[string]bool, len(nodes))
+	for _, node := range nodes {
+		removed[node] = true
+	}
+
+	hashes := r.hashes[:0]
+	for _, h := range r.hashes {
+		if removed[r.nodes[h]] {
+			delete(r.nodes, h)
+			continue
+		}
+		hashes = append(hashes, h)
+	}
+	r.hashes = hashes
+}
+
+// Pick returns the node owning the key or an empty string if the ring is empty
+func (r *Ring) Pick(key string) string {
+	if len(r.hashes) == 0 {
+		return ""
+	}
+
+	h := hash(key)
+	i := sort.Search(len(r.hashes), func(i int) bool { return r.hashes[i] >= h })
+	if i == len(r.hashes) {
+		i = 0
+	}
+	return r.nodes[r.hashes[i]]
+}
+
+// hash returns FNV-1a hash of the string passed through the MurmurHash3 finalizer,
+// which spreads hashes of similar strings such as node addresses differing in a port
+func hash(s string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(s))
+
+	x := h.Sum64()
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return x
+}
@@ -0,0 +1,61 @@
+package cluster_test
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/faroyam/caches/cluster"
+)
+
+func TestRing(t *testing.T) {
+	if owner := cluster.NewRing(10).Pick("key"); owner != "" {
+		t.Errorf("owner %q of an empty ring, want %q", owner, "")
+	}
+
+	nodes := []string{"node1", "node2", "node3", "node4"}
+	r := cluster.NewRing(100, nodes...)
+
+	counts := make(map[string]int)
+	for i := 0; i < 10000; i++ {
+		key := "key" + strconv.Itoa(i)
+		owner := r.Pick(key)
+		if r.Pick(key) != owner {
+			t.Fatalf("owner of %v is not stable", key)
+		}
+		counts[owner]++
+	}
+
+	// virtual nodes spread keys evenly
+	for _, node := range nodes {
+		if counts[node] < 1500 || counts[node] > 3500 {
+			t.Errorf("node %v owns %v of 10000 keys", node, counts[node])
+		}
+	}
+}
+
+func TestRing_Add(t *testing.T) {
+	r := cluster.NewRing(100, "node1", "node2", "node3", "node4")
+
+	owners := make(map[string]string)
+	for i := 0; i < 10000; i++ {
+		key := "key" + strconv.Itoa(i)
+		owners[key] = r.Pick(key)
+	}
+
+	r.Add("node5")
+
+	// only keys taken over by the new node move
+	moved := 0
+	for key, owner := range owners {
+		if newOwner := r.Pick(key); newOwner != owner {
+			if newOwner != "node5" {
+				t.Fatalf("key %v moved from %v to %v", key, owner, newOwner)
+			}
+			moved++
+		}
+	}
+
+	if moved < 1000 || moved > 3000 {
+		t.Errorf("%v of 10000 keys moved, want about 2000", moved)
+	}
+}
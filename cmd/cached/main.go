@@ -0,0 +1,92 @@
+// Command cached serves a cache over HTTP, the memcached text protocol, RESP or gRPC,
+// see packages server/http, server/memcache, server/resp and server/grpc for the APIs.
+// RESP keys with TTL are kept in a separate expiring cache of the same capacity.
+//
+// Usage:
+//
+//	cached -addr :8080 -policy lru -capacity 100000
+//	cached -addr :8080 -policy expiring -capacity 100000 -ttl 5m
+//	cached -addr :11211 -protocol memcache -policy expiring -capacity 100000
+//	cached -addr :6379 -protocol resp -policy lfu -capacity 100000
+//	cached -addr :9090 -protocol grpc -policy lru -capacity 100000
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/faroyam/caches/server"
+	"github.com/faroyam/caches/server/grpc"
+	"github.com/faroyam/caches/server/http"
+	"github.com/faroyam/caches/server/memcache"
+	"github.com/faroyam/caches/server/resp"
+)
+
+type srv interface {
+	ListenAndServe(addr string) error
+	Shutdown(ctx context.Context) error
+}
+
+func main() {
+	addr := flag.String("addr", ":8080", "address to listen on")
+	protocol := flag.String("protocol", "http", "protocol to serve: http, memcache, resp or grpc")
+	policy := flag.String("policy", "lru", "eviction policy: lru, lfu or expiring")
+	capacity := flag.Int("capacity", 100_000, "maximum number of records")
+	ttl := flag.Duration("ttl", 10*time.Minute, "default TTL of records of the expiring cache")
+	maxKeySize := flag.Int("max-key-size", 250, "maximum size of keys in bytes")
+	maxValueSize := flag.Int64("max-value-size", 1<<20, "maximum size of values in bytes")
+	shutdownTimeout := flag.Duration("shutdown-timeout", 10*time.Second, "time to wait for active requests on shutdown")
+	flag.Parse()
+
+	cache, err := server.New(*policy, *capacity, *ttl)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var s srv
+	switch *protocol {
+	case "http":
+		s, err = http.New(cache, http.WithMaxKeySize(*maxKeySize), http.WithMaxValueSize(*maxValueSize))
+	case "memcache":
+		s, err = memcache.New(cache, memcache.WithMaxKeySize(*maxKeySize), memcache.WithMaxValueSize(int(*maxValueSize)))
+	case "resp":
+		var volatile server.Cache
+		if volatile, err = server.New("expiring", *capacity, *ttl); err == nil {
+			s, err = resp.New(cache, volatile, resp.WithMaxValueSize(int(*maxValueSize)))
+		}
+	case "grpc":
+		s, err = grpc.New(cache, grpc.WithMaxKeySize(*maxKeySize))
+	default:
+		log.Fatalf("unknown protocol %q", *protocol)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+		<-signals
+
+		ctx, cancel := context.WithTimeout(context.Background(), *shutdownTimeout)
+		defer cancel()
+
+		if err := s.Shutdown(ctx); err != nil {
+			log.Printf("shutdown: %v", err)
+		}
+	}()
+
+	log.Printf("serving %s cache over %s on %s", *policy, *protocol, *addr)
+	if err := s.ListenAndServe(*addr); err != nil {
+		log.Fatal(err)
+	}
+	<-done
+}
@@ -0,0 +1,135 @@
+// Command cachesim replays access traces through cache policies at several capacities
+// and reports hit ratios, byte hit ratios and evictions to compare the policies.
+//
+// Every request is a Get followed by a Put on a miss. Capacities are numbers of records,
+// sizes of requests only weigh byte hit ratios. Timestamps of traces are ignored,
+// so records of the expiring cache expire after the TTL of wall-clock time.
+//
+// Only the policies of caches with capacities in records are simulated: lru, lfu and expiring.
+// A namespace cache replaying a trace without namespaces is an LRU cache, bytecache is sized in bytes
+// of its buffers rather than in records, and tiered caches and servers wrap the caches above.
+//
+// Trace formats:
+//
+//	plain  a key per line optionally followed by its size in bytes, e.g. block numbers of the LIRS traces
+//	arc    "start count ignored request" lines of the ARC traces, count requests of 512-byte blocks
+//	csv    key and size columns of CSV traces, e.g. Wikipedia or CDN request logs
+//
+// Usage:
+//
+//	cachesim -trace P8.lis -format arc -policies lru,lfu -capacities 1000,10000,100000
+//	cachesim -trace wiki.tr -format csv -csv-comma ' ' -csv-key 2 -csv-size 3 -out results.csv
+//	zcat trace.gz | cachesim -trace - -capacities 1000,5000
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+func main() {
+	trace := flag.String("trace", "-", "trace file, - reads the standard input")
+	format := flag.String("format", "plain", "trace format: plain, arc or csv")
+	policies := flag.String("policies", "lru,lfu", "comma-separated eviction policies: lru, lfu or expiring")
+	capacities := flag.String("capacities", "1000,10000,100000", "comma-separated capacities in records")
+	ttl := flag.Duration("ttl", time.Hour, "TTL of records of the expiring cache")
+	limit := flag.Int64("limit", 0, "maximum number of requests to replay, 0 replays the whole trace")
+	csvComma := flag.String("csv-comma", ",", "separator of csv columns")
+	csvKey := flag.Int("csv-key", 2, "1-based number of the key column of csv traces")
+	csvSize := flag.Int("csv-size", 3, "1-based number of the size column of csv traces, 0 if there is none")
+	out := flag.String("out", "", "file to export results to as CSV")
+	flag.Parse()
+
+	comma, n := utf8.DecodeRuneInString(*csvComma)
+	if n == 0 || n != len(*csvComma) {
+		log.Fatalf("csv separator must be a single character")
+	}
+
+	sims, err := newSimulations(split(*policies), split(*capacities), *ttl)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var r io.Reader = os.Stdin
+	if *trace != "-" {
+		f, err := os.Open(*trace)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer f.Close()
+		r = f
+	}
+
+	skipped, err := readTrace(r, *format, csvFormat{comma: comma, key: *csvKey, size: *csvSize}, limited(*limit, func(key string, size int64) error {
+		for _, s := range sims {
+			s.access(key, size)
+		}
+		return nil
+	}))
+	if err != nil {
+		log.Fatal(err)
+	}
+	if skipped > 0 {
+		log.Printf("skipped %d malformed lines", skipped)
+	}
+
+	if err := report(os.Stdout, sims); err != nil {
+		log.Fatal(err)
+	}
+
+	if *out != "" {
+		f, err := os.Create(*out)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := export(f, sims); err != nil {
+			f.Close()
+			log.Fatal(err)
+		}
+		if err := f.Close(); err != nil {
+			log.Fatal(err)
+		}
+	}
+}
+
+// newSimulations returns a simulation for every pair of a policy and a capacity
+func newSimulations(policies, capacities []string, ttl time.Duration) ([]*simulation, error) {
+	if len(policies) == 0 || len(capacities) == 0 {
+		return nil, fmt.Errorf("no policies or capacities to simulate")
+	}
+
+	var sims []*simulation
+	for _, policy := range policies {
+		for _, c := range capacities {
+			capacity, err := strconv.Atoi(c)
+			if err != nil {
+				return nil, fmt.Errorf("invalid capacity %q", c)
+			}
+
+			s, err := newSimulation(policy, capacity, ttl)
+			if err != nil {
+				return nil, err
+			}
+			sims = append(sims, s)
+		}
+	}
+	return sims, nil
+}
+
+// split splits a comma-separated list dropping empty items
+func split(list string) []string {
+	var items []string
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/faroyam/caches/excache"
+	"github.com/faroyam/caches/lfu"
+	"github.com/faroyam/caches/lru"
+)
+
+// cache represents the methods of caches used by simulations
+type cache interface {
+	Get(key string) (interface{}, bool)
+	Put(key string, value interface{})
+}
+
+// expiring adapts excache.Cache to cache putting records with the same TTL
+type expiring struct {
+	c   *excache.Cache
+	ttl time.Duration
+}
+
+func (e expiring) Get(key string) (interface{}, bool) {
+	return e.c.Get(key)
+}
+
+func (e expiring) Put(key string, value interface{}) {
+	e.c.Put(key, value, e.ttl)
+}
+
+// simulation replays requests through a cache counting hits and evictions
+type simulation struct {
+	policy   string
+	capacity int
+	cache    cache
+
+	requests  int64
+	hits      int64
+	bytes     int64
+	hitBytes  int64
+	evictions int64
+}
+
+func newSimulation(policy string, capacity int, ttl time.Duration) (*simulation, error) {
+	s := &simulation{policy: policy, capacity: capacity}
+	evicted := func(string, interface{}) {
+		s.evictions++
+	}
+
+	switch policy {
+	case "lru":
+		c, err := lru.New(capacity, lru.WithEvictionHook(evicted))
+		if err != nil {
+			return nil, err
+		}
+		s.cache = c
+	case "lfu":
+		c, err := lfu.New(capacity, lfu.WithEvictionHook(evicted))
+		if err != nil {
+			return nil, err
+		}
+		s.cache = c
+	case "expiring":
+		c, err := excache.New(capacity, excache.WithEvictionHook(evicted))
+		if err != nil {
+			return nil, err
+		}
+		s.cache = expiring{c: c, ttl: ttl}
+	default:
+		return nil, fmt.Errorf("unknown policy %q", policy)
+	}
+	return s, nil
+}
+
+// access requests the key putting it into the cache on a miss
+func (s *simulation) access(key string, size int64) {
+	s.requests++
+	s.bytes += size
+
+	if _, ok := s.cache.Get(key); ok {
+		s.hits++
+		s.hitBytes += size
+		return
+	}
+	s.cache.Put(key, size)
+}
+
+func (s *simulation) hitRatio() float64 {
+	return ratio(s.hits, s.requests)
+}
+
+func (s *simulation) byteHitRatio() float64 {
+	return ratio(s.hitBytes, s.bytes)
+}
+
+func ratio(a, b int64) float64 {
+	if b == 0 {
+		return 0
+	}
+	return float64(a) / float64(b)
+}
+
+// report prints the results as a table
+func report(w io.Writer, sims []*simulation) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "policy\tcapacity\trequests\thits\thit ratio\tbyte hit ratio\tevictions\t")
+	for _, s := range sims {
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t%.4f\t%.4f\t%d\t\n",
+			s.policy, s.capacity, s.requests, s.hits, s.hitRatio(), s.byteHitRatio(), s.evictions)
+	}
+	return tw.Flush()
+}
+
+// export writes the results as CSV with a header
+func export(w io.Writer, sims []*simulation) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"policy", "capacity", "requests", "hits", "hit_ratio", "bytes", "hit_bytes", "byte_hit_ratio", "evictions"})
+	for _, s := range sims {
+		cw.Write([]string{
+			s.policy,
+			strconv.Itoa(s.capacity),
+			strconv.FormatInt(s.requests, 10),
+			strconv.FormatInt(s.hits, 10),
+			strconv.FormatFloat(s.hitRatio(), 'f', 6, 64),
+			strconv.FormatInt(s.bytes, 10),
+			strconv.FormatInt(s.hitBytes, 10),
+			strconv.FormatFloat(s.byteHitRatio(), 'f', 6, 64),
+			strconv.FormatInt(s.evictions, 10),
+		})
+	}
+	cw.Flush()
+	return cw.Error()
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSimulation_Access(t *testing.T) {
+	trace := []request{{"a", 10}, {"b", 20}, {"a", 10}, {"c", 30}, {"b", 20}, {"a", 10}}
+
+	for _, tt := range []struct {
+		policy    string
+		hits      int64
+		hitBytes  int64
+		evictions int64
+	}{
+		// c evicts b, b evicts a, a evicts c
+		{policy: "lru", hits: 1, hitBytes: 10, evictions: 3},
+		// c evicts b used once, b evicts c used once, a used twice stays
+		{policy: "lfu", hits: 2, hitBytes: 20, evictions: 2},
+		// Get resets the TTL, so records expire in the order of use and are evicted like lru
+		{policy: "expiring", hits: 1, hitBytes: 10, evictions: 3},
+	} {
+		s, err := newSimulation(tt.policy, 2, time.Hour)
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+
+		for _, r := range trace {
+			s.access(r.key, r.size)
+		}
+
+		if s.requests != 6 || s.bytes != 100 {
+			t.Errorf("%v: requests %v, bytes %v, want %v, %v", tt.policy, s.requests, s.bytes, 6, 100)
+		}
+		if s.hits != tt.hits || s.hitBytes != tt.hitBytes || s.evictions != tt.evictions {
+			t.Errorf("%v: hits %v, hit bytes %v, evictions %v, want %v, %v, %v",
+				tt.policy, s.hits, s.hitBytes, s.evictions, tt.hits, tt.hitBytes, tt.evictions)
+		}
+	}
+}
+
+func TestNewSimulations(t *testing.T) {
+	sims, err := newSimulations(split("lru, lfu,"), split("10,100"), time.Hour)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if len(sims) != 4 || sims[1].policy != "lru" || sims[1].capacity != 100 {
+		t.Errorf("simulations %+v", sims)
+	}
+
+	if _, err := newSimulations(split("lru"), split("x"), time.Hour); err == nil {
+		t.Errorf("expected error")
+	}
+	if _, err := newSimulations(split("arc"), split("10"), time.Hour); err == nil {
+		t.Errorf("expected error")
+	}
+	if _, err := newSimulations(nil, split("10"), time.Hour); err == nil {
+		t.Errorf("expected error")
+	}
+}
+
+func TestExport(t *testing.T) {
+	sims := []*simulation{
+		{policy: "lru", capacity: 2, requests: 4, hits: 1, bytes: 100, hitBytes: 25, evictions: 1},
+		{policy: "lfu", capacity: 10},
+	}
+
+	var buf bytes.Buffer
+	if err := export(&buf, sims); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	want := strings.Join([]string{
+		"policy,capacity,requests,hits,hit_ratio,bytes,hit_bytes,byte_hit_ratio,evictions",
+		"lru,2,4,1,0.250000,100,25,0.250000,1",
+		"lfu,10,0,0,0.000000,0,0,0.000000,0",
+	}, "\n") + "\n"
+	if buf.String() != want {
+		t.Errorf("exported %q, want %q", buf.String(), want)
+	}
+}
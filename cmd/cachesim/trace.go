@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// blockSize is the size of blocks of ARC traces in bytes
+const blockSize = 512
+
+// maxARCCount is the maximum number of blocks of an ARC line, lines requesting more are skipped as malformed
+const maxARCCount = 1 << 16
+
+// errStop stops reading a trace once the limit of requests is reached
+var errStop = errors.New("stop")
+
+// csvFormat configures the columns of CSV traces
+type csvFormat struct {
+	comma rune
+	// key and size are 1-based column numbers, size 0 means that requests have no sizes
+	key  int
+	size int
+}
+
+// readTrace calls visit for every request of the trace.
+// Requests without sizes have size 1. Lines that can't be parsed are skipped and counted.
+func readTrace(r io.Reader, format string, c csvFormat, visit func(key string, size int64) error) (int, error) {
+	var (
+		skipped int
+		err     error
+	)
+	switch format {
+	case "plain":
+		skipped, err = readLines(r, func(line string) (bool, error) { return readPlain(line, visit) })
+	case "arc":
+		skipped, err = readLines(r, func(line string) (bool, error) { return readARC(line, visit) })
+	case "csv":
+		skipped, err = readCSV(r, c, visit)
+	default:
+		return 0, fmt.Errorf("unknown trace format %q", format)
+	}
+	if errors.Is(err, errStop) {
+		err = nil
+	}
+	return skipped, err
+}
+
+// limited returns visit stopping the trace after limit requests, limit 0 means no limit
+func limited(limit int64, visit func(key string, size int64) error) func(key string, size int64) error {
+	var requests int64
+	return func(key string, size int64) error {
+		if limit > 0 && requests >= limit {
+			return errStop
+		}
+		requests++
+		return visit(key, size)
+	}
+}
+
+// readLines calls read for every line except blank lines and comments starting with #
+func readLines(r io.Reader, read func(line string) (bool, error)) (int, error) {
+	skipped := 0
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		ok, err := read(line)
+		if err != nil {
+			return skipped, err
+		}
+		if !ok {
+			skipped++
+		}
+	}
+	return skipped, scanner.Err()
+}
+
+// readPlain reads a key optionally followed by its size, e.g. "key 1024"
+func readPlain(line string, visit func(key string, size int64) error) (bool, error) {
+	fields := strings.Fields(line)
+	size := int64(1)
+	if len(fields) > 1 {
+		var err error
+		if size, err = strconv.ParseInt(fields[1], 10, 64); err != nil || size < 0 {
+			return false, nil
+		}
+	}
+	return true, visit(fields[0], size)
+}
+
+// readARC reads a line of the ARC trace format "start count ignored request",
+// which stands for count requests of consecutive blocks starting at start
+func readARC(line string, visit func(key string, size int64) error) (bool, error) {
+	fields := strings.Fields(line)
+	if len(fields) < 2 {
+		return false, nil
+	}
+
+	start, err := strconv.ParseUint(fields[0], 10, 64)
+	if err != nil {
+		return false, nil
+	}
+	count, err := strconv.ParseUint(fields[1], 10, 64)
+	if err != nil || count > maxARCCount || start+count < start {
+		return false, nil
+	}
+
+	for i := uint64(0); i < count; i++ {
+		if err := visit(strconv.FormatUint(start+i, 10), blockSize); err != nil {
+			return true, err
+		}
+	}
+	return true, nil
+}
+
+// readCSV reads the key and the size columns of CSV traces such as Wikipedia or CDN request logs.
+// A header is skipped as a line with a malformed size.
+func readCSV(r io.Reader, c csvFormat, visit func(key string, size int64) error) (int, error) {
+	if c.key <= 0 || c.size < 0 {
+		return 0, fmt.Errorf("csv columns must be positive")
+	}
+
+	reader := csv.NewReader(r)
+	reader.Comma = c.comma
+	reader.Comment = '#'
+	reader.FieldsPerRecord = -1
+	reader.LazyQuotes = true
+	reader.ReuseRecord = true
+
+	skipped := 0
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return skipped, nil
+		}
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			skipped++
+			continue
+		}
+		if err != nil {
+			return skipped, err
+		}
+
+		if len(record) < c.key || len(record) < c.size {
+			skipped++
+			continue
+		}
+
+		size := int64(1)
+		if c.size > 0 {
+			if size, err = strconv.ParseInt(strings.TrimSpace(record[c.size-1]), 10, 64); err != nil || size < 0 {
+				skipped++
+				continue
+			}
+		}
+
+		if err := visit(record[c.key-1], size); err != nil {
+			return skipped, err
+		}
+	}
+}
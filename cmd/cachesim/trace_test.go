@@ -0,0 +1,99 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type request struct {
+	key  string
+	size int64
+}
+
+// read returns the requests of the trace and the number of skipped lines
+func read(t *testing.T, trace, format string, c csvFormat, limit int64) ([]request, int) {
+	t.Helper()
+
+	var requests []request
+	skipped, err := readTrace(strings.NewReader(trace), format, c, limited(limit, func(key string, size int64) error {
+		requests = append(requests, request{key, size})
+		return nil
+	}))
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	return requests, skipped
+}
+
+func TestReadTrace_Plain(t *testing.T) {
+	trace := "a\n\n# comment\nb 10\nc x\nd -1\n  a  \n"
+
+	requests, skipped := read(t, trace, "plain", csvFormat{}, 0)
+	if want := []request{{"a", 1}, {"b", 10}, {"a", 1}}; !reflect.DeepEqual(requests, want) {
+		t.Errorf("requests %v, want %v", requests, want)
+	}
+	if skipped != 2 {
+		t.Errorf("skipped %v, want %v", skipped, 2)
+	}
+}
+
+func TestReadTrace_ARC(t *testing.T) {
+	trace := "10 3 0 1\n20 1\nx 1 0 1\n30\n40 18446744073709551615 0 1\n18446744073709551615 2 0 1\n"
+
+	requests, skipped := read(t, trace, "arc", csvFormat{}, 0)
+	want := []request{{"10", blockSize}, {"11", blockSize}, {"12", blockSize}, {"20", blockSize}}
+	if !reflect.DeepEqual(requests, want) {
+		t.Errorf("requests %v, want %v", requests, want)
+	}
+	if skipped != 4 {
+		t.Errorf("skipped %v, want %v", skipped, 4)
+	}
+}
+
+func TestReadTrace_CSV(t *testing.T) {
+	trace := "time,key,size\n1,a,10\n2,b\n3,c,x\n# comment\n4,\"d,e\",5\n"
+
+	// the header is skipped as a line with a malformed size
+	requests, skipped := read(t, trace, "csv", csvFormat{comma: ',', key: 2, size: 3}, 0)
+	if want := []request{{"a", 10}, {"d,e", 5}}; !reflect.DeepEqual(requests, want) {
+		t.Errorf("requests %v, want %v", requests, want)
+	}
+	if skipped != 3 {
+		t.Errorf("skipped %v, want %v", skipped, 3)
+	}
+
+	// requests without the size column
+	requests, skipped = read(t, "1 a\n2 b\n", "csv", csvFormat{comma: ' ', key: 2}, 0)
+	if want := []request{{"a", 1}, {"b", 1}}; !reflect.DeepEqual(requests, want) {
+		t.Errorf("requests %v, want %v", requests, want)
+	}
+	if skipped != 0 {
+		t.Errorf("skipped %v, want %v", skipped, 0)
+	}
+
+	visit := func(string, int64) error { return nil }
+	if _, err := readTrace(strings.NewReader(trace), "csv", csvFormat{comma: ',', key: 0}, visit); err == nil {
+		t.Errorf("expected error")
+	}
+}
+
+func TestReadTrace_Limit(t *testing.T) {
+	requests, _ := read(t, "a\nb\nc\n", "plain", csvFormat{}, 2)
+	if want := []request{{"a", 1}, {"b", 1}}; !reflect.DeepEqual(requests, want) {
+		t.Errorf("requests %v, want %v", requests, want)
+	}
+
+	// the limit stops in the middle of an ARC line
+	requests, _ = read(t, "10 5 0 1\n", "arc", csvFormat{}, 3)
+	if len(requests) != 3 {
+		t.Errorf("requests %v, want %v", len(requests), 3)
+	}
+}
+
+func TestReadTrace_UnknownFormat(t *testing.T) {
+	visit := func(string, int64) error { return nil }
+	if _, err := readTrace(strings.NewReader("a\n"), "lirs", csvFormat{}, visit); err == nil {
+		t.Errorf("expected error")
+	}
+}
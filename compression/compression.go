@@ -0,0 +1,267 @@
+// Package compression implements a cache wrapper that compresses large []byte values,
+// trading CPU for memory.
+//
+// Values of at least the threshold size are compressed by a Codec before being stored
+// in the wrapped cache, e.g. lru.Cache, and transparently decompressed on Get.
+// Smaller values and values the codec can't shrink are stored as is.
+// Put copies them, so that callers may reuse their buffers.
+package compression
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sync"
+)
+
+// Codec compresses and decompresses values.
+// Implementations for other algorithms, e.g. zstd or snappy, can be plugged in with WithCodec.
+type Codec interface {
+	Encode(data []byte) ([]byte, error)
+	Decode(data []byte) ([]byte, error)
+}
+
+// Inner represents a wrapped cache, e.g. lru.Cache or lfu.Cache
+type Inner interface {
+	Get(key string) (interface{}, bool)
+	Put(key string, value interface{})
+	Delete(key string)
+}
+
+// Stats represents compression statistics of the values put into the cache
+type Stats struct {
+	// Compressed is the number of compressed values
+	Compressed int64
+	// Skipped is the number of values stored as is
+	Skipped int64
+	// RawBytes is the total size of the compressed values before compression
+	RawBytes int64
+	// CompressedBytes is the total size of the compressed values after compression
+	CompressedBytes int64
+	// SkippedBytes is the total size of the values stored as is
+	SkippedBytes int64
+	// Errors is the number of values that failed to compress or decompress
+	Errors int64
+}
+
+// Ratio returns the size of all values put into the cache divided by the size they are stored with,
+// values stored as is count with a ratio of 1. Returns 0 if no values were put.
+func (s Stats) Ratio() float64 {
+	stored := s.CompressedBytes + s.SkippedBytes
+	if stored == 0 {
+		return 0
+	}
+	return float64(s.RawBytes+s.SkippedBytes) / float64(stored)
+}
+
+// Cache represents safe for concurrent use cache compressing its values
+type Cache struct {
+	inner     Inner
+	codec     Codec
+	threshold int
+
+	m     *sync.Mutex
+	stats Stats
+}
+
+// compressed marks values stored in compressed form
+type compressed []byte
+
+// Option configures a cache instance
+type Option func(*Cache)
+
+// WithCodec sets the codec of the cache. Gzip with the default compression level is used by default.
+func WithCodec(codec Codec) Option {
+	return func(c *Cache) {
+		c.codec = codec
+	}
+}
+
+// WithThreshold sets the minimal size of values to be compressed. 1024 bytes by default.
+func WithThreshold(threshold int) Option {
+	return func(c *Cache) {
+		c.threshold = threshold
+	}
+}
+
+// New returns an initialized cache instance wrapping the inner cache
+func New(inner Inner, options ...Option) (*Cache, error) {
+	c := &Cache{
+		inner:     inner,
+		threshold: 1024,
+		m:         &sync.Mutex{},
+	}
+
+	for _, option := range options {
+		option(c)
+	}
+
+	if c.threshold < 0 {
+		return nil, fmt.Errorf("threshold can't be negative")
+	}
+	if c.codec == nil {
+		codec, err := Gzip(gzip.DefaultCompression)
+		if err != nil {
+			return nil, err
+		}
+		c.codec = codec
+	}
+	return c, nil
+}
+
+// Get returns (value, true, nil) or (nil, false, nil) for a given key.
+// Values stored as is are returned without copying and must not be modified.
+// Returns an error if the value can't be decompressed.
+func (c *Cache) Get(key string) ([]byte, bool, error) {
+	value, ok := c.inner.Get(key)
+	if !ok {
+		return nil, false, nil
+	}
+
+	switch v := value.(type) {
+	case compressed:
+		data, err := c.codec.Decode(v)
+		if err != nil {
+			c.count(func(s *Stats) { s.Errors++ })
+			return nil, false, err
+		}
+		return data, true, nil
+	case []byte:
+		return v, true, nil
+	default:
+		return nil, false, fmt.Errorf("value of type %T is not []byte", value)
+	}
+}
+
+// Put inserts a new record into the cache compressing the value if it is large enough.
+// Returns an error if the value can't be compressed.
+func (c *Cache) Put(key string, value []byte) error {
+	if len(value) < c.threshold {
+		c.skip(key, value)
+		return nil
+	}
+
+	data, err := c.codec.Encode(value)
+	if err != nil {
+		c.count(func(s *Stats) { s.Errors++ })
+		return err
+	}
+
+	// incompressible values are not worth decoding on every Get
+	if len(data) >= len(value) {
+		c.skip(key, value)
+		return nil
+	}
+
+	c.count(func(s *Stats) {
+		s.Compressed++
+		s.RawBytes += int64(len(value))
+		s.CompressedBytes += int64(len(data))
+	})
+	c.inner.Put(key, compressed(data))
+	return nil
+}
+
+// Delete removes the record associated with the specified key
+func (c *Cache) Delete(key string) {
+	c.inner.Delete(key)
+}
+
+// Stats returns compression statistics of the cache
+func (c *Cache) Stats() Stats {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	return c.stats
+}
+
+// skip stores a copy of the value as is
+func (c *Cache) skip(key string, value []byte) {
+	c.count(func(s *Stats) {
+		s.Skipped++
+		s.SkippedBytes += int64(len(value))
+	})
+	c.inner.Put(key, append([]byte(nil), value...))
+}
+
+func (c *Cache) count(f func(s *Stats)) {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	f(&c.stats)
+}
+
+// Gzip returns a codec compressing values with gzip at the level
+func Gzip(level int) (Codec, error) {
+	if _, err := gzip.NewWriterLevel(ioutil.Discard, level); err != nil {
+		return nil, err
+	}
+
+	return &streamCodec{
+		writers: &sync.Pool{New: func() interface{} {
+			w, _ := gzip.NewWriterLevel(nil, level)
+			return w
+		}},
+		reader: func(r io.Reader) (io.ReadCloser, error) {
+			return gzip.NewReader(r)
+		},
+	}, nil
+}
+
+// Flate returns a codec compressing values with raw DEFLATE at the level.
+// It has less overhead than gzip for small values.
+func Flate(level int) (Codec, error) {
+	if _, err := flate.NewWriter(ioutil.Discard, level); err != nil {
+		return nil, err
+	}
+
+	return &streamCodec{
+		writers: &sync.Pool{New: func() interface{} {
+			w, _ := flate.NewWriter(nil, level)
+			return w
+		}},
+		reader: func(r io.Reader) (io.ReadCloser, error) {
+			return flate.NewReader(r), nil
+		},
+	}, nil
+}
+
+// streamCodec adapts streaming compressors of the standard library to Codec
+type streamCodec struct {
+	writers *sync.Pool
+	reader  func(r io.Reader) (io.ReadCloser, error)
+}
+
+type resetWriter interface {
+	io.WriteCloser
+	Reset(w io.Writer)
+}
+
+func (c *streamCodec) Encode(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+
+	w := c.writers.Get().(resetWriter)
+	defer c.writers.Put(w)
+
+	w.Reset(&buf)
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (c *streamCodec) Decode(data []byte) ([]byte, error) {
+	r, err := c.reader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	return ioutil.ReadAll(r)
+}
@@ -0,0 +1,163 @@
+package compression_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"math/rand"
+	"reflect"
+	"testing"
+
+	"github.com/faroyam/caches/compression"
+	"github.com/faroyam/caches/lru"
+)
+
+func newCache(t *testing.T, options ...compression.Option) (*compression.Cache, *lru.Cache) {
+	inner, _ := lru.New(10)
+	c, err := compression.New(inner, options...)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	return c, inner
+}
+
+func TestNew(t *testing.T) {
+	inner, _ := lru.New(10)
+	if _, err := compression.New(inner, compression.WithThreshold(-1)); err == nil {
+		t.Errorf("expected error")
+	}
+
+	if _, err := compression.Gzip(100); err == nil {
+		t.Errorf("expected error")
+	}
+
+	if _, err := compression.Flate(100); err == nil {
+		t.Errorf("expected error")
+	}
+}
+
+func TestCache_Get(t *testing.T) {
+	gzipCodec, _ := compression.Gzip(gzip.BestCompression)
+	flateCodec, _ := compression.Flate(gzip.BestSpeed)
+
+	for _, codec := range []compression.Codec{gzipCodec, flateCodec} {
+		cache, inner := newCache(t, compression.WithCodec(codec), compression.WithThreshold(100))
+
+		text := bytes.Repeat([]byte("lorem ipsum dolor sit amet "), 100)
+		cache.Put("large", text)
+		cache.Put("small", []byte("small"))
+
+		if value, ok, err := cache.Get("large"); err != nil || !ok || !bytes.Equal(value, text) {
+			t.Errorf("cached value %s, want %s", value, text)
+		}
+
+		if value, ok, _ := cache.Get("small"); !ok || string(value) != "small" {
+			t.Errorf("cached value %s, want %v", value, "small")
+		}
+
+		if value, ok, _ := cache.Get("non-existing-key"); ok {
+			t.Errorf("cached value %s, want %v", value, "nil")
+		}
+
+		// the large value is stored compressed
+
+		if value, _ := inner.Get("large"); reflect.DeepEqual(value, text) {
+			t.Errorf("value is not compressed")
+		}
+
+		stats := cache.Stats()
+		if stats.Compressed != 1 || stats.Skipped != 1 || stats.RawBytes != int64(len(text)) {
+			t.Errorf("stats %+v", stats)
+		}
+
+		if stats.Ratio() < 10 {
+			t.Errorf("ratio %v, want >= %v", stats.Ratio(), 10)
+		}
+	}
+}
+
+func TestCache_Incompressible(t *testing.T) {
+	cache, inner := newCache(t, compression.WithThreshold(0))
+
+	data := make([]byte, 1024)
+	rand.New(rand.NewSource(1)).Read(data)
+	cache.Put("random", data)
+
+	if value, _ := inner.Get("random"); !bytes.Equal(value.([]byte), data) {
+		t.Errorf("incompressible value is compressed")
+	}
+
+	if value, _, _ := cache.Get("random"); !bytes.Equal(value, data) {
+		t.Errorf("cached value %v, want %v", value, data)
+	}
+
+	if stats := cache.Stats(); stats.Skipped != 1 || stats.SkippedBytes != 1024 || stats.Ratio() != 1 {
+		t.Errorf("stats %+v", stats)
+	}
+}
+
+func TestCache_Put_Copy(t *testing.T) {
+	cache, _ := newCache(t)
+
+	// values stored as is don't share buffers with callers
+	buf := []byte("value")
+	cache.Put("key", buf)
+	copy(buf, "reuse")
+
+	if value, _, _ := cache.Get("key"); string(value) != "value" {
+		t.Errorf("cached value %s, want %v", value, "value")
+	}
+}
+
+func TestStats_Ratio(t *testing.T) {
+	if ratio := (compression.Stats{}).Ratio(); ratio != 0 {
+		t.Errorf("ratio %v, want %v", ratio, 0)
+	}
+
+	// 1000 bytes compressed to 100 and 100 bytes stored as is
+	stats := compression.Stats{RawBytes: 1000, CompressedBytes: 100, SkippedBytes: 100}
+	if ratio := stats.Ratio(); ratio != 5.5 {
+		t.Errorf("ratio %v, want %v", ratio, 5.5)
+	}
+}
+
+func TestCache_Delete(t *testing.T) {
+	cache, _ := newCache(t)
+
+	cache.Put("1", []byte("1"))
+	cache.Delete("1")
+
+	if value, ok, _ := cache.Get("1"); ok {
+		t.Errorf("cached value %s, want %v", value, "nil")
+	}
+}
+
+type failingCodec struct{}
+
+func (failingCodec) Encode(data []byte) ([]byte, error) {
+	if len(data) > 10 {
+		return nil, errors.New("too large")
+	}
+	return data[:1], nil
+}
+
+func (failingCodec) Decode(data []byte) ([]byte, error) {
+	return nil, errors.New("corrupted")
+}
+
+func TestCache_WithCodec(t *testing.T) {
+	cache, _ := newCache(t, compression.WithCodec(failingCodec{}), compression.WithThreshold(2))
+
+	if err := cache.Put("1", []byte("large value")); err == nil {
+		t.Errorf("expected error")
+	}
+
+	cache.Put("2", []byte("value"))
+	if _, _, err := cache.Get("2"); err == nil {
+		t.Errorf("expected error")
+	}
+
+	if stats := cache.Stats(); stats.Errors != 2 {
+		t.Errorf("errors %v, want %v", stats.Errors, 2)
+	}
+}
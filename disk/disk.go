@@ -0,0 +1,518 @@
+// Package disk implements an on-disk key-value store for byte values
+// meant to be used as the second tier of a cache.
+//
+// Records are appended to segment files, and an in-memory index points at the last record of each key.
+// Deletes append tombstones, so that reopening the store does not bring deleted keys back.
+// Compaction rewrites live records into new segments and removes the old ones.
+// A torn record at the end of the active segment, e.g. after a crash, is truncated when the store is opened,
+// while a corrupted record in an older segment fails Open, since records after it would be lost.
+package disk
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const (
+	segmentExt = ".seg"
+
+	// record header: crc32 of the rest of the record, flags, key length, value length
+	headerSize = 4 + 1 + 4 + 4
+
+	flagTombstone = 1
+)
+
+var errCorrupted = errors.New("corrupted record")
+
+// Store represents safe for concurrent use append-only on-disk store
+type Store struct {
+	m   *sync.Mutex
+	dir string
+
+	maxSegmentSize int64
+	maxSize        int64
+
+	segments []*segment // sorted by id, the last one is active
+	index    map[string]location
+	size     int64
+	garbage  int64
+}
+
+// Option configures a store instance
+type Option func(*Store)
+
+// WithMaxSegmentSize sets the size at which the active segment is closed and a new one is started
+func WithMaxSegmentSize(size int64) Option {
+	return func(s *Store) {
+		s.maxSegmentSize = size
+	}
+}
+
+// WithMaxSize bounds the total size of segments.
+// When the store grows beyond it, the oldest segments are dropped with all their records.
+func WithMaxSize(size int64) Option {
+	return func(s *Store) {
+		s.maxSize = size
+	}
+}
+
+// Open opens the store in the directory creating the directory if necessary
+// and rebuilds the index from the existing segments
+func Open(dir string, options ...Option) (*Store, error) {
+	s := &Store{
+		m:              &sync.Mutex{},
+		dir:            dir,
+		maxSegmentSize: 64 << 20,
+		index:          make(map[string]location),
+	}
+
+	for _, option := range options {
+		option(s)
+	}
+
+	if s.maxSegmentSize <= 0 {
+		return nil, fmt.Errorf("max segment size can't be negative")
+	}
+	if s.maxSize < 0 {
+		return nil, fmt.Errorf("max size can't be negative")
+	}
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	ids, err := segmentIDs(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, id := range ids {
+		seg, err := openSegment(dir, id)
+		if err != nil {
+			s.close()
+			return nil, err
+		}
+		s.segments = append(s.segments, seg)
+
+		if err := s.load(seg, i == len(ids)-1); err != nil {
+			s.close()
+			return nil, err
+		}
+	}
+
+	if len(s.segments) == 0 {
+		if err := s.rotate(); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// Get returns (value, true, nil) or (nil, false, nil) for a given key
+func (s *Store) Get(key string) ([]byte, bool, error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	loc, ok := s.index[key]
+	if !ok {
+		return nil, false, nil
+	}
+
+	_, value, _, err := s.segment(loc.segment).read(loc.offset, loc.size)
+	if err != nil {
+		return nil, false, err
+	}
+	return value, true, nil
+}
+
+// Contains reports whether the key is in the store
+func (s *Store) Contains(key string) bool {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	_, ok := s.index[key]
+	return ok
+}
+
+// Put appends a new record to the store
+func (s *Store) Put(key string, value []byte) error {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	return s.append(key, value, 0)
+}
+
+// Delete appends a tombstone for the key to the store
+func (s *Store) Delete(key string) error {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	if _, ok := s.index[key]; !ok {
+		return nil
+	}
+	return s.append(key, nil, flagTombstone)
+}
+
+// Compact rewrites live records into new segments and removes the old ones
+func (s *Store) Compact() error {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	return s.compact()
+}
+
+// Len returns the number of keys in the store
+func (s *Store) Len() int {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	return len(s.index)
+}
+
+// Size returns the total size of segments and the size of dead records in them
+func (s *Store) Size() (size, garbage int64) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	return s.size, s.garbage
+}
+
+// Close closes the segment files
+func (s *Store) Close() error {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	return s.close()
+}
+
+func (s *Store) append(key string, value []byte, flags byte) error {
+	data := encodeRecord(key, value, flags)
+	size := int64(len(data))
+
+	active := s.segments[len(s.segments)-1]
+	if active.size > 0 && active.size+size > s.maxSegmentSize {
+		if err := s.rotate(); err != nil {
+			return err
+		}
+		active = s.segments[len(s.segments)-1]
+	}
+
+	offset, err := active.append(data)
+	if err != nil {
+		return err
+	}
+	s.size += size
+	s.apply(key, location{segment: active.id, offset: offset, size: size}, flags)
+
+	if s.garbage > s.maxSegmentSize && s.garbage*2 > s.size {
+		if err := s.compact(); err != nil {
+			return err
+		}
+	}
+
+	for s.maxSize > 0 && s.size > s.maxSize && len(s.segments) > 1 {
+		if err := s.dropOldest(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// apply updates the index and the garbage size with a record appended at loc
+func (s *Store) apply(key string, loc location, flags byte) {
+	if old, ok := s.index[key]; ok {
+		s.garbage += old.size
+	}
+
+	if flags&flagTombstone != 0 {
+		delete(s.index, key)
+		s.garbage += loc.size
+		return
+	}
+	s.index[key] = loc
+}
+
+// load scans the segment and applies its records to the index.
+// A torn tail is truncated only in the active segment, sealed segments are never written to.
+func (s *Store) load(seg *segment, active bool) error {
+	var offset int64
+	for offset < seg.size {
+		key, _, flags, size, err := seg.readAt(offset)
+		if err == io.ErrUnexpectedEOF || err == io.EOF || err == errCorrupted {
+			if !active {
+				return fmt.Errorf("segment %016x at offset %d: %w", seg.id, offset, errCorrupted)
+			}
+			return seg.truncate(offset)
+		}
+		if err != nil {
+			return err
+		}
+
+		s.apply(key, location{segment: seg.id, offset: offset, size: size}, flags)
+		offset += size
+	}
+	s.size += seg.size
+	return nil
+}
+
+func (s *Store) rotate() error {
+	var id uint64
+	if len(s.segments) > 0 {
+		id = s.segments[len(s.segments)-1].id + 1
+	}
+
+	seg, err := openSegment(s.dir, id)
+	if err != nil {
+		return err
+	}
+	s.segments = append(s.segments, seg)
+	return nil
+}
+
+func (s *Store) compact() error {
+	if err := s.rotate(); err != nil {
+		return err
+	}
+
+	old := s.segments[:len(s.segments)-1]
+	active := s.segments[len(s.segments)-1].id
+
+	keys := make([]string, 0, len(s.index))
+	for key, loc := range s.index {
+		if loc.segment < active {
+			keys = append(keys, key)
+		}
+	}
+	// copy records in their order on disk
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := s.index[keys[i]], s.index[keys[j]]
+		return a.segment < b.segment || a.segment == b.segment && a.offset < b.offset
+	})
+
+	for _, key := range keys {
+		loc := s.index[key]
+		_, value, _, err := s.segment(loc.segment).read(loc.offset, loc.size)
+		if err != nil {
+			return err
+		}
+
+		seg := s.segments[len(s.segments)-1]
+		if seg.size > 0 && seg.size+loc.size > s.maxSegmentSize {
+			if err := s.rotate(); err != nil {
+				return err
+			}
+			seg = s.segments[len(s.segments)-1]
+		}
+
+		offset, err := seg.append(encodeRecord(key, value, 0))
+		if err != nil {
+			return err
+		}
+		s.index[key] = location{segment: seg.id, offset: offset, size: loc.size}
+	}
+
+	for _, seg := range old {
+		if err := seg.remove(); err != nil {
+			return err
+		}
+	}
+
+	s.segments = s.segments[len(old):]
+	s.size, s.garbage = 0, 0
+	for _, seg := range s.segments {
+		s.size += seg.size
+	}
+	return nil
+}
+
+func (s *Store) dropOldest() error {
+	oldest := s.segments[0]
+	for key, loc := range s.index {
+		if loc.segment == oldest.id {
+			delete(s.index, key)
+		}
+	}
+
+	if err := oldest.remove(); err != nil {
+		return err
+	}
+	s.segments = s.segments[1:]
+
+	s.size -= oldest.size
+	s.garbage = s.garbageSize()
+	return nil
+}
+
+// garbageSize recalculates the size of dead records from the index
+func (s *Store) garbageSize() int64 {
+	live := int64(0)
+	for _, loc := range s.index {
+		live += loc.size
+	}
+	return s.size - live
+}
+
+func (s *Store) segment(id uint64) *segment {
+	i := sort.Search(len(s.segments), func(i int) bool {
+		return s.segments[i].id >= id
+	})
+	return s.segments[i]
+}
+
+func (s *Store) close() error {
+	var firstErr error
+	for _, seg := range s.segments {
+		if err := seg.f.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+type location struct {
+	segment uint64
+	offset  int64
+	size    int64
+}
+
+type segment struct {
+	id   uint64
+	path string
+	f    *os.File
+	size int64
+}
+
+func openSegment(dir string, id uint64) (*segment, error) {
+	path := filepath.Join(dir, fmt.Sprintf("%016x%s", id, segmentExt))
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0o644)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return &segment{
+		id:   id,
+		path: path,
+		f:    f,
+		size: info.Size(),
+	}, nil
+}
+
+func (seg *segment) append(data []byte) (int64, error) {
+	offset := seg.size
+	if _, err := seg.f.WriteAt(data, offset); err != nil {
+		return 0, err
+	}
+	seg.size += int64(len(data))
+	return offset, nil
+}
+
+// read reads the record of a known size at the offset
+func (seg *segment) read(offset, size int64) (string, []byte, byte, error) {
+	data := make([]byte, size)
+	if _, err := seg.f.ReadAt(data, offset); err != nil {
+		return "", nil, 0, err
+	}
+	return decodeRecord(data)
+}
+
+// readAt reads the record at the offset and returns its size
+func (seg *segment) readAt(offset int64) (string, []byte, byte, int64, error) {
+	header := make([]byte, headerSize)
+	if _, err := seg.f.ReadAt(header, offset); err != nil {
+		return "", nil, 0, 0, unexpectedEOF(err)
+	}
+
+	size := int64(headerSize) +
+		int64(binary.LittleEndian.Uint32(header[5:9])) +
+		int64(binary.LittleEndian.Uint32(header[9:13]))
+	if offset+size > seg.size {
+		return "", nil, 0, 0, io.ErrUnexpectedEOF
+	}
+
+	key, value, flags, err := seg.read(offset, size)
+	return key, value, flags, size, err
+}
+
+func (seg *segment) truncate(size int64) error {
+	if err := seg.f.Truncate(size); err != nil {
+		return err
+	}
+	seg.size = size
+	return nil
+}
+
+func (seg *segment) remove() error {
+	if err := seg.f.Close(); err != nil {
+		return err
+	}
+	return os.Remove(seg.path)
+}
+
+func encodeRecord(key string, value []byte, flags byte) []byte {
+	data := make([]byte, headerSize+len(key)+len(value))
+	data[4] = flags
+	binary.LittleEndian.PutUint32(data[5:9], uint32(len(key)))
+	binary.LittleEndian.PutUint32(data[9:13], uint32(len(value)))
+	copy(data[headerSize:], key)
+	copy(data[headerSize+len(key):], value)
+	binary.LittleEndian.PutUint32(data[0:4], crc32.ChecksumIEEE(data[4:]))
+	return data
+}
+
+func decodeRecord(data []byte) (string, []byte, byte, error) {
+	if len(data) < headerSize || binary.LittleEndian.Uint32(data[0:4]) != crc32.ChecksumIEEE(data[4:]) {
+		return "", nil, 0, errCorrupted
+	}
+
+	keyLen := int(binary.LittleEndian.Uint32(data[5:9]))
+	key := string(data[headerSize : headerSize+keyLen])
+	value := data[headerSize+keyLen:]
+	return key, value, data[4], nil
+}
+
+func segmentIDs(dir string) ([]uint64, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var ids []uint64
+	for _, file := range files {
+		name := file.Name()
+		if file.IsDir() || !strings.HasSuffix(name, segmentExt) {
+			continue
+		}
+
+		var id uint64
+		if _, err := fmt.Sscanf(strings.TrimSuffix(name, segmentExt), "%x", &id); err != nil {
+			continue
+		}
+		ids = append(ids, id)
+	}
+
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids, nil
+}
+
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
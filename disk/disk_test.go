@@ -0,0 +1,195 @@
+package disk_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/faroyam/caches/disk"
+)
+
+func TestOpen(t *testing.T) {
+	if _, err := disk.Open(t.TempDir(), disk.WithMaxSegmentSize(0)); err == nil {
+		t.Errorf("expected error")
+	}
+
+	if _, err := disk.Open(t.TempDir(), disk.WithMaxSize(-1)); err == nil {
+		t.Errorf("expected error")
+	}
+}
+
+func TestStore_Get(t *testing.T) {
+	store, _ := disk.Open(t.TempDir())
+	defer store.Close()
+
+	store.Put("key", []byte("value1"))
+	store.Put("key", []byte("value2"))
+
+	if value, ok, err := store.Get("key"); err != nil || !ok || string(value) != "value2" {
+		t.Errorf("stored value %s, want %v", value, "value2")
+	}
+
+	if value, ok, _ := store.Get("non-existing-key"); ok {
+		t.Errorf("stored value %s, want %v", value, "nil")
+	}
+
+	if _, garbage := store.Size(); garbage == 0 {
+		t.Errorf("garbage %v, want > %v", garbage, 0)
+	}
+}
+
+func TestStore_Delete(t *testing.T) {
+	dir := t.TempDir()
+	store, _ := disk.Open(dir)
+	store.Put("1", []byte("1"))
+	store.Put("2", []byte("2"))
+	store.Delete("1")
+	store.Delete("non-existing-key")
+
+	if store.Contains("1") || store.Len() != 1 {
+		t.Errorf("store len %v, want %v", store.Len(), 1)
+	}
+	store.Close()
+
+	// tombstones survive reopening
+
+	store, _ = disk.Open(dir)
+	defer store.Close()
+
+	if store.Contains("1") || !store.Contains("2") {
+		t.Errorf("store len %v, want %v", store.Len(), 1)
+	}
+}
+
+func TestStore_Reopen(t *testing.T) {
+	dir := t.TempDir()
+	store, _ := disk.Open(dir, disk.WithMaxSegmentSize(64))
+	for i := 0; i < 20; i++ {
+		store.Put(strconv.Itoa(i%10), []byte(strconv.Itoa(i)))
+	}
+	store.Close()
+
+	store, err := disk.Open(dir, disk.WithMaxSegmentSize(64))
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	defer store.Close()
+
+	if store.Len() != 10 {
+		t.Errorf("store len %v, want %v", store.Len(), 10)
+	}
+
+	for i := 10; i < 20; i++ {
+		key := strconv.Itoa(i % 10)
+		if value, _, _ := store.Get(key); string(value) != strconv.Itoa(i) {
+			t.Errorf("stored value %s, want %v", value, i)
+		}
+	}
+}
+
+func TestStore_TornTail(t *testing.T) {
+	dir := t.TempDir()
+	store, _ := disk.Open(dir)
+	store.Put("1", []byte("value1"))
+	store.Put("2", []byte("value2"))
+	store.Close()
+
+	segments, _ := filepath.Glob(filepath.Join(dir, "*.seg"))
+	info, _ := os.Stat(segments[0])
+	os.Truncate(segments[0], info.Size()-3)
+
+	store, err := disk.Open(dir)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	if !store.Contains("1") || store.Contains("2") {
+		t.Errorf("store len %v, want %v", store.Len(), 1)
+	}
+
+	// new records are appended after the truncated tail
+
+	store.Put("3", []byte("value3"))
+	store.Close()
+
+	store, _ = disk.Open(dir)
+	defer store.Close()
+
+	if value, _, _ := store.Get("3"); string(value) != "value3" {
+		t.Errorf("stored value %s, want %v", value, "value3")
+	}
+}
+
+func TestStore_CorruptedSegment(t *testing.T) {
+	dir := t.TempDir()
+	store, _ := disk.Open(dir, disk.WithMaxSegmentSize(64))
+	for i := 0; i < 10; i++ {
+		store.Put(strconv.Itoa(i), []byte("value"))
+	}
+	store.Close()
+
+	// a torn record in a sealed segment can't be a crash of the last write
+	segments, _ := filepath.Glob(filepath.Join(dir, "*.seg"))
+	if len(segments) < 2 {
+		t.Fatalf("%v segments, want at least %v", len(segments), 2)
+	}
+	info, _ := os.Stat(segments[0])
+	os.Truncate(segments[0], info.Size()-3)
+
+	if _, err := disk.Open(dir); err == nil {
+		t.Errorf("expected error")
+	}
+}
+
+func TestStore_Compact(t *testing.T) {
+	dir := t.TempDir()
+	store, _ := disk.Open(dir, disk.WithMaxSegmentSize(64))
+	defer store.Close()
+
+	for i := 0; i < 100; i++ {
+		store.Put(strconv.Itoa(i%5), []byte(strconv.Itoa(i)))
+	}
+	store.Delete("0")
+
+	if err := store.Compact(); err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+
+	if size, garbage := store.Size(); garbage != 0 || size > 5*32 {
+		t.Errorf("size %v, garbage %v", size, garbage)
+	}
+
+	if store.Len() != 4 {
+		t.Errorf("store len %v, want %v", store.Len(), 4)
+	}
+
+	for i := 96; i < 100; i++ {
+		if value, _, _ := store.Get(strconv.Itoa(i % 5)); string(value) != strconv.Itoa(i) {
+			t.Errorf("stored value %s, want %v", value, i)
+		}
+	}
+
+	files, _ := ioutil.ReadDir(dir)
+	if len(files) > 3 {
+		t.Errorf("segments %v, want <= %v", len(files), 3)
+	}
+}
+
+func TestStore_WithMaxSize(t *testing.T) {
+	store, _ := disk.Open(t.TempDir(), disk.WithMaxSegmentSize(100), disk.WithMaxSize(300))
+	defer store.Close()
+
+	for i := 0; i < 100; i++ {
+		store.Put(strconv.Itoa(i), []byte("value"))
+	}
+
+	if size, _ := store.Size(); size > 300 {
+		t.Errorf("size %v, want <= %v", size, 300)
+	}
+
+	if !store.Contains("99") || store.Contains("0") {
+		t.Errorf("the oldest records are not dropped")
+	}
+}
@@ -0,0 +1,159 @@
+// Package encryption implements an AES-GCM codec for values cached at rest,
+// e.g. in write-ahead log snapshots or on-disk tiers.
+//
+// Every entry is prefixed with the ID of the key it was encrypted with,
+// so keys can be rotated: entries are encrypted with the current key
+// and decrypted with any key still known to the codec.
+// The header is authenticated along with the value, so tampering with either is detected on Decode.
+// EncodeKey and DecodeKey authenticate the cache key of the value as well,
+// so that an entry copied to another key fails to decode.
+//
+// The codec plugs into wal.WithCodec directly, records of the log are encrypted along with their keys.
+// It plugs into tiered.WithKeyMarshaling via Marshaling, which binds values to their keys,
+// but the keys themselves are stored on disk in plaintext.
+package encryption
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// entry header: version, key ID
+const (
+	version    = 1
+	headerSize = 1 + 4
+)
+
+var (
+	// ErrUnknownKey is returned by Decode if the entry was encrypted with a key unknown to the codec
+	ErrUnknownKey = errors.New("unknown key")
+	// ErrMalformed is returned by Decode if the entry is too short or has an unknown version
+	ErrMalformed = errors.New("malformed entry")
+	// ErrTampered is returned by Decode if the entry fails authentication
+	ErrTampered = errors.New("entry is tampered with or encrypted with another key")
+)
+
+// Codec represents safe for concurrent use AES-GCM codec
+type Codec struct {
+	aeads   map[uint32]cipher.AEAD
+	current uint32
+}
+
+// New returns an initialized codec instance encrypting with the key current.
+// keys maps key IDs to AES-128, AES-192 or AES-256 keys and must contain current.
+// Keys retired by rotation should be kept until no entries encrypted with them remain.
+func New(current uint32, keys map[uint32][]byte) (*Codec, error) {
+	if _, ok := keys[current]; !ok {
+		return nil, fmt.Errorf("current key %d is missing", current)
+	}
+
+	c := &Codec{
+		aeads:   make(map[uint32]cipher.AEAD, len(keys)),
+		current: current,
+	}
+	for id, key := range keys {
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, fmt.Errorf("key %d: %w", id, err)
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, fmt.Errorf("key %d: %w", id, err)
+		}
+		c.aeads[id] = aead
+	}
+	return c, nil
+}
+
+// Encode encrypts data with the current key.
+// The entry layout is version(1) | key ID(4) | nonce | ciphertext and tag.
+func (c *Codec) Encode(data []byte) ([]byte, error) {
+	return c.EncodeKey("", data)
+}
+
+// Decode authenticates and decrypts the entry
+func (c *Codec) Decode(entry []byte) ([]byte, error) {
+	return c.DecodeKey("", entry)
+}
+
+// EncodeKey encrypts data of the cache key with the current key authenticating the cache key as well
+func (c *Codec) EncodeKey(key string, data []byte) ([]byte, error) {
+	aead := c.aeads[c.current]
+
+	entry := make([]byte, headerSize+aead.NonceSize(), headerSize+aead.NonceSize()+len(data)+aead.Overhead())
+	entry[0] = version
+	binary.LittleEndian.PutUint32(entry[1:headerSize], c.current)
+
+	nonce := entry[headerSize:]
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+
+	return aead.Seal(entry, nonce, data, associatedData(entry, key)), nil
+}
+
+// DecodeKey authenticates the entry along with the cache key it was encoded for and decrypts it
+func (c *Codec) DecodeKey(key string, entry []byte) ([]byte, error) {
+	id, err := c.KeyID(entry)
+	if err != nil {
+		return nil, err
+	}
+
+	aead, ok := c.aeads[id]
+	if !ok {
+		return nil, fmt.Errorf("key %d: %w", id, ErrUnknownKey)
+	}
+	if len(entry) < headerSize+aead.NonceSize()+aead.Overhead() {
+		return nil, ErrMalformed
+	}
+
+	nonce := entry[headerSize : headerSize+aead.NonceSize()]
+	data, err := aead.Open(nil, nonce, entry[headerSize+aead.NonceSize():], associatedData(entry, key))
+	if err != nil {
+		return nil, ErrTampered
+	}
+	return data, nil
+}
+
+// KeyID returns the ID of the key the entry was encrypted with,
+// e.g. to find entries to re-encrypt after rotation
+func (c *Codec) KeyID(entry []byte) (uint32, error) {
+	if len(entry) < headerSize || entry[0] != version {
+		return 0, ErrMalformed
+	}
+	return binary.LittleEndian.Uint32(entry[1:headerSize]), nil
+}
+
+// Marshaling wraps functions converting values to bytes and back with encryption
+// binding values to their cache keys, e.g. to be passed to tiered.WithKeyMarshaling
+func (c *Codec) Marshaling(
+	marshal func(value interface{}) ([]byte, error),
+	unmarshal func(data []byte) (interface{}, error),
+) (func(key string, value interface{}) ([]byte, error), func(key string, data []byte) (interface{}, error)) {
+	encrypt := func(key string, value interface{}) ([]byte, error) {
+		data, err := marshal(value)
+		if err != nil {
+			return nil, err
+		}
+		return c.EncodeKey(key, data)
+	}
+
+	decrypt := func(key string, entry []byte) (interface{}, error) {
+		data, err := c.DecodeKey(key, entry)
+		if err != nil {
+			return nil, err
+		}
+		return unmarshal(data)
+	}
+	return encrypt, decrypt
+}
+
+// associatedData returns the header of the entry followed by the cache key
+func associatedData(entry []byte, key string) []byte {
+	return append(entry[:headerSize:headerSize], key...)
+}
@@ -0,0 +1,199 @@
+package encryption_test
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/faroyam/caches/disk"
+	"github.com/faroyam/caches/encryption"
+	"github.com/faroyam/caches/lru"
+	"github.com/faroyam/caches/tiered"
+	"github.com/faroyam/caches/wal"
+)
+
+var (
+	key1 = bytes.Repeat([]byte{1}, 32)
+	key2 = bytes.Repeat([]byte{2}, 16)
+)
+
+func TestNew(t *testing.T) {
+	if _, err := encryption.New(1, map[uint32][]byte{2: key2}); err == nil {
+		t.Errorf("expected error")
+	}
+
+	if _, err := encryption.New(1, map[uint32][]byte{1: []byte("short")}); err == nil {
+		t.Errorf("expected error")
+	}
+}
+
+func TestCodec_Decode(t *testing.T) {
+	codec, _ := encryption.New(1, map[uint32][]byte{1: key1})
+
+	data := []byte("personal data")
+	entry, err := codec.Encode(data)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	if bytes.Contains(entry, data) {
+		t.Errorf("entry contains plaintext")
+	}
+
+	if decoded, err := codec.Decode(entry); err != nil || !bytes.Equal(decoded, data) {
+		t.Errorf("decoded %s, want %s", decoded, data)
+	}
+
+	// nonces are random
+
+	if other, _ := codec.Encode(data); bytes.Equal(entry, other) {
+		t.Errorf("entries are equal")
+	}
+}
+
+func TestCodec_Tampered(t *testing.T) {
+	codec, _ := encryption.New(1, map[uint32][]byte{1: key1, 2: key2})
+	entry, _ := codec.Encode([]byte("personal data"))
+
+	for i := range entry {
+		tampered := append([]byte(nil), entry...)
+		tampered[i] ^= 0x01
+
+		if _, err := codec.Decode(tampered); err == nil {
+			t.Errorf("tampered byte %d is not detected", i)
+		}
+	}
+
+	if _, err := codec.Decode(entry[:len(entry)-1]); !errors.Is(err, encryption.ErrTampered) {
+		t.Errorf("error %v, want %v", err, encryption.ErrTampered)
+	}
+
+	if _, err := codec.Decode(entry[:3]); !errors.Is(err, encryption.ErrMalformed) {
+		t.Errorf("error %v, want %v", err, encryption.ErrMalformed)
+	}
+
+	// the key ID is authenticated
+
+	tampered := append([]byte(nil), entry...)
+	tampered[1] = 2
+	if _, err := codec.Decode(tampered); !errors.Is(err, encryption.ErrTampered) {
+		t.Errorf("error %v, want %v", err, encryption.ErrTampered)
+	}
+}
+
+func TestCodec_Rotation(t *testing.T) {
+	old, _ := encryption.New(1, map[uint32][]byte{1: key1})
+	entry, _ := old.Encode([]byte("value"))
+
+	rotated, _ := encryption.New(2, map[uint32][]byte{1: key1, 2: key2})
+
+	if data, err := rotated.Decode(entry); err != nil || string(data) != "value" {
+		t.Errorf("decoded %s, want %v", data, "value")
+	}
+
+	reencrypted, _ := rotated.Encode([]byte("value"))
+	if id, _ := rotated.KeyID(reencrypted); id != 2 {
+		t.Errorf("key ID %v, want %v", id, 2)
+	}
+
+	// the retired key is removed
+
+	retired, _ := encryption.New(2, map[uint32][]byte{2: key2})
+
+	if _, err := retired.Decode(entry); !errors.Is(err, encryption.ErrUnknownKey) {
+		t.Errorf("error %v, want %v", err, encryption.ErrUnknownKey)
+	}
+
+	if _, err := retired.Decode(reencrypted); err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+}
+
+func TestCodec_WAL(t *testing.T) {
+	dir := t.TempDir()
+	codec, _ := encryption.New(1, map[uint32][]byte{1: key1})
+
+	log, _ := wal.Open(dir, wal.WithCodec(codec))
+	log.Put("user@example.com", "personal data", 0)
+	log.Compact()
+	log.Put("other@example.com", "personal data", 0)
+	log.Close()
+
+	files, _ := filepath.Glob(filepath.Join(dir, "*"))
+	for _, file := range files {
+		data, _ := ioutil.ReadFile(file)
+		if bytes.Contains(data, []byte("example.com")) || bytes.Contains(data, []byte("personal data")) {
+			t.Errorf("%s contains plaintext", file)
+		}
+	}
+
+	log, _ = wal.Open(dir, wal.WithCodec(codec))
+	if entries, err := log.Entries(); err != nil || len(entries) != 2 {
+		t.Errorf("entries %v, error %v", entries, err)
+	}
+	log.Close()
+
+	// the log can't be read with another key
+
+	other, _ := encryption.New(1, map[uint32][]byte{1: key2})
+	log, _ = wal.Open(dir, wal.WithCodec(other))
+	defer log.Close()
+
+	if _, err := log.Entries(); !errors.Is(err, encryption.ErrTampered) {
+		t.Errorf("error %v, want %v", err, encryption.ErrTampered)
+	}
+}
+
+func TestCodec_Marshaling(t *testing.T) {
+	dir := t.TempDir()
+	codec, _ := encryption.New(1, map[uint32][]byte{1: key1})
+
+	l2, _ := disk.Open(dir)
+	defer l2.Close()
+
+	marshal, unmarshal := codec.Marshaling(
+		func(value interface{}) ([]byte, error) {
+			return []byte(value.(string)), nil
+		},
+		func(data []byte) (interface{}, error) {
+			return string(data), nil
+		},
+	)
+
+	var cache *tiered.Cache
+	l1, _ := lru.New(1, lru.WithEvictionHook(func(key string, value interface{}) {
+		cache.Evicted(key, value)
+	}))
+	cache = tiered.New(l1, l2, tiered.WithKeyMarshaling(marshal, unmarshal))
+
+	cache.Put("1", "personal data")
+	cache.Put("2", "other data")
+
+	segments, _ := filepath.Glob(filepath.Join(dir, "*.seg"))
+	data, _ := ioutil.ReadFile(segments[0])
+	if bytes.Contains(data, []byte("personal data")) {
+		t.Errorf("%s contains plaintext", segments[0])
+	}
+
+	if value, ok, err := cache.Get("1"); err != nil || !ok || value != "personal data" {
+		t.Errorf("cached value %v, want %v", value, "personal data")
+	}
+
+	// entries copied to other keys in L2 fail authentication
+	entry, _, _ := l2.Get("2")
+	l2.Put("3", entry)
+	if _, _, err := cache.Get("3"); !errors.Is(err, encryption.ErrTampered) {
+		t.Errorf("error %v, want %v", err, encryption.ErrTampered)
+	}
+
+	entry1, _ := marshal("1", "personal data")
+	entry2, _ := marshal("2", "other data")
+	if _, err := unmarshal("1", entry2); !errors.Is(err, encryption.ErrTampered) {
+		t.Errorf("error %v, want %v", err, encryption.ErrTampered)
+	}
+	if value, err := unmarshal("1", entry1); err != nil || value != "personal data" {
+		t.Errorf("decoded value %v, error %v, want %v", value, err, "personal data")
+	}
+}
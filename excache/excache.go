@@ -3,8 +3,17 @@ package excache
 import (
 	"container/heap"
 	"fmt"
+	"sort"
+	"strings"
 	"sync"
 	"time"
+
+	"github.com/faroyam/caches/internal/lease"
+	"github.com/faroyam/caches/internal/radix"
+	"github.com/faroyam/caches/internal/tagindex"
+	"github.com/faroyam/caches/internal/watchhub"
+	"github.com/faroyam/caches/wal"
+	"github.com/faroyam/caches/watch"
 )
 
 // Cache represents safe for concurrent use passive expiring cache.
@@ -16,20 +25,101 @@ type Cache struct {
 
 	expireQueue expireQueue
 	cache       map[string]*record
+	tags        tagindex.Index
+	index       *radix.Tree
+	// version is the last version assigned to a record
+	version uint64
+
+	evictionHooks []func(key string, value interface{})
+	log           *wal.Log
+
+	leases lease.Leases
+
+	watchers watchhub.Hub
+
+	// generations count writes of keys by their hash, so that GetOrLoad does not cache
+	// a value loaded before a concurrent write or delete of the key
+	generations []uint64
+
+	// negative records are kept in their own queue,
+	// so that they can be bounded by negativeCapacity instead of capacity
+	negativeQueue    expireQueue
+	negativeCapacity int
+}
+
+// Option configures a cache instance
+type Option func(*Cache)
+
+// WithPrefixIndex keeps the keys of the cache in a radix tree,
+// so that KeysWithPrefix and DeletePrefix take time proportional to the number of matching keys
+// instead of the number of records in the cache.
+func WithPrefixIndex() Option {
+	return func(c *Cache) {
+		c.index = radix.New()
+	}
+}
+
+// WithEvictionHook registers f to be called with the key and the value of every record
+// evicted to free space for a new one or to fit a reduced capacity.
+// f is called under the cache lock and must not use the cache.
+func WithEvictionHook(f func(key string, value interface{})) Option {
+	return func(c *Cache) {
+		c.evictionHooks = append(c.evictionHooks, f)
+	}
+}
+
+// WithWAL makes the cache append every change to the write-ahead log
+// and restores the records of the log when the cache is created.
+// TTL resets on Get and negative records are not logged,
+// so restored records expire according to their last write.
+// Errors of the log are not returned by the cache methods and should be checked with log.Err.
+func WithWAL(log *wal.Log) Option {
+	return func(c *Cache) {
+		c.log = log
+	}
 }
 
 // New returns an initialized cache instance
-func New(capacity int) (*Cache, error) {
+func New(capacity int, options ...Option) (*Cache, error) {
 	if capacity <= 0 {
 		return nil, fmt.Errorf("capacity can't be negative")
 	}
-	return &Cache{
+	c := &Cache{
 		m:        &sync.Mutex{},
 		capacity: capacity,
 
 		expireQueue: make(expireQueue, 0, capacity),
 		cache:       make(map[string]*record, capacity),
-	}, nil
+		tags:        make(tagindex.Index),
+		leases:      lease.Leases{TTL: lease.DefaultTTL},
+		generations: make([]uint64, generationsCount),
+
+		watchers: watchhub.New(),
+	}
+
+	for _, option := range options {
+		option(c)
+	}
+
+	if c.leases.TTL <= 0 {
+		return nil, fmt.Errorf("lease ttl must be positive")
+	}
+
+	if c.negativeCapacity < 0 {
+		return nil, fmt.Errorf("negative capacity can't be negative")
+	}
+
+	err := c.watchers.Init()
+	if err != nil {
+		return nil, err
+	}
+
+	if c.log != nil {
+		if err = c.replay(); err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
 }
 
 // Get returns (value, true) or (nil, false) for a given key.
@@ -40,46 +130,245 @@ func (c *Cache) Get(key string) (interface{}, bool) {
 
 	c.expire()
 
-	e, ok := c.cache[key]
-	if !ok {
-		return nil, false
+	return c.get(key)
+}
+
+// Put inserts new record into the cache.
+// Tags of an existing record are kept.
+func (c *Cache) Put(key string, value interface{}, ttl time.Duration) {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	c.expire()
+
+	c.put(key, value, ttl)
+}
+
+// PutWithTags inserts a new record tagged with the given tags into the cache.
+// Tags of an existing record are replaced.
+func (c *Cache) PutWithTags(key string, value interface{}, ttl time.Duration, tags ...string) {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	c.expire()
+
+	c.put(key, value, ttl)
+	c.setTags(c.cache[key], tags)
+}
+
+// InvalidateTag removes all records tagged with the tag from the cache.
+// Returns the number of removed records that have not expired yet.
+func (c *Cache) InvalidateTag(tag string) int {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	c.expire()
+
+	keys := c.tags.Keys(tag)
+	for _, key := range keys {
+		c.delete(key)
+	}
+	return len(keys)
+}
+
+// GetOrPut returns (value, true) for a given key if it exists and resets its TTL.
+// Otherwise it inserts a new record and returns (value, false).
+func (c *Cache) GetOrPut(key string, value interface{}, ttl time.Duration) (interface{}, bool) {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	c.expire()
+
+	if v, ok := c.get(key); ok {
+		return v, true
 	}
 
-	c.expireQueue.update(e, e.value, e.ttl, time.Now().Add(e.ttl).UnixNano())
+	c.put(key, value, ttl)
+	return value, false
+}
+
+// PutIfAbsent inserts a new record into the cache if the key does not exist.
+// Returns true if the record was inserted.
+func (c *Cache) PutIfAbsent(key string, value interface{}, ttl time.Duration) bool {
+	c.m.Lock()
+	defer c.m.Unlock()
 
-	return e.value, true
+	c.expire()
+
+	if _, ok := c.cache[key]; ok {
+		return false
+	}
+
+	c.put(key, value, ttl)
+	return true
 }
 
-// Put inserts new record into the cache
-func (c *Cache) Put(key string, value interface{}, ttl time.Duration) {
+// Compute atomically replaces the value for a given key with the one returned by f
+// and sets the TTL of the record.
+// f receives the current value and whether the key exists.
+// If f returns keep == false, the record is removed from the cache.
+// Returns the new value and keep.
+// f is called under the cache lock and must not use the cache.
+func (c *Cache) Compute(key string, ttl time.Duration, f func(old interface{}, exists bool) (value interface{}, keep bool)) (interface{}, bool) {
 	c.m.Lock()
 	defer c.m.Unlock()
 
 	c.expire()
 
-	if len(c.cache) >= c.capacity {
-		if c.expireQueue[0].key != key {
-			r := c.expireQueue.Pop().(*record)
-			delete(c.cache, r.key)
-		}
+	var old interface{}
+	r, exists := c.cache[key]
+	if exists {
+		old = r.value
 	}
 
+	value, keep := f(old, exists)
+	if !keep {
+		c.delete(key)
+		return value, false
+	}
+
+	c.put(key, value, ttl)
+	return value, true
+}
+
+// CompareAndSwap replaces the value for a given key with new and sets the TTL of the record
+// if the current value equals old.
+// Returns true if the value was replaced.
+// Values are compared with ==, so old must be comparable.
+func (c *Cache) CompareAndSwap(key string, old, new interface{}, ttl time.Duration) bool {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	c.expire()
+
 	r, ok := c.cache[key]
+	if !ok || r.value != old {
+		return false
+	}
+
+	c.put(key, new, ttl)
+	return true
+}
+
+// GetVersioned returns (value, version, true) or (nil, 0, false) for a given key.
+// Versions are positive and increase with every write of the record.
+// They are never reused within the cache, even if the record is deleted and inserted again,
+// so a version identifies a value for PutIfVersion.
+// Resets TTL.
+func (c *Cache) GetVersioned(key string) (interface{}, uint64, bool) {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	c.expire()
+
+	value, ok := c.get(key)
 	if !ok {
-		r = &record{
-			key:             key,
-			value:           value,
-			ttl:             ttl,
-			expireTimeStamp: time.Now().Add(ttl).UnixNano(),
-		}
+		return nil, 0, false
+	}
+	return value, c.cache[key].version, true
+}
 
-		heap.Push(&c.expireQueue, r)
-		c.cache[key] = r
+// PutIfVersion inserts a new record into the cache if the current version of the key equals version,
+// version 0 means that the key must not exist or must have expired.
+// Returns the new version and true if the record was inserted,
+// or the current version and false otherwise.
+func (c *Cache) PutIfVersion(key string, value interface{}, ttl time.Duration, version uint64) (uint64, bool) {
+	c.m.Lock()
+	defer c.m.Unlock()
 
-		return
+	c.expire()
+
+	var current uint64
+	if r, ok := c.cache[key]; ok {
+		current = r.version
+	}
+	if current != version {
+		return current, false
 	}
 
-	c.expireQueue.update(r, value, ttl, time.Now().Add(ttl).UnixNano())
+	c.put(key, value, ttl)
+	return c.version, true
+}
+
+// Increment atomically adds delta to the integer value for a given key,
+// sets the TTL of the record and returns the result.
+// A missing key is treated as 0.
+// Returns an error if the current value is not an int or int64.
+func (c *Cache) Increment(key string, delta int64, ttl time.Duration) (int64, error) {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	c.expire()
+
+	var old interface{} = int64(0)
+	if r, ok := c.cache[key]; ok {
+		old = r.value
+	}
+
+	value, n, err := add(old, delta)
+	if err != nil {
+		return 0, fmt.Errorf("can't increment %q: %w", key, err)
+	}
+
+	c.put(key, value, ttl)
+	return n, nil
+}
+
+// Decrement atomically subtracts delta from the integer value for a given key,
+// sets the TTL of the record and returns the result.
+// A missing key is treated as 0.
+// Returns an error if the current value is not an int or int64.
+func (c *Cache) Decrement(key string, delta int64, ttl time.Duration) (int64, error) {
+	return c.Increment(key, -delta, ttl)
+}
+
+// Peek returns (value, true) or (nil, false) for a given key.
+// Does not reset TTL.
+func (c *Cache) Peek(key string) (interface{}, bool) {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	r, ok := c.cache[key]
+	if !ok || r.expired(time.Now().UnixNano()) {
+		return nil, false
+	}
+	return r.value, true
+}
+
+// Contains reports whether the key is in the cache and has not expired yet.
+// Does not reset TTL.
+func (c *Cache) Contains(key string) bool {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	r, ok := c.cache[key]
+	return ok && !r.expired(time.Now().UnixNano())
+}
+
+// Keys returns the keys of not expired records in eviction order
+// i.e. the key that expires first comes first.
+// The keys are a snapshot taken under the lock: writes made after Keys returns are not reflected.
+func (c *Cache) Keys() []string {
+	records := c.snapshot()
+
+	keys := make([]string, 0, len(records))
+	for _, r := range records {
+		keys = append(keys, r.key)
+	}
+	return keys
+}
+
+// Range calls f sequentially for each key and value of not expired records in eviction order.
+// If f returns false, Range stops the iteration.
+// Range iterates over a snapshot taken under the lock and calls f without holding it,
+// so f may use the cache, but writes made during the iteration are not reflected.
+// Does not reset TTL.
+func (c *Cache) Range(f func(key string, value interface{}) bool) {
+	for _, r := range c.snapshot() {
+		if !f(r.key, r.value) {
+			return
+		}
+	}
 }
 
 // Delete removes the record associated with the specified key from the cache
@@ -89,13 +378,79 @@ func (c *Cache) Delete(key string) {
 
 	c.expire()
 
-	r, ok := c.cache[key]
-	if !ok {
-		return
+	c.delete(key)
+}
+
+// GetMany returns values for the given keys that exist in the cache.
+// Missing keys are absent from the result.
+// Resets TTL of every found record.
+// Takes the lock once and is equivalent to calling Get for each key.
+func (c *Cache) GetMany(keys []string) map[string]interface{} {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	c.expire()
+
+	values := make(map[string]interface{}, len(keys))
+	for _, key := range keys {
+		if value, ok := c.get(key); ok {
+			values[key] = value
+		}
+	}
+	return values
+}
+
+// PutMany inserts the given records into the cache.
+// Takes the lock once and is equivalent to calling Put for each record
+// in the iteration order of the map.
+func (c *Cache) PutMany(values map[string]interface{}, ttl time.Duration) {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	c.expire()
+
+	for key, value := range values {
+		c.put(key, value, ttl)
+	}
+}
+
+// DeleteMany removes the records associated with the given keys from the cache.
+// Takes the lock once and is equivalent to calling Delete for each key.
+func (c *Cache) DeleteMany(keys []string) {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	c.expire()
+
+	for _, key := range keys {
+		c.delete(key)
 	}
+}
+
+// KeysWithPrefix returns the keys of not expired records starting with the prefix in lexicographic order.
+// Does not "use" records.
+func (c *Cache) KeysWithPrefix(prefix string) []string {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	c.expire()
+
+	return c.keysWithPrefix(prefix)
+}
+
+// DeletePrefix removes the records which keys start with the prefix from the cache.
+// Returns the number of removed records.
+func (c *Cache) DeletePrefix(prefix string) int {
+	c.m.Lock()
+	defer c.m.Unlock()
 
-	heap.Remove(&c.expireQueue, r.index)
-	delete(c.cache, key)
+	c.expire()
+
+	keys := c.keysWithPrefix(prefix)
+	for _, key := range keys {
+		c.delete(key)
+	}
+	return len(keys)
 }
 
 // Clear removes all saved records
@@ -103,8 +458,27 @@ func (c *Cache) Clear() {
 	c.m.Lock()
 	defer c.m.Unlock()
 
+	if c.log != nil {
+		c.log.Clear()
+	}
+	if c.watchers.Active() {
+		c.expire()
+		for key, r := range c.cache {
+			c.watchers.Publish(watch.Delete, key, r.value)
+		}
+	}
+
 	c.expireQueue = make(expireQueue, 0, c.capacity)
+	c.negativeQueue = nil
 	c.cache = make(map[string]*record, c.capacity)
+	c.tags = make(tagindex.Index)
+	if c.index != nil {
+		c.index = radix.New()
+	}
+	c.leases.Clear()
+	for i := range c.generations {
+		c.generations[i]++
+	}
 }
 
 // Len returns the number of records in the cache
@@ -117,6 +491,34 @@ func (c *Cache) Len() int {
 	return len(c.cache)
 }
 
+// Cap returns the maximum number of records in the cache
+func (c *Cache) Cap() int {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	return c.capacity
+}
+
+// Resize changes the capacity of the cache.
+// Shrinking removes expired records first and then evicts records
+// that expire first until the cache fits the new capacity.
+func (c *Cache) Resize(capacity int) error {
+	if capacity <= 0 {
+		return fmt.Errorf("capacity can't be negative")
+	}
+
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	c.expire()
+
+	c.capacity = capacity
+	for c.len() > c.capacity {
+		c.evict(false)
+	}
+	return nil
+}
+
 // Expire removes old records
 func (c *Cache) Expire() {
 	c.m.Lock()
@@ -128,15 +530,235 @@ func (c *Cache) Expire() {
 func (c *Cache) expire() {
 	now := time.Now().UnixNano()
 
-	for c.expireQueue.Len() > 0 && now >= c.expireQueue[0].expireTimeStamp {
-		r := c.expireQueue.Pop().(*record)
-		delete(c.cache, r.key)
+	for _, q := range []*expireQueue{&c.expireQueue, &c.negativeQueue} {
+		for q.Len() > 0 && (*q)[0].expired(now) {
+			r := heap.Pop(q).(*record)
+			if c.log != nil && !r.negative {
+				c.log.Expire(r.key)
+			}
+			c.remove(r)
+			c.watchers.Publish(watch.Expire, r.key, r.value)
+		}
+	}
+}
+
+// get resets TTL of positive records only,
+// so that frequently requested negative records still expire
+func (c *Cache) get(key string) (interface{}, bool) {
+	r, ok := c.cache[key]
+	if !ok {
+		return nil, false
+	}
+
+	if !r.negative {
+		c.expireQueue.update(r, r.value, r.ttl, time.Now().Add(r.ttl).UnixNano())
+	}
+
+	return r.value, true
+}
+
+func (c *Cache) put(key string, value interface{}, ttl time.Duration) {
+	c.insert(key, value, ttl, false)
+}
+
+func (c *Cache) insert(key string, value interface{}, ttl time.Duration, negative bool) {
+	c.leases.Written(key)
+	c.generations[slot(key)]++
+
+	expireTimeStamp := time.Now().Add(ttl).UnixNano()
+	if c.log != nil && !negative {
+		c.log.Put(key, value, expireTimeStamp)
+	}
+
+	c.version++
+	r, ok := c.cache[key]
+	if ok && r.negative == negative {
+		c.queue(r).update(r, value, ttl, expireTimeStamp)
+		r.version = c.version
+		c.watchers.Publish(watch.Update, key, value)
+		return
+	}
+
+	// a positive record replacing a negative one or vice versa moves to another queue
+	var tags []string
+	if ok {
+		tags = r.tags
+		c.unlink(r)
+	}
+
+	if c.full(negative) {
+		c.evict(negative)
+	}
+
+	r = &record{
+		key:             key,
+		value:           value,
+		negative:        negative,
+		version:         c.version,
+		ttl:             ttl,
+		expireTimeStamp: expireTimeStamp,
+	}
+
+	heap.Push(c.queue(r), r)
+	c.cache[key] = r
+	c.setTags(r, tags)
+	if c.index != nil {
+		c.index.Insert(key)
+	}
+
+	if ok {
+		c.watchers.Publish(watch.Update, key, value)
+	} else {
+		c.watchers.Publish(watch.Put, key, value)
+	}
+}
+
+func (c *Cache) delete(key string) {
+	c.generations[slot(key)]++
+
+	r, ok := c.cache[key]
+	if !ok {
+		c.leases.Deleted(key, nil, false)
+		return
+	}
+
+	c.leases.Deleted(key, r.value, true)
+	c.unlink(r)
+	c.watchers.Publish(watch.Delete, key, r.value)
+}
+
+// unlink removes the record from its queue, the map and the indexes
+func (c *Cache) unlink(r *record) {
+	if c.log != nil && !r.negative {
+		c.log.Delete(r.key)
+	}
+	c.remove(heap.Remove(c.queue(r), r.index).(*record))
+}
+
+// len returns the number of records counted toward capacity
+func (c *Cache) len() int {
+	if c.negativeCapacity == 0 {
+		return len(c.cache)
+	}
+	return c.expireQueue.Len()
+}
+
+// full reports whether a new positive or negative record requires eviction
+func (c *Cache) full(negative bool) bool {
+	if negative && c.negativeCapacity > 0 {
+		return c.negativeQueue.Len() >= c.negativeCapacity
+	}
+	return c.len() >= c.capacity
+}
+
+// evict removes the record that expires first
+// among the records sharing capacity with a new positive or negative record
+func (c *Cache) evict(negative bool) {
+	q := &c.expireQueue
+	switch {
+	case c.negativeCapacity > 0:
+		if negative {
+			q = &c.negativeQueue
+		}
+	case q.Len() == 0 || c.negativeQueue.Len() > 0 && c.negativeQueue[0].expireTimeStamp < (*q)[0].expireTimeStamp:
+		q = &c.negativeQueue
+	}
+	r := heap.Pop(q).(*record)
+	if c.log != nil && !r.negative {
+		c.log.Delete(r.key)
+	}
+	c.remove(r)
+	for _, hook := range c.evictionHooks {
+		hook(r.key, r.value)
+	}
+	c.watchers.Publish(watch.Evict, r.key, r.value)
+}
+
+func (c *Cache) queue(r *record) *expireQueue {
+	if r.negative {
+		return &c.negativeQueue
+	}
+	return &c.expireQueue
+}
+
+// remove removes the record popped from the expire queue from the map and the indexes
+func (c *Cache) remove(r *record) {
+	delete(c.cache, r.key)
+	c.tags.Remove(r.key, r.tags)
+	if c.index != nil {
+		c.index.Delete(r.key)
+	}
+}
+
+// replay inserts the records of the log into the cache without logging them again
+func (c *Cache) replay() error {
+	entries, err := c.log.Entries()
+	if err != nil {
+		return err
+	}
+
+	log := c.log
+	c.log = nil
+	for _, e := range entries {
+		c.put(e.Key, e.Value, time.Until(time.Unix(0, e.ExpireAt)))
+	}
+	c.log = log
+	return nil
+}
+
+// keysWithPrefix uses the prefix index if it is enabled and scans all keys otherwise
+func (c *Cache) keysWithPrefix(prefix string) []string {
+	var keys []string
+	if c.index != nil {
+		c.index.WalkPrefix(prefix, func(key string) bool {
+			keys = append(keys, key)
+			return true
+		})
+		return keys
+	}
+
+	for key := range c.cache {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (c *Cache) setTags(r *record, tags []string) {
+	c.tags.Remove(r.key, r.tags)
+
+	r.tags = append([]string(nil), tags...)
+	c.tags.Add(r.key, r.tags)
+}
+
+// snapshot returns copies of not expired records ordered by expiration time
+func (c *Cache) snapshot() []record {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	now := time.Now().UnixNano()
+
+	records := make([]record, 0, len(c.cache))
+	for _, r := range c.cache {
+		if !r.expired(now) {
+			records = append(records, *r)
+		}
 	}
+
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].expireTimeStamp < records[j].expireTimeStamp
+	})
+	return records
 }
 
 type record struct {
-	key   string
-	value interface{}
+	key      string
+	value    interface{}
+	tags     []string
+	negative bool
+	version  uint64
 
 	ttl             time.Duration
 	expireTimeStamp int64
@@ -144,12 +766,16 @@ type record struct {
 	index int
 }
 
+func (r *record) expired(now int64) bool {
+	return now >= r.expireTimeStamp
+}
+
 type expireQueue []*record
 
 func (q expireQueue) Len() int { return len(q) }
 
 func (q expireQueue) Less(i, j int) bool {
-	return q[i].expireTimeStamp > q[j].expireTimeStamp
+	return q[i].expireTimeStamp < q[j].expireTimeStamp
 }
 
 func (q expireQueue) Swap(i, j int) {
@@ -181,3 +807,18 @@ func (q *expireQueue) update(oldRecord *record, value interface{}, ttl time.Dura
 	oldRecord.expireTimeStamp = expireTimeStamp
 	heap.Fix(q, oldRecord.index)
 }
+
+// add returns the sum of an int or int64 value and delta
+// both as a value of the original type and as int64
+func add(value interface{}, delta int64) (interface{}, int64, error) {
+	switch v := value.(type) {
+	case int:
+		n := v + int(delta)
+		return n, int64(n), nil
+	case int64:
+		n := v + delta
+		return n, n, nil
+	default:
+		return nil, 0, fmt.Errorf("value of type %T is not an integer", value)
+	}
+}
@@ -1,10 +1,13 @@
 package excache_test
 
 import (
+	"reflect"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/faroyam/caches/excache"
+	"github.com/faroyam/caches/wal"
 )
 
 const (
@@ -80,6 +83,95 @@ func TestCache_Get_ResetsTTL(t *testing.T) {
 	}
 }
 
+func TestCache_Peek(t *testing.T) {
+	cache, _ := excache.New(2)
+	cache.Put(key, value, time.Millisecond*100)
+	cache.Put("expired-key", value, time.Millisecond)
+
+	time.Sleep(time.Millisecond * 70)
+
+	if v, ok := cache.Peek(key); !ok || v != value {
+		t.Errorf("cached value %v, want %v", v, value)
+	}
+
+	if v, ok := cache.Peek("expired-key"); ok {
+		t.Errorf("cached value %v, want %v", v, "nil")
+	}
+
+	time.Sleep(time.Millisecond * 70)
+
+	if v, ok := cache.Peek(key); ok {
+		t.Errorf("cached value %v, want %v", v, "nil")
+	}
+}
+
+func TestCache_Contains(t *testing.T) {
+	cache, _ := excache.New(2)
+	cache.Put(key, value, time.Second)
+	cache.Put("expired-key", value, time.Millisecond)
+
+	time.Sleep(time.Millisecond * 10)
+
+	if !cache.Contains(key) {
+		t.Errorf("contains %v, want %v", false, true)
+	}
+
+	if cache.Contains("expired-key") {
+		t.Errorf("contains %v, want %v", true, false)
+	}
+
+	if cache.Contains("non-existing-key") {
+		t.Errorf("contains %v, want %v", true, false)
+	}
+}
+
+func TestCache_Keys(t *testing.T) {
+	cache, _ := excache.New(4)
+	if keys := cache.Keys(); len(keys) != 0 {
+		t.Errorf("keys %v, want %v", keys, []string{})
+	}
+
+	cache.Put("1", "1", time.Second*3)
+	cache.Put("2", "2", time.Second)
+	cache.Put("3", "3", time.Second*2)
+	cache.Put("4", "4", time.Millisecond)
+
+	time.Sleep(time.Millisecond * 10)
+
+	want := []string{"2", "3", "1"}
+	if keys := cache.Keys(); !reflect.DeepEqual(keys, want) {
+		t.Errorf("keys %v, want %v", keys, want)
+	}
+}
+
+func TestCache_Range(t *testing.T) {
+	cache, _ := excache.New(3)
+	cache.Put("1", 1, time.Second)
+	cache.Put("2", 2, time.Second*2)
+	cache.Put("3", 3, time.Second*3)
+
+	var keys []string
+	var values []interface{}
+	cache.Range(func(key string, value interface{}) bool {
+		keys = append(keys, key)
+		values = append(values, value)
+		cache.Delete(key)
+		return key != "2"
+	})
+
+	if want := []string{"1", "2"}; !reflect.DeepEqual(keys, want) {
+		t.Errorf("keys %v, want %v", keys, want)
+	}
+
+	if want := []interface{}{1, 2}; !reflect.DeepEqual(values, want) {
+		t.Errorf("values %v, want %v", values, want)
+	}
+
+	if cache.Len() != 1 {
+		t.Errorf("cache len %v, want %v", cache.Len(), 1)
+	}
+}
+
 func TestCache_Delete(t *testing.T) {
 	cache, _ := excache.New(1)
 	cache.Put(key, value, time.Second)
@@ -92,6 +184,172 @@ func TestCache_Delete(t *testing.T) {
 	}
 }
 
+func TestCache_GetMany(t *testing.T) {
+	cache, _ := excache.New(3)
+	cache.Put("1", 1, time.Second)
+	cache.Put("2", 2, time.Second)
+
+	want := map[string]interface{}{"1": 1, "2": 2}
+	if values := cache.GetMany([]string{"1", "2", "non-existing-key"}); !reflect.DeepEqual(values, want) {
+		t.Errorf("cached values %v, want %v", values, want)
+	}
+}
+
+func TestCache_PutMany(t *testing.T) {
+	cache, _ := excache.New(2)
+	cache.PutMany(map[string]interface{}{"1": 1, "2": 2, "3": 3}, time.Second)
+
+	if cache.Len() != 2 {
+		t.Errorf("cache len %v, want %v", cache.Len(), 2)
+	}
+
+	cache.PutMany(map[string]interface{}{"4": 4}, time.Second)
+
+	if value, ok := cache.Get("4"); !ok || value != 4 {
+		t.Errorf("cached value %v, want %v", value, 4)
+	}
+}
+
+func TestCache_DeleteMany(t *testing.T) {
+	cache, _ := excache.New(3)
+	cache.Put("1", 1, time.Second)
+	cache.Put("2", 2, time.Second)
+	cache.Put("3", 3, time.Second)
+
+	cache.DeleteMany([]string{"1", "3", "non-existing-key"})
+
+	if want := []string{"2"}; !reflect.DeepEqual(cache.Keys(), want) {
+		t.Errorf("keys %v, want %v", cache.Keys(), want)
+	}
+}
+
+func TestCache_InvalidateTag(t *testing.T) {
+	cache, _ := excache.New(4)
+	cache.PutWithTags("user/1", 1, time.Second, "user", "tenant/a")
+	cache.PutWithTags("user/2", 2, time.Second, "user", "tenant/b")
+	cache.PutWithTags("order/1", 3, time.Second, "tenant/a")
+	cache.Put("4", 4, time.Second*2)
+
+	if n := cache.InvalidateTag("tenant/a"); n != 2 {
+		t.Errorf("invalidated %v, want %v", n, 2)
+	}
+
+	if want := []string{"user/2", "4"}; !reflect.DeepEqual(cache.Keys(), want) {
+		t.Errorf("keys %v, want %v", cache.Keys(), want)
+	}
+
+	cache.Put("user/2", 20, time.Second)
+	if n := cache.InvalidateTag("user"); n != 1 {
+		t.Errorf("invalidated %v, want %v", n, 1)
+	}
+
+	if n := cache.InvalidateTag("non-existing-tag"); n != 0 {
+		t.Errorf("invalidated %v, want %v", n, 0)
+	}
+}
+
+func TestCache_PutWithTags_Replace(t *testing.T) {
+	cache, _ := excache.New(2)
+	cache.PutWithTags("key", 1, time.Second, "a")
+	cache.PutWithTags("key", 2, time.Second, "b")
+
+	if n := cache.InvalidateTag("a"); n != 0 {
+		t.Errorf("invalidated %v, want %v", n, 0)
+	}
+
+	if n := cache.InvalidateTag("b"); n != 1 {
+		t.Errorf("invalidated %v, want %v", n, 1)
+	}
+}
+
+func TestCache_InvalidateTag_Removed(t *testing.T) {
+	cache, _ := excache.New(1)
+	cache.PutWithTags("1", 1, time.Second, "tag")
+	cache.PutWithTags("2", 2, time.Second, "tag")
+
+	// "1" is evicted
+
+	if n := cache.InvalidateTag("tag"); n != 1 {
+		t.Errorf("invalidated %v, want %v", n, 1)
+	}
+
+	cache.PutWithTags("3", 3, time.Second, "tag")
+	cache.Delete("3")
+
+	if n := cache.InvalidateTag("tag"); n != 0 {
+		t.Errorf("invalidated %v, want %v", n, 0)
+	}
+
+	cache.PutWithTags("4", 4, time.Second, "tag")
+	cache.Clear()
+
+	if n := cache.InvalidateTag("tag"); n != 0 {
+		t.Errorf("invalidated %v, want %v", n, 0)
+	}
+}
+
+func TestCache_InvalidateTag_Expired(t *testing.T) {
+	cache, _ := excache.New(2)
+	cache.PutWithTags("1", 1, time.Millisecond, "tag")
+	cache.PutWithTags("2", 2, time.Second, "tag")
+
+	time.Sleep(time.Millisecond * 10)
+
+	if n := cache.InvalidateTag("tag"); n != 1 {
+		t.Errorf("invalidated %v, want %v", n, 1)
+	}
+
+	if cache.Len() != 0 {
+		t.Errorf("cache len %v, want %v", cache.Len(), 0)
+	}
+}
+
+func TestCache_KeysWithPrefix(t *testing.T) {
+	for _, options := range [][]excache.Option{nil, {excache.WithPrefixIndex()}} {
+		cache, _ := excache.New(3, options...)
+		cache.Put("org/1/repo/2", 1, time.Millisecond*500)
+		cache.Put("org/1/repo/1", 2, time.Second)
+		cache.Put("org/12", 3, time.Second)
+		cache.Put("org/2", 4, time.Second)
+
+		// "org/1/repo/2" is evicted
+
+		want := []string{"org/1/repo/1", "org/12"}
+		if keys := cache.KeysWithPrefix("org/1"); !reflect.DeepEqual(keys, want) {
+			t.Errorf("keys %v, want %v", keys, want)
+		}
+
+		if keys := cache.KeysWithPrefix("user/"); len(keys) != 0 {
+			t.Errorf("keys %v, want %v", keys, []string{})
+		}
+	}
+}
+
+func TestCache_DeletePrefix(t *testing.T) {
+	for _, options := range [][]excache.Option{nil, {excache.WithPrefixIndex()}} {
+		cache, _ := excache.New(4, options...)
+		cache.Put("org/1/repo/1", 1, time.Second)
+		cache.Put("org/1/repo/2", 2, time.Second)
+		cache.Put("org/12", 3, time.Second)
+		cache.Put("org/2", 4, time.Second)
+
+		if n := cache.DeletePrefix("org/1/"); n != 2 {
+			t.Errorf("deleted %v, want %v", n, 2)
+		}
+
+		if want := []string{"org/12", "org/2"}; !reflect.DeepEqual(cache.KeysWithPrefix(""), want) {
+			t.Errorf("keys %v, want %v", cache.KeysWithPrefix(""), want)
+		}
+
+		cache.Clear()
+		cache.Put("org/3", 5, time.Second)
+
+		if want := []string{"org/3"}; !reflect.DeepEqual(cache.KeysWithPrefix("org/"), want) {
+			t.Errorf("keys %v, want %v", cache.KeysWithPrefix("org/"), want)
+		}
+	}
+}
+
 func TestCache_Clear(t *testing.T) {
 	cache, _ := excache.New(10)
 	cache.Put("key1", "value1", time.Second)
@@ -103,6 +361,281 @@ func TestCache_Clear(t *testing.T) {
 	}
 }
 
+func TestCache_Resize(t *testing.T) {
+	cache, _ := excache.New(3)
+	if err := cache.Resize(0); err == nil {
+		t.Errorf("expected error")
+	}
+
+	cache.Put("1", 1, time.Second*3)
+	cache.Put("2", 2, time.Second*2)
+	cache.Put("3", 3, time.Second)
+
+	if err := cache.Resize(1); err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+
+	if cache.Cap() != 1 {
+		t.Errorf("cache cap %v, want %v", cache.Cap(), 1)
+	}
+
+	if cache.Len() != 1 {
+		t.Errorf("cache len %v, want %v", cache.Len(), 1)
+	}
+
+	if err := cache.Resize(2); err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+
+	cache.Put("4", 4, time.Second)
+
+	if cache.Len() != 2 {
+		t.Errorf("cache len %v, want %v", cache.Len(), 2)
+	}
+}
+
+func TestCache_GetOrPut(t *testing.T) {
+	cache, _ := excache.New(2)
+
+	if value, loaded := cache.GetOrPut("key", "value1", time.Second); loaded || value != "value1" {
+		t.Errorf("cached value %v, loaded %v, want %v, %v", value, loaded, "value1", false)
+	}
+
+	if value, loaded := cache.GetOrPut("key", "value2", time.Second); !loaded || value != "value1" {
+		t.Errorf("cached value %v, loaded %v, want %v, %v", value, loaded, "value1", true)
+	}
+}
+
+func TestCache_PutIfAbsent(t *testing.T) {
+	cache, _ := excache.New(2)
+
+	if ok := cache.PutIfAbsent("key", "value1", time.Second); !ok {
+		t.Errorf("put %v, want %v", ok, true)
+	}
+
+	if ok := cache.PutIfAbsent("key", "value2", time.Second); ok {
+		t.Errorf("put %v, want %v", ok, false)
+	}
+
+	if value, _ := cache.Get("key"); value != "value1" {
+		t.Errorf("cached value %v, want %v", value, "value1")
+	}
+}
+
+func TestCache_Compute(t *testing.T) {
+	cache, _ := excache.New(2)
+
+	appendValue := func(old interface{}, exists bool) (interface{}, bool) {
+		if !exists {
+			return "a", true
+		}
+		return old.(string) + "a", true
+	}
+
+	cache.Compute("key", time.Second, appendValue)
+	if value, keep := cache.Compute("key", time.Second, appendValue); !keep || value != "aa" {
+		t.Errorf("computed value %v, keep %v, want %v, %v", value, keep, "aa", true)
+	}
+
+	if value, _ := cache.Get("key"); value != "aa" {
+		t.Errorf("cached value %v, want %v", value, "aa")
+	}
+
+	cache.Compute("key", time.Second, func(old interface{}, exists bool) (interface{}, bool) {
+		return nil, false
+	})
+
+	if cache.Contains("key") {
+		t.Errorf("contains %v, want %v", true, false)
+	}
+}
+
+func TestCache_CompareAndSwap(t *testing.T) {
+	cache, _ := excache.New(2)
+
+	if ok := cache.CompareAndSwap("key", nil, "value", time.Second); ok {
+		t.Errorf("swapped %v, want %v", ok, false)
+	}
+
+	cache.Put("key", "value1", time.Second)
+
+	if ok := cache.CompareAndSwap("key", "value2", "value3", time.Second); ok {
+		t.Errorf("swapped %v, want %v", ok, false)
+	}
+
+	if ok := cache.CompareAndSwap("key", "value1", "value2", time.Second); !ok {
+		t.Errorf("swapped %v, want %v", ok, true)
+	}
+
+	if value, _ := cache.Get("key"); value != "value2" {
+		t.Errorf("cached value %v, want %v", value, "value2")
+	}
+}
+
+func TestCache_PutIfVersion(t *testing.T) {
+	cache, _ := excache.New(2)
+
+	if _, ok := cache.PutIfVersion("key", "value1", time.Minute, 1); ok {
+		t.Errorf("put %v, want %v", ok, false)
+	}
+
+	version, ok := cache.PutIfVersion("key", "value1", time.Minute, 0)
+	if !ok || version == 0 {
+		t.Fatalf("put %v, version %v, want a new version", ok, version)
+	}
+
+	if value, v, ok := cache.GetVersioned("key"); !ok || value != "value1" || v != version {
+		t.Errorf("got %v, %v, %v, want %v, %v, %v", value, v, ok, "value1", version, true)
+	}
+
+	// every write bumps the version
+	cache.Put("key", "value2", time.Minute)
+	if current, ok := cache.PutIfVersion("key", "value3", time.Minute, version); ok || current <= version {
+		t.Errorf("put %v, version %v, want %v and a version greater than %v", ok, current, false, version)
+	}
+
+	// versions are not reused after a delete
+	_, version, _ = cache.GetVersioned("key")
+	cache.Delete("key")
+	cache.Put("key", "value4", time.Minute)
+	if _, ok := cache.PutIfVersion("key", "value5", time.Minute, version); ok {
+		t.Errorf("put %v with a version of a deleted record, want %v", ok, false)
+	}
+
+	if _, v, ok := cache.GetVersioned("missing"); ok || v != 0 {
+		t.Errorf("got version %v, %v for a missing key, want %v, %v", v, ok, 0, false)
+	}
+}
+
+func TestCache_Increment(t *testing.T) {
+	cache, _ := excache.New(3)
+
+	if n, err := cache.Increment("int64", 2, time.Second); err != nil || n != 2 {
+		t.Errorf("incremented value %v, error %v, want %v, %v", n, err, 2, nil)
+	}
+
+	if n, err := cache.Decrement("int64", 3, time.Second); err != nil || n != -1 {
+		t.Errorf("decremented value %v, error %v, want %v, %v", n, err, -1, nil)
+	}
+
+	cache.Put("int", 1, time.Second)
+
+	if n, err := cache.Increment("int", 1, time.Second); err != nil || n != 2 {
+		t.Errorf("incremented value %v, error %v, want %v, %v", n, err, 2, nil)
+	}
+
+	if value, _ := cache.Get("int"); value != 2 {
+		t.Errorf("cached value %v, want %v", value, 2)
+	}
+
+	cache.Put("string", "1", time.Second)
+
+	if _, err := cache.Increment("string", 1, time.Second); err == nil {
+		t.Errorf("expected error")
+	}
+}
+
+func TestCache_Atomic_Race(t *testing.T) {
+	const (
+		goroutines = 8
+		iterations = 1000
+	)
+
+	cache, _ := excache.New(10)
+
+	var wg sync.WaitGroup
+	for i := 0; i < goroutines; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for j := 0; j < iterations; j++ {
+				cache.Increment("counter", 1, time.Second)
+				cache.Compute("compute", time.Second, func(old interface{}, exists bool) (interface{}, bool) {
+					if !exists {
+						return 1, true
+					}
+					return old.(int) + 1, true
+				})
+				for {
+					old, _ := cache.GetOrPut("cas", 0, time.Second)
+					if cache.CompareAndSwap("cas", old, old.(int)+1, time.Second) {
+						break
+					}
+				}
+				for {
+					old, version, _ := cache.GetVersioned("versioned")
+					n, _ := old.(int)
+					if _, ok := cache.PutIfVersion("versioned", n+1, time.Second, version); ok {
+						break
+					}
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	for _, key := range []string{"counter", "compute", "cas", "versioned"} {
+		if n, _ := cache.Increment(key, 0, time.Second); n != goroutines*iterations {
+			t.Errorf("%v value %v, want %v", key, n, goroutines*iterations)
+		}
+	}
+}
+
+func TestCache_WithEvictionHook(t *testing.T) {
+	var evicted []string
+	cache, _ := excache.New(2, excache.WithEvictionHook(func(key string, value interface{}) {
+		evicted = append(evicted, key+"="+value.(string))
+	}))
+
+	cache.Put("1", "1", time.Second)
+	cache.Put("2", "2", time.Second)
+	cache.Delete("2")
+	cache.Put("3", "3", time.Second*2)
+	cache.Put("4", "4", time.Second*3)
+	cache.Resize(1)
+
+	if want := []string{"1=1", "3=3"}; !reflect.DeepEqual(evicted, want) {
+		t.Errorf("evicted %v, want %v", evicted, want)
+	}
+}
+
+func TestCache_WithWAL(t *testing.T) {
+	dir := t.TempDir()
+	log, _ := wal.Open(dir)
+	cache, _ := excache.New(10, excache.WithWAL(log))
+
+	cache.Put("1", "1", time.Hour)
+	cache.Put("2", "2", 10*time.Millisecond)
+	cache.Put("3", "3", time.Minute)
+	cache.Put("4", "4", 20*time.Millisecond)
+	cache.Delete("3")
+
+	time.Sleep(15 * time.Millisecond)
+	cache.Expire()
+	log.Close()
+
+	// "4" expires during the restart
+
+	time.Sleep(10 * time.Millisecond)
+
+	log, _ = wal.Open(dir)
+	defer log.Close()
+	cache, err := excache.New(10, excache.WithWAL(log))
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	if keys := cache.Keys(); !reflect.DeepEqual(keys, []string{"1"}) {
+		t.Errorf("keys %v, want %v", keys, []string{"1"})
+	}
+
+	cache.Put("5", "5", time.Minute)
+	if err := log.Err(); err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+}
+
 func TestReplace(t *testing.T) {
 	cache, _ := excache.New(10)
 	cache.Put(key, "value1", time.Second)
@@ -154,3 +687,20 @@ func TestPutMoreThanCap(t *testing.T) {
 		t.Errorf("cache len %v, want %v", cache.Len(), 2)
 	}
 }
+
+func TestCache_Expire_Order(t *testing.T) {
+	cache, _ := excache.New(3)
+	cache.Put("key1", "value1", time.Millisecond*10)
+	cache.Put("key2", "value2", time.Hour)
+	cache.Put("key3", "value3", time.Millisecond*20)
+
+	time.Sleep(time.Millisecond * 30)
+
+	if cache.Len() != 1 {
+		t.Errorf("cache len %v, want %v", cache.Len(), 1)
+	}
+
+	if v, ok := cache.Get("key2"); !ok || v != "value2" {
+		t.Errorf("cached value %v, want %v", v, "value2")
+	}
+}
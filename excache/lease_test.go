@@ -0,0 +1,102 @@
+package excache_test
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/faroyam/caches/excache"
+)
+
+func TestCache_GetWithLease(t *testing.T) {
+	if _, err := excache.New(2, excache.WithLeaseTTL(0)); err == nil {
+		t.Errorf("expected error")
+	}
+
+	cache, _ := excache.New(2)
+
+	v, token, ok := cache.GetWithLease("key")
+	if ok || v != nil || token == 0 {
+		t.Fatalf("got %v, %v, %v, want a lease", v, token, ok)
+	}
+
+	// other callers wait
+	if _, other, _ := cache.GetWithLease("key"); other != 0 {
+		t.Errorf("token %v while the lease is held, want 0", other)
+	}
+
+	if !cache.PutWithLease("key", 1, time.Minute, token) {
+		t.Errorf("lease isn't accepted")
+	}
+	if cache.PutWithLease("key", 2, time.Minute, token) {
+		t.Errorf("lease is accepted twice")
+	}
+	if v, token, ok := cache.GetWithLease("key"); !ok || v != 1 || token != 0 {
+		t.Errorf("got %v, %v, %v, want %v, 0, true", v, token, ok, 1)
+	}
+}
+
+func TestCache_GetWithLease_Delete(t *testing.T) {
+	cache, _ := excache.New(2)
+	cache.PutWithTags("key", 1, time.Minute, "tag")
+	cache.GetWithLease("other")
+
+	// the invalidated value is stale, the lease is revoked by a delete racing with the fill
+	cache.InvalidateTag("tag")
+	v, token, ok := cache.GetWithLease("key")
+	if ok || v != 1 || token == 0 {
+		t.Fatalf("got %v, %v, %v, want stale %v and a lease", v, token, ok, 1)
+	}
+
+	cache.Delete("key")
+	if cache.PutWithLease("key", 2, time.Minute, token) {
+		t.Errorf("revoked lease is accepted")
+	}
+
+	// expired values are not stale
+	cache.Put("expiring", 1, time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+	if v, token, ok := cache.GetWithLease("expiring"); ok || v != nil || token == 0 {
+		t.Errorf("got %v, %v, %v, want a lease", v, token, ok)
+	}
+}
+
+func TestCache_GetWithLease_Expire(t *testing.T) {
+	cache, _ := excache.New(2, excache.WithLeaseTTL(10*time.Millisecond))
+
+	_, token, _ := cache.GetWithLease("key")
+	time.Sleep(20 * time.Millisecond)
+
+	_, next, _ := cache.GetWithLease("key")
+	if next == 0 || next == token {
+		t.Errorf("token %v after the lease expired, want a new one", next)
+	}
+	if cache.PutWithLease("key", 1, time.Minute, token) {
+		t.Errorf("expired lease is accepted")
+	}
+	if !cache.PutWithLease("key", 1, time.Minute, next) {
+		t.Errorf("lease isn't accepted")
+	}
+}
+
+func TestCache_GetWithLease_Race(t *testing.T) {
+	cache, _ := excache.New(2)
+
+	var leases int64
+	wg := &sync.WaitGroup{}
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, token, _ := cache.GetWithLease("key"); token != 0 {
+				atomic.AddInt64(&leases, 1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if leases != 1 {
+		t.Errorf("%v leases granted, want 1", leases)
+	}
+}
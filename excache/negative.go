@@ -0,0 +1,89 @@
+package excache
+
+import (
+	"errors"
+	"hash/fnv"
+	"time"
+)
+
+// ErrNotFound is the error a loader is expected to return when there is no value for a key
+var ErrNotFound = errors.New("not found")
+
+// Negative is the value of a negative record, that is a cached failure of a loader.
+// Get returns it as the value of a negative record, other methods treat it as a regular value.
+type Negative struct {
+	Err error
+}
+
+// Error implements error interface
+func (n Negative) Error() string {
+	return n.Err.Error()
+}
+
+// Unwrap returns the error of the loader
+func (n Negative) Unwrap() error {
+	return n.Err
+}
+
+// WithNegativeCapacity keeps negative records out of the cache capacity
+// and bounds their number by a separate capacity instead.
+// By default negative records count toward the cache capacity.
+func WithNegativeCapacity(capacity int) Option {
+	return func(c *Cache) {
+		c.negativeCapacity = capacity
+	}
+}
+
+// GetOrLoad returns the value for a given key.
+// On a miss it calls load without holding the cache lock and caches the result:
+// a value is cached for ttl, an error is cached as a negative record for negativeTTL.
+// A cached negative record makes GetOrLoad return its error without calling load.
+// Negative records are never cached if negativeTTL <= 0.
+// The result is returned but not cached if the key is written or deleted while load runs.
+// Resets TTL of positive records only.
+func (c *Cache) GetOrLoad(key string, ttl, negativeTTL time.Duration, load func(key string) (interface{}, error)) (interface{}, error) {
+	c.m.Lock()
+	c.expire()
+	value, ok := c.get(key)
+	generation := c.generations[slot(key)]
+	c.m.Unlock()
+
+	if ok {
+		if n, negative := value.(Negative); negative {
+			return nil, n.Err
+		}
+		return value, nil
+	}
+
+	value, err := load(key)
+
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	c.expire()
+
+	// the key was written or deleted while loading, so the result may be stale
+	stale := c.generations[slot(key)] != generation
+
+	if err != nil {
+		if negativeTTL > 0 && !stale {
+			c.insert(key, Negative{Err: err}, negativeTTL, true)
+		}
+		return nil, err
+	}
+
+	if !stale {
+		c.put(key, value, ttl)
+	}
+	return value, nil
+}
+
+// generationsCount is the number of generations keys are spread over
+const generationsCount = 256
+
+// slot returns the index of the generation of the key
+func slot(key string) int {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return int(h.Sum32() % generationsCount)
+}
@@ -0,0 +1,140 @@
+package excache_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/faroyam/caches/excache"
+)
+
+func TestCache_GetOrLoad(t *testing.T) {
+	cache, _ := excache.New(2)
+
+	loads := 0
+	load := func(key string) (interface{}, error) {
+		loads++
+		if key == "missing" {
+			return nil, excache.ErrNotFound
+		}
+		return key + "'", nil
+	}
+
+	for i := 0; i < 2; i++ {
+		if v, err := cache.GetOrLoad(key, time.Second, time.Second, load); err != nil || v != key+"'" {
+			t.Errorf("loaded value %v, error %v, want %v, %v", v, err, key+"'", nil)
+		}
+
+		if v, err := cache.GetOrLoad("missing", time.Second, time.Second, load); err != excache.ErrNotFound {
+			t.Errorf("loaded value %v, error %v, want %v, %v", v, err, nil, excache.ErrNotFound)
+		}
+	}
+
+	if loads != 2 {
+		t.Errorf("loads %v, want %v", loads, 2)
+	}
+
+	v, ok := cache.Get("missing")
+	if n, negative := v.(excache.Negative); !ok || !negative || !errors.Is(n, excache.ErrNotFound) {
+		t.Errorf("cached value %v, want %v", v, excache.Negative{Err: excache.ErrNotFound})
+	}
+}
+
+func TestCache_GetOrLoad_NegativeTTL(t *testing.T) {
+	cache, _ := excache.New(2)
+
+	loads := 0
+	load := func(key string) (interface{}, error) {
+		loads++
+		return nil, excache.ErrNotFound
+	}
+
+	cache.GetOrLoad(key, time.Second, 0, load)
+	cache.GetOrLoad(key, time.Second, 0, load)
+
+	if loads != 2 || cache.Len() != 0 {
+		t.Errorf("loads %v, cache len %v, want %v, %v", loads, cache.Len(), 2, 0)
+	}
+
+	cache.GetOrLoad(key, time.Second, time.Millisecond*50, load)
+	time.Sleep(time.Millisecond * 30)
+
+	// Get does not reset TTL of negative records
+
+	if _, ok := cache.Get(key); !ok {
+		t.Errorf("negative record is not cached")
+	}
+
+	time.Sleep(time.Millisecond * 30)
+
+	cache.GetOrLoad(key, time.Second, time.Millisecond*50, load)
+
+	if loads != 4 {
+		t.Errorf("loads %v, want %v", loads, 4)
+	}
+
+	cache.Put(key, value, time.Second)
+
+	if v, err := cache.GetOrLoad(key, time.Second, time.Second, load); err != nil || v != value {
+		t.Errorf("loaded value %v, error %v, want %v, %v", v, err, value, nil)
+	}
+}
+
+func TestCache_WithNegativeCapacity(t *testing.T) {
+	_, err := excache.New(1, excache.WithNegativeCapacity(-1))
+	if err == nil {
+		t.Errorf("expected error")
+	}
+
+	load := func(key string) (interface{}, error) {
+		return nil, excache.ErrNotFound
+	}
+
+	cache, _ := excache.New(2, excache.WithNegativeCapacity(1))
+	cache.Put("1", 1, time.Second)
+	cache.Put("2", 2, time.Second)
+	cache.GetOrLoad("3", time.Second, time.Second, load)
+	cache.GetOrLoad("4", time.Second, time.Second, load)
+
+	// negative records do not evict positive ones
+
+	if cache.Len() != 3 || !cache.Contains("1") || !cache.Contains("2") || !cache.Contains("4") {
+		t.Errorf("keys %v, want %v", cache.Keys(), []string{"1", "2", "4"})
+	}
+
+	cache, _ = excache.New(2)
+	cache.Put("1", 1, time.Second)
+	cache.Put("2", 2, time.Second*2)
+	cache.GetOrLoad("3", time.Second, time.Second*3, load)
+
+	// negative records count toward capacity by default
+
+	if cache.Len() != 2 || cache.Contains("1") {
+		t.Errorf("keys %v, want %v", cache.Keys(), []string{"2", "3"})
+	}
+}
+
+func TestCache_GetOrLoad_ConcurrentWrite(t *testing.T) {
+	cache, _ := excache.New(2)
+
+	// a Put made while the value is loaded wins over the loaded value
+	v, err := cache.GetOrLoad(key, time.Second, time.Second, func(key string) (interface{}, error) {
+		cache.Put(key, "new", time.Second)
+		return "stale", nil
+	})
+	if err != nil || v != "stale" {
+		t.Errorf("loaded value %v, error %v, want %v, %v", v, err, "stale", nil)
+	}
+	if v, ok := cache.Get(key); !ok || v != "new" {
+		t.Errorf("cached value %v, want %v", v, "new")
+	}
+
+	// a Delete made while the key is loaded isn't followed by a negative record
+	cache.GetOrLoad("missing", time.Second, time.Second, func(key string) (interface{}, error) {
+		cache.Delete(key)
+		return nil, excache.ErrNotFound
+	})
+	if v, ok := cache.Get("missing"); ok {
+		t.Errorf("cached value %v, want %v", v, nil)
+	}
+}
@@ -0,0 +1,72 @@
+package excache_test
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/faroyam/caches/excache"
+	"github.com/faroyam/caches/watch"
+)
+
+// drain returns the buffered events
+func drain(events <-chan watch.Event) []watch.Event {
+	var drained []watch.Event
+	for {
+		select {
+		case e := <-events:
+			drained = append(drained, e)
+		default:
+			return drained
+		}
+	}
+}
+
+func TestCache_Watch(t *testing.T) {
+	if _, err := excache.New(2, excache.WithWatchBuffer(0, watch.Block)); err == nil {
+		t.Errorf("expected error")
+	}
+
+	cache, _ := excache.New(2)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events := cache.Watch(ctx, nil)
+
+	cache.Put("a", 1, time.Minute)
+	cache.Put("a", 2, time.Minute)
+	cache.Put("b", 3, time.Minute)
+	cache.Put("c", 4, time.Minute)
+	cache.Delete("b")
+	cache.Delete("missing")
+	cache.Clear()
+
+	want := []watch.Event{
+		{Kind: watch.Put, Key: "a", Value: 1},
+		{Kind: watch.Update, Key: "a", Value: 2},
+		{Kind: watch.Put, Key: "b", Value: 3},
+		{Kind: watch.Evict, Key: "a", Value: 2},
+		{Kind: watch.Put, Key: "c", Value: 4},
+		{Kind: watch.Delete, Key: "b", Value: 3},
+		{Kind: watch.Delete, Key: "c", Value: 4},
+	}
+	if drained := drain(events); !reflect.DeepEqual(drained, want) {
+		t.Errorf("events %v, want %v", drained, want)
+	}
+}
+
+func TestCache_Watch_Expire(t *testing.T) {
+	cache, _ := excache.New(2)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events := cache.Watch(ctx, watch.Kinds(watch.Expire))
+
+	cache.Put("a", 1, time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+	cache.Expire()
+
+	want := []watch.Event{{Kind: watch.Expire, Key: "a", Value: 1}}
+	if drained := drain(events); !reflect.DeepEqual(drained, want) {
+		t.Errorf("events %v, want %v", drained, want)
+	}
+}
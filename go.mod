@@ -1,3 +1,8 @@
 module github.com/faroyam/caches
 
 go 1.15
+
+require (
+	google.golang.org/grpc v1.43.0
+	google.golang.org/protobuf v1.27.1
+)
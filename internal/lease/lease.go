@@ -0,0 +1,168 @@
+// Package lease implements leases of missing keys described in "Scaling Memcache at Facebook".
+// A lease is granted to one caller missing a key, which is then expected to fill the key,
+// while other callers wait or use the stale value of the key if it was deleted recently.
+// A write or a delete of the key revokes the lease, so a fill racing with them can't store a stale value.
+// Table and Leases are not safe for concurrent use, callers guard them with the cache lock.
+package lease
+
+import "time"
+
+// DefaultTTL is the time leases are held and deleted values are kept stale for by default
+const DefaultTTL = 10 * time.Second
+
+// minPrune is the number of entries a table keeps before it starts pruning expired ones
+const minPrune = 64
+
+// Table keeps leases and stale values of keys
+type Table struct {
+	ttl     time.Duration
+	next    uint64
+	entries map[string]*entry
+	// pruned is the number of entries left by the last pruning
+	pruned int
+}
+
+type entry struct {
+	token   uint64
+	expires int64
+
+	stale        interface{}
+	staleExpires int64
+}
+
+// New returns an empty table of leases and stale values expiring after ttl
+func New(ttl time.Duration) *Table {
+	return &Table{
+		ttl:     ttl,
+		entries: make(map[string]*entry),
+	}
+}
+
+// Acquire returns a new token for the key if no other caller holds an unexpired lease of it
+// and 0 otherwise, along with the value of the key deleted within ttl or nil
+func (t *Table) Acquire(key string, now int64) (uint64, interface{}) {
+	e, ok := t.entries[key]
+	if !ok {
+		t.prune(now)
+		e = &entry{}
+		t.entries[key] = e
+	}
+
+	var stale interface{}
+	if now < e.staleExpires {
+		stale = e.stale
+	}
+
+	if e.token != 0 && now < e.expires {
+		return 0, stale
+	}
+
+	t.next++
+	e.token = t.next
+	e.expires = now + int64(t.ttl)
+	return e.token, stale
+}
+
+// Release reports whether the token is an unexpired lease of the key and ends the lease
+func (t *Table) Release(key string, token uint64, now int64) bool {
+	e, ok := t.entries[key]
+	if !ok || token == 0 || e.token != token || now >= e.expires {
+		return false
+	}
+
+	delete(t.entries, key)
+	return true
+}
+
+// Put revokes the lease of the written key and drops its stale value
+func (t *Table) Put(key string) {
+	delete(t.entries, key)
+}
+
+// Delete revokes the lease of the deleted key keeping the value as stale if the key existed
+func (t *Table) Delete(key string, value interface{}, existed bool, now int64) {
+	e, ok := t.entries[key]
+	if !ok {
+		if !existed {
+			return
+		}
+		t.prune(now)
+		e = &entry{}
+		t.entries[key] = e
+	}
+
+	e.token = 0
+	if existed {
+		e.stale = value
+		e.staleExpires = now + int64(t.ttl)
+	}
+}
+
+// Clear revokes all leases and drops all stale values
+func (t *Table) Clear() {
+	t.entries = make(map[string]*entry)
+	t.pruned = 0
+}
+
+// Len returns the number of keys with leases or stale values, including expired ones not pruned yet
+func (t *Table) Len() int {
+	return len(t.entries)
+}
+
+// prune removes expired entries once their number doubles since the last pruning,
+// which keeps the table proportional to the number of unexpired entries in amortized O(1) time
+func (t *Table) prune(now int64) {
+	if len(t.entries) < minPrune || len(t.entries) < 2*t.pruned {
+		return
+	}
+
+	for key, e := range t.entries {
+		if now >= e.expires && now >= e.staleExpires {
+			delete(t.entries, key)
+		}
+	}
+	t.pruned = len(t.entries)
+}
+
+// Leases is a table of a cache created by the first Acquire,
+// so that caches not using leases don't keep deleted values stale
+type Leases struct {
+	// TTL is the time leases are held and deleted values are kept stale for
+	TTL   time.Duration
+	table *Table
+}
+
+// Acquire returns a new token for the key if no other caller holds an unexpired lease of it
+// and 0 otherwise, along with the value of the key deleted within TTL or nil
+func (l *Leases) Acquire(key string) (uint64, interface{}) {
+	if l.table == nil {
+		l.table = New(l.TTL)
+	}
+	return l.table.Acquire(key, time.Now().UnixNano())
+}
+
+// Release reports whether the token is an unexpired lease of the key and ends the lease
+func (l *Leases) Release(key string, token uint64) bool {
+	return l.table != nil && l.table.Release(key, token, time.Now().UnixNano())
+}
+
+// Written revokes the lease of the written key
+func (l *Leases) Written(key string) {
+	if l.table != nil {
+		l.table.Put(key)
+	}
+}
+
+// Deleted revokes the lease of the deleted key keeping its value stale if the key existed
+func (l *Leases) Deleted(key string, value interface{}, existed bool) {
+	if l.table != nil {
+		l.table.Delete(key, value, existed, time.Now().UnixNano())
+	}
+}
+
+// Clear revokes all leases and drops all stale values
+func (l *Leases) Clear() {
+	if l.table != nil {
+		l.table.Clear()
+	}
+}
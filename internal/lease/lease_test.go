@@ -0,0 +1,110 @@
+package lease_test
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/faroyam/caches/internal/lease"
+)
+
+func TestTable(t *testing.T) {
+	table := lease.New(time.Second)
+	now := time.Now().UnixNano()
+
+	token, stale := table.Acquire("key", now)
+	if token == 0 || stale != nil {
+		t.Fatalf("token %v, stale %v, want a lease", token, stale)
+	}
+
+	// other callers wait until the lease expires
+	if other, _ := table.Acquire("key", now); other != 0 {
+		t.Errorf("token %v while the lease is held, want 0", other)
+	}
+	expired, _ := table.Acquire("key", now+int64(time.Second))
+	if expired == 0 || expired == token {
+		t.Errorf("token %v after the lease expired, want a new one", expired)
+	}
+
+	if table.Release("key", token, now) {
+		t.Errorf("expired lease is released")
+	}
+	if !table.Release("key", expired, now+int64(time.Second)) {
+		t.Errorf("lease isn't released")
+	}
+	if table.Release("key", expired, now+int64(time.Second)) {
+		t.Errorf("lease is released twice")
+	}
+}
+
+func TestTable_Delete(t *testing.T) {
+	table := lease.New(time.Second)
+	now := time.Now().UnixNano()
+
+	token, _ := table.Acquire("key", now)
+	table.Delete("key", nil, false, now)
+	if table.Release("key", token, now) {
+		t.Errorf("revoked lease is released")
+	}
+
+	// a deleted value is stale until ttl passes
+	table.Delete("stale", 1, true, now)
+	if token, stale := table.Acquire("stale", now); token == 0 || stale != 1 {
+		t.Errorf("token %v, stale %v, want a lease and %v", token, stale, 1)
+	}
+	if _, stale := table.Acquire("stale", now+int64(time.Second)); stale != nil {
+		t.Errorf("stale %v after ttl, want %v", stale, nil)
+	}
+
+	table.Put("stale")
+	if _, stale := table.Acquire("stale", now); stale != nil {
+		t.Errorf("stale %v after a write, want %v", stale, nil)
+	}
+
+	table.Delete("missing", nil, false, now)
+	table.Clear()
+	if table.Len() != 0 {
+		t.Errorf("table length %v, want %v", table.Len(), 0)
+	}
+}
+
+func TestTable_Prune(t *testing.T) {
+	table := lease.New(time.Second)
+	now := time.Now().UnixNano()
+
+	for i := 0; i < 1000; i++ {
+		table.Acquire(strconv.Itoa(i), now+int64(i)*int64(time.Second))
+	}
+
+	// only the leases acquired within the last second are kept, plus at most as many expired ones
+	if table.Len() > 2*64 {
+		t.Errorf("table length %v, expired leases are not pruned", table.Len())
+	}
+}
+
+func TestLeases(t *testing.T) {
+	l := lease.Leases{TTL: time.Second}
+
+	// deleted values are not kept stale before the first Acquire
+	l.Deleted("key", 1, true)
+	l.Written("key")
+	l.Clear()
+	if l.Release("key", 1) {
+		t.Errorf("released a lease that wasn't acquired")
+	}
+
+	token, stale := l.Acquire("key")
+	if token == 0 || stale != nil {
+		t.Fatalf("token %v, stale %v, want a lease", token, stale)
+	}
+	l.Deleted("key", 1, true)
+	if l.Release("key", token) {
+		t.Errorf("released a lease revoked by a delete")
+	}
+	if token, stale = l.Acquire("key"); token == 0 || stale != 1 {
+		t.Errorf("token %v, stale %v, want a lease and %v", token, stale, 1)
+	}
+	if !l.Release("key", token) {
+		t.Errorf("lease isn't released")
+	}
+}
@@ -0,0 +1,194 @@
+// Package radix implements a radix tree of string keys
+// that lists and removes keys sharing a prefix in O(matches).
+// Tree is not safe for concurrent use, callers guard it with the cache lock.
+package radix
+
+import (
+	"sort"
+	"strings"
+)
+
+// Tree represents a set of string keys stored in a radix tree
+type Tree struct {
+	root node
+	len  int
+}
+
+// New returns an empty tree
+func New() *Tree {
+	return &Tree{}
+}
+
+// Insert adds the key to the tree.
+// Returns false if the key is already in the tree.
+func (t *Tree) Insert(key string) bool {
+	n := &t.root
+	for key != "" {
+		i, child := n.child(key[0])
+		if child == nil {
+			n.insertChild(i, &node{label: key, leaf: true})
+			t.len++
+			return true
+		}
+
+		l := commonPrefixLen(key, child.label)
+		if l < len(child.label) {
+			split := &node{label: child.label[:l], children: []*node{child}}
+			child.label = child.label[l:]
+			n.children[i] = split
+			child = split
+		}
+
+		key = key[l:]
+		n = child
+	}
+
+	if n.leaf {
+		return false
+	}
+	n.leaf = true
+	t.len++
+	return true
+}
+
+// Delete removes the key from the tree.
+// Returns false if there is no such key in the tree.
+func (t *Tree) Delete(key string) bool {
+	var parent *node
+	var parentIndex int
+
+	n := &t.root
+	for key != "" {
+		i, child := n.child(key[0])
+		if child == nil || !strings.HasPrefix(key, child.label) {
+			return false
+		}
+		parent, parentIndex = n, i
+		key = key[len(child.label):]
+		n = child
+	}
+
+	if !n.leaf {
+		return false
+	}
+	n.leaf = false
+	t.len--
+
+	if parent == nil {
+		return true
+	}
+
+	switch len(n.children) {
+	case 0:
+		parent.removeChild(parentIndex)
+		if parent != &t.root && !parent.leaf && len(parent.children) == 1 {
+			parent.mergeChild()
+		}
+	case 1:
+		n.mergeChild()
+	}
+	return true
+}
+
+// Contains reports whether the key is in the tree
+func (t *Tree) Contains(key string) bool {
+	n := &t.root
+	for key != "" {
+		_, child := n.child(key[0])
+		if child == nil || !strings.HasPrefix(key, child.label) {
+			return false
+		}
+		key = key[len(child.label):]
+		n = child
+	}
+	return n.leaf
+}
+
+// WalkPrefix calls f for each key starting with the prefix in lexicographic order.
+// If f returns false, WalkPrefix stops the iteration.
+// The tree must not be modified by f.
+func (t *Tree) WalkPrefix(prefix string, f func(key string) bool) {
+	n := &t.root
+	var key []byte
+
+	for prefix != "" {
+		_, child := n.child(prefix[0])
+		switch {
+		case child == nil:
+			return
+		case strings.HasPrefix(prefix, child.label):
+			prefix = prefix[len(child.label):]
+		case strings.HasPrefix(child.label, prefix):
+			prefix = ""
+		default:
+			return
+		}
+		key = append(key, child.label...)
+		n = child
+	}
+
+	n.walk(key, f)
+}
+
+// Len returns the number of keys in the tree
+func (t *Tree) Len() int {
+	return t.len
+}
+
+type node struct {
+	label    string
+	leaf     bool
+	children []*node // sorted by the first byte of label
+}
+
+// child returns the child which label starts with b and its index.
+// If there is no such child, returns nil and the index to insert it at.
+func (n *node) child(b byte) (int, *node) {
+	i := sort.Search(len(n.children), func(i int) bool {
+		return n.children[i].label[0] >= b
+	})
+	if i < len(n.children) && n.children[i].label[0] == b {
+		return i, n.children[i]
+	}
+	return i, nil
+}
+
+func (n *node) insertChild(i int, child *node) {
+	n.children = append(n.children, nil)
+	copy(n.children[i+1:], n.children[i:])
+	n.children[i] = child
+}
+
+func (n *node) removeChild(i int) {
+	copy(n.children[i:], n.children[i+1:])
+	n.children[len(n.children)-1] = nil
+	n.children = n.children[:len(n.children)-1]
+}
+
+// mergeChild merges the only child into the node
+func (n *node) mergeChild() {
+	child := n.children[0]
+	n.label += child.label
+	n.leaf = child.leaf
+	n.children = child.children
+}
+
+func (n *node) walk(key []byte, f func(key string) bool) bool {
+	if n.leaf && !f(string(key)) {
+		return false
+	}
+	for _, child := range n.children {
+		if !child.walk(append(key, child.label...), f) {
+			return false
+		}
+	}
+	return true
+}
+
+func commonPrefixLen(a, b string) int {
+	i := 0
+	for i < len(a) && i < len(b) && a[i] == b[i] {
+		i++
+	}
+	return i
+}
@@ -0,0 +1,102 @@
+package radix_test
+
+import (
+	"math/rand"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/faroyam/caches/internal/radix"
+)
+
+func TestTree(t *testing.T) {
+	tree := radix.New()
+	for _, key := range []string{"org/1/repo/1", "org/1/repo/2", "org/12", "org/2", "o", ""} {
+		if !tree.Insert(key) {
+			t.Errorf("insert %q %v, want %v", key, false, true)
+		}
+	}
+
+	if tree.Insert("org/12") {
+		t.Errorf("insert %q %v, want %v", "org/12", true, false)
+	}
+
+	if tree.Len() != 6 {
+		t.Errorf("tree len %v, want %v", tree.Len(), 6)
+	}
+
+	want := []string{"org/1/repo/1", "org/1/repo/2", "org/12"}
+	if keys := walk(tree, "org/1"); !reflect.DeepEqual(keys, want) {
+		t.Errorf("keys %v, want %v", keys, want)
+	}
+
+	if keys := walk(tree, "org/1/"); !reflect.DeepEqual(keys, want[:2]) {
+		t.Errorf("keys %v, want %v", keys, want[:2])
+	}
+
+	if keys := walk(tree, "org/3"); len(keys) != 0 {
+		t.Errorf("keys %v, want %v", keys, []string{})
+	}
+
+	if tree.Delete("org/1") {
+		t.Errorf("delete %q %v, want %v", "org/1", true, false)
+	}
+
+	if !tree.Delete("org/1/repo/1") || tree.Contains("org/1/repo/1") {
+		t.Errorf("key %q is not deleted", "org/1/repo/1")
+	}
+
+	if !tree.Contains("org/1/repo/2") || !tree.Contains("o") || !tree.Contains("") {
+		t.Errorf("keys are lost")
+	}
+}
+
+func TestTree_Random(t *testing.T) {
+	tree := radix.New()
+	keys := make(map[string]struct{})
+	r := rand.New(rand.NewSource(1))
+
+	for i := 0; i < 10000; i++ {
+		key := strconv.FormatInt(r.Int63n(5000), 4)
+		if _, ok := keys[key]; ok {
+			delete(keys, key)
+			if !tree.Delete(key) {
+				t.Fatalf("delete %q %v, want %v", key, false, true)
+			}
+			continue
+		}
+		keys[key] = struct{}{}
+		if !tree.Insert(key) {
+			t.Fatalf("insert %q %v, want %v", key, false, true)
+		}
+	}
+
+	if tree.Len() != len(keys) {
+		t.Errorf("tree len %v, want %v", tree.Len(), len(keys))
+	}
+
+	for _, prefix := range []string{"", "1", "12", "123", "3210"} {
+		var want []string
+		for key := range keys {
+			if strings.HasPrefix(key, prefix) {
+				want = append(want, key)
+			}
+		}
+		sort.Strings(want)
+
+		if got := walk(tree, prefix); !reflect.DeepEqual(got, want) {
+			t.Errorf("prefix %q keys %v, want %v", prefix, got, want)
+		}
+	}
+}
+
+func walk(tree *radix.Tree, prefix string) []string {
+	var keys []string
+	tree.WalkPrefix(prefix, func(key string) bool {
+		keys = append(keys, key)
+		return true
+	})
+	return keys
+}
@@ -0,0 +1,42 @@
+// Package tagindex implements a reverse index from tags to cache keys.
+// Index is not safe for concurrent use, callers guard it with the cache lock.
+package tagindex
+
+// Index maps every tag to the set of keys tagged with it
+type Index map[string]map[string]struct{}
+
+// Add tags the key with the given tags
+func (i Index) Add(key string, tags []string) {
+	for _, tag := range tags {
+		keys, ok := i[tag]
+		if !ok {
+			keys = make(map[string]struct{})
+			i[tag] = keys
+		}
+		keys[key] = struct{}{}
+	}
+}
+
+// Remove untags the key from the given tags.
+// Tags that are left without keys are dropped from the index.
+func (i Index) Remove(key string, tags []string) {
+	for _, tag := range tags {
+		keys, ok := i[tag]
+		if !ok {
+			continue
+		}
+		delete(keys, key)
+		if len(keys) == 0 {
+			delete(i, tag)
+		}
+	}
+}
+
+// Keys returns the keys tagged with the tag
+func (i Index) Keys(tag string) []string {
+	keys := make([]string, 0, len(i[tag]))
+	for key := range i[tag] {
+		keys = append(keys, key)
+	}
+	return keys
+}
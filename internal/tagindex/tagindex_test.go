@@ -0,0 +1,30 @@
+package tagindex_test
+
+import (
+	"sort"
+	"testing"
+
+	"github.com/faroyam/caches/internal/tagindex"
+)
+
+func TestIndex(t *testing.T) {
+	index := make(tagindex.Index)
+	index.Add("1", []string{"a", "b"})
+	index.Add("2", []string{"a"})
+
+	keys := index.Keys("a")
+	sort.Strings(keys)
+	if len(keys) != 2 || keys[0] != "1" || keys[1] != "2" {
+		t.Errorf("keys %v, want %v", keys, []string{"1", "2"})
+	}
+
+	index.Remove("1", []string{"a", "b", "c"})
+
+	if keys := index.Keys("a"); len(keys) != 1 || keys[0] != "2" {
+		t.Errorf("keys %v, want %v", keys, []string{"2"})
+	}
+
+	if _, ok := index["b"]; ok {
+		t.Errorf("tag %v is not dropped", "b")
+	}
+}
@@ -0,0 +1,30 @@
+// Package watchhub keeps the watch hub of a cache along with its configuration,
+// which is set by options of the cache before the hub is created.
+package watchhub
+
+import "github.com/faroyam/caches/watch"
+
+// Hub is the watch hub of a cache, nil until Init
+type Hub struct {
+	// Buffer is the number of events buffered for each watcher
+	Buffer int
+	// Policy is the policy applied to events published while the buffer of a watcher is full
+	Policy watch.Policy
+
+	*watch.Hub
+}
+
+// New returns a configuration buffering watch.DefaultBuffer events with the watch.Disconnect policy
+func New() Hub {
+	return Hub{Buffer: watch.DefaultBuffer, Policy: watch.Disconnect}
+}
+
+// Init validates the configuration and creates the hub
+func (h *Hub) Init() error {
+	hub, err := watch.NewHub(h.Buffer, h.Policy)
+	if err != nil {
+		return err
+	}
+	h.Hub = hub
+	return nil
+}
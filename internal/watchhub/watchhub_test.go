@@ -0,0 +1,31 @@
+package watchhub_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/faroyam/caches/internal/watchhub"
+	"github.com/faroyam/caches/watch"
+)
+
+func TestHub(t *testing.T) {
+	h := watchhub.New()
+	h.Buffer = 0
+	if err := h.Init(); err == nil {
+		t.Errorf("expected error")
+	}
+
+	h = watchhub.New()
+	if err := h.Init(); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	events := h.Watch(ctx, nil)
+	h.Publish(watch.Put, "key", 1)
+	if e := <-events; e.Kind != watch.Put || e.Key != "key" || e.Value != 1 {
+		t.Errorf("event %+v, want a put of %v", e, "key")
+	}
+}
@@ -0,0 +1,417 @@
+// Package invalidation keeps local caches of several instances consistent
+// by broadcasting invalidation events over a pluggable transport.
+//
+// Each bus numbers the events it sends. Receivers track the sequence numbers of every sender
+// and clear their cache when a gap shows that an event was lost, so a stale value is never kept.
+// Buses send heartbeats carrying the last sequence number, so that losing the last event
+// before a quiet period is detected as well.
+// Events sent before a receiver joined are reported as a gap too,
+// which clears the cache once for every sender that sent events before.
+//
+// Transports may reorder events, so a missing event is taken for lost only when
+// 64 later events of its sender arrive first, or when it is still missing at the heartbeat
+// after the one that announced it. Until then its key may stay stale for up to two heartbeat intervals.
+//
+//	c, _ := lru.New(1000)
+//	transport, _ := invalidation.ListenUDP("127.0.0.1:7946")
+//	transport.SetPeers("127.0.0.1:7947", "127.0.0.1:7948")
+//	bus, _ := invalidation.New(c, transport)
+//	defer bus.Close()
+//
+//	db.Update(row)
+//	bus.Delete(row.Key)
+package invalidation
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/bits"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrClosed is returned by Transport.Receive after the transport is closed
+var ErrClosed = errors.New("transport is closed")
+
+// delays before retrying Transport.Receive after an error
+const (
+	minBackoff = 10 * time.Millisecond
+	maxBackoff = time.Second
+)
+
+// reorderWindow is the number of events of a sender that may arrive before a missing one
+const reorderWindow = 64
+
+// Cache represents a local cache, e.g. lru.Cache, lfu.Cache or excache.Cache
+type Cache interface {
+	Delete(key string)
+	InvalidateTag(tag string) int
+	Clear()
+}
+
+// Transport delivers messages between buses, possibly losing or reordering them
+type Transport interface {
+	// Send broadcasts the message to other members
+	Send(msg []byte) error
+	// Receive blocks until a message is received, returns ErrClosed after Close
+	Receive() ([]byte, error)
+	// Close stops the transport
+	Close() error
+}
+
+// Kind represents a kind of event
+type Kind uint8
+
+// Kinds of events
+const (
+	// Delete deletes a key
+	Delete Kind = iota + 1
+	// InvalidateTag deletes keys tagged with a tag
+	InvalidateTag
+	// Heartbeat carries the last sequence number of the sender
+	Heartbeat
+)
+
+// Event represents an invalidation event
+type Event struct {
+	Sender string
+	Seq    uint64
+	Kind   Kind
+	// Key is the deleted key or the invalidated tag
+	Key string
+}
+
+// Stats represents counters of a bus
+type Stats struct {
+	// Sent is the number of events sent
+	Sent int64
+	// Applied is the number of events received and applied to the cache
+	Applied int64
+	// Gaps is the number of detected gaps, each of them cleared the cache
+	Gaps int64
+	// Malformed is the number of messages that couldn't be decoded
+	Malformed int64
+	// Errors is the number of failed receives of the transport
+	Errors int64
+}
+
+// Bus represents a member of an invalidation group safe for concurrent use
+type Bus struct {
+	cache     Cache
+	transport Transport
+	id        string
+	heartbeat time.Duration
+
+	m     *sync.Mutex
+	seq   uint64
+	peers map[string]*peer
+
+	stats Stats
+
+	stop      chan struct{}
+	done      *sync.WaitGroup
+	closeOnce *sync.Once
+}
+
+// Option configures a bus instance
+type Option func(*Bus)
+
+// WithID sets the ID of the bus identifying its events. Random by default.
+// IDs must be unique within the group and must not be reused after a restart.
+func WithID(id string) Option {
+	return func(b *Bus) {
+		b.id = id
+	}
+}
+
+// WithHeartbeat sets the interval of heartbeats. 1s by default.
+func WithHeartbeat(interval time.Duration) Option {
+	return func(b *Bus) {
+		b.heartbeat = interval
+	}
+}
+
+// New returns a bus applying received events to the cache.
+// It starts background goroutines receiving events and sending heartbeats which are stopped by Close.
+func New(cache Cache, transport Transport, options ...Option) (*Bus, error) {
+	b := &Bus{
+		cache:     cache,
+		transport: transport,
+		heartbeat: time.Second,
+		m:         &sync.Mutex{},
+		peers:     make(map[string]*peer),
+		stop:      make(chan struct{}),
+		done:      &sync.WaitGroup{},
+		closeOnce: &sync.Once{},
+	}
+
+	for _, option := range options {
+		option(b)
+	}
+
+	if b.heartbeat <= 0 {
+		return nil, fmt.Errorf("heartbeat interval must be positive")
+	}
+	if b.id == "" {
+		id := make([]byte, 8)
+		if _, err := rand.Read(id); err != nil {
+			return nil, err
+		}
+		b.id = hex.EncodeToString(id)
+	}
+
+	b.done.Add(2)
+	go b.receive()
+	go b.beat()
+	return b, nil
+}
+
+// ID returns the ID of the bus
+func (b *Bus) ID() string {
+	return b.id
+}
+
+// Delete deletes the key from the local cache and broadcasts the event
+func (b *Bus) Delete(key string) error {
+	b.cache.Delete(key)
+	return b.send(Delete, key)
+}
+
+// InvalidateTag deletes keys tagged with the tag from the local cache and broadcasts the event.
+// Returns the number of keys deleted locally.
+func (b *Bus) InvalidateTag(tag string) (int, error) {
+	n := b.cache.InvalidateTag(tag)
+	return n, b.send(InvalidateTag, tag)
+}
+
+// Stats returns the counters of the bus
+func (b *Bus) Stats() Stats {
+	return Stats{
+		Sent:      atomic.LoadInt64(&b.stats.Sent),
+		Applied:   atomic.LoadInt64(&b.stats.Applied),
+		Gaps:      atomic.LoadInt64(&b.stats.Gaps),
+		Malformed: atomic.LoadInt64(&b.stats.Malformed),
+		Errors:    atomic.LoadInt64(&b.stats.Errors),
+	}
+}
+
+// Close stops background goroutines and closes the transport
+func (b *Bus) Close() error {
+	var err error
+	b.closeOnce.Do(func() {
+		close(b.stop)
+		err = b.transport.Close()
+		b.done.Wait()
+	})
+	return err
+}
+
+func (b *Bus) send(kind Kind, key string) error {
+	// sequence numbers are sent in order, so that receivers don't take reordering for gaps
+	b.m.Lock()
+	defer b.m.Unlock()
+
+	b.seq++
+	atomic.AddInt64(&b.stats.Sent, 1)
+	return b.transport.Send(Encode(Event{Sender: b.id, Seq: b.seq, Kind: kind, Key: key}))
+}
+
+func (b *Bus) receive() {
+	defer b.done.Done()
+
+	backoff := minBackoff
+	for {
+		msg, err := b.transport.Receive()
+		if errors.Is(err, ErrClosed) {
+			return
+		}
+		if err != nil {
+			// a failing transport is retried with a growing delay instead of spinning
+			atomic.AddInt64(&b.stats.Errors, 1)
+			select {
+			case <-time.After(backoff):
+			case <-b.stop:
+				return
+			}
+			if backoff *= 2; backoff > maxBackoff {
+				backoff = maxBackoff
+			}
+			continue
+		}
+		backoff = minBackoff
+
+		e, err := Decode(msg)
+		if err != nil {
+			atomic.AddInt64(&b.stats.Malformed, 1)
+			continue
+		}
+		if e.Sender != b.id {
+			b.apply(e)
+		}
+	}
+}
+
+// apply applies the event clearing the cache if an event of the sender was lost.
+// Late events are applied as well, invalidating a key again is harmless.
+func (b *Bus) apply(e Event) {
+	b.m.Lock()
+	gap := b.track(e)
+	b.m.Unlock()
+
+	if gap {
+		atomic.AddInt64(&b.stats.Gaps, 1)
+		b.cache.Clear()
+	}
+
+	switch e.Kind {
+	case Delete:
+		b.cache.Delete(e.Key)
+	case InvalidateTag:
+		b.cache.InvalidateTag(e.Key)
+	default:
+		return
+	}
+	atomic.AddInt64(&b.stats.Applied, 1)
+}
+
+// track records the sequence number of the event and reports whether an event of the sender was lost.
+// The caller must hold b.m.
+func (b *Bus) track(e Event) bool {
+	p, ok := b.peers[e.Sender]
+	if !ok {
+		// events sent before the bus joined are missing like lost ones
+		p = &peer{next: 1}
+		b.peers[e.Sender] = p
+	}
+
+	if e.Kind == Heartbeat {
+		// the previous heartbeat announced an event which is still missing
+		lost := p.beat >= p.next
+		if lost {
+			p.skip(e.Seq)
+		}
+		if e.Seq > p.beat {
+			p.beat = e.Seq
+		}
+		return lost
+	}
+
+	switch {
+	case e.Seq < p.next:
+		return false
+	case e.Seq-p.next >= reorderWindow:
+		p.skip(e.Seq)
+		return true
+	}
+
+	p.received |= 1 << (e.Seq - p.next)
+	for p.received&1 != 0 {
+		p.received >>= 1
+		p.next++
+	}
+	return false
+}
+
+// peer tracks sequence numbers received from a sender
+type peer struct {
+	// next is the lowest sequence number not received yet
+	next uint64
+	// received has bit i set if next+i was received
+	received uint64
+	// beat is the sequence number of the last heartbeat
+	beat uint64
+}
+
+// skip gives up waiting for missing events up to seq
+func (p *peer) skip(seq uint64) {
+	if p.received != 0 {
+		if highest := p.next + 63 - uint64(bits.LeadingZeros64(p.received)); highest > seq {
+			seq = highest
+		}
+	}
+	if seq >= p.next {
+		p.next = seq + 1
+	}
+	p.received = 0
+}
+
+func (b *Bus) beat() {
+	defer b.done.Done()
+
+	ticker := time.NewTicker(b.heartbeat)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			b.m.Lock()
+			msg := Encode(Event{Sender: b.id, Seq: b.seq, Kind: Heartbeat})
+			b.m.Unlock()
+
+			// a lost heartbeat is detected by the next one
+			_ = b.transport.Send(msg)
+		case <-b.stop:
+			return
+		}
+	}
+}
+
+// version of the encoding
+const version = 1
+
+// Encode encodes the event for a transport
+func Encode(e Event) []byte {
+	buf := make([]byte, 2+3*binary.MaxVarintLen64+len(e.Sender)+len(e.Key))
+	buf[0] = version
+	buf[1] = byte(e.Kind)
+	n := 2
+	n += binary.PutUvarint(buf[n:], e.Seq)
+	n += binary.PutUvarint(buf[n:], uint64(len(e.Sender)))
+	n += copy(buf[n:], e.Sender)
+	n += binary.PutUvarint(buf[n:], uint64(len(e.Key)))
+	n += copy(buf[n:], e.Key)
+	return buf[:n]
+}
+
+// Decode decodes an event encoded by Encode
+func Decode(msg []byte) (Event, error) {
+	if len(msg) < 2 || msg[0] != version {
+		return Event{}, fmt.Errorf("unsupported message")
+	}
+	e := Event{Kind: Kind(msg[1])}
+	if e.Kind < Delete || e.Kind > Heartbeat {
+		return Event{}, fmt.Errorf("unknown event kind %d", e.Kind)
+	}
+	msg = msg[2:]
+
+	var n int
+	if e.Seq, n = binary.Uvarint(msg); n <= 0 {
+		return Event{}, fmt.Errorf("malformed sequence number")
+	}
+	msg = msg[n:]
+
+	var err error
+	if e.Sender, msg, err = readString(msg); err != nil {
+		return Event{}, err
+	}
+	if e.Key, msg, err = readString(msg); err != nil {
+		return Event{}, err
+	}
+	if len(msg) != 0 {
+		return Event{}, fmt.Errorf("trailing bytes")
+	}
+	return e, nil
+}
+
+func readString(msg []byte) (string, []byte, error) {
+	size, n := binary.Uvarint(msg)
+	if n <= 0 || size > uint64(len(msg)-n) {
+		return "", nil, fmt.Errorf("malformed string")
+	}
+	msg = msg[n:]
+	return string(msg[:size]), msg[size:], nil
+}
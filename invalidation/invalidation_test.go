@@ -0,0 +1,396 @@
+package invalidation_test
+
+import (
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/faroyam/caches/excache"
+	"github.com/faroyam/caches/invalidation"
+	"github.com/faroyam/caches/lfu"
+	"github.com/faroyam/caches/lru"
+)
+
+// eventually fails the test if the condition doesn't hold within a second
+func eventually(t *testing.T, condition func() bool) {
+	t.Helper()
+
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		if condition() {
+			return
+		}
+	}
+	t.Fatalf("condition doesn't hold")
+}
+
+func newBus(t *testing.T, cache invalidation.Cache, transport invalidation.Transport, options ...invalidation.Option) *invalidation.Bus {
+	t.Helper()
+
+	b, err := invalidation.New(cache, transport, options...)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	t.Cleanup(func() { b.Close() })
+	return b
+}
+
+// lossy drops messages sent while drop is set
+type lossy struct {
+	invalidation.Transport
+
+	m    sync.Mutex
+	drop bool
+}
+
+func (l *lossy) setDrop(drop bool) {
+	l.m.Lock()
+	defer l.m.Unlock()
+
+	l.drop = drop
+}
+
+func (l *lossy) Send(msg []byte) error {
+	l.m.Lock()
+	defer l.m.Unlock()
+
+	if l.drop {
+		return nil
+	}
+	return l.Transport.Send(msg)
+}
+
+func TestEncode(t *testing.T) {
+	e := invalidation.Event{Sender: "node1", Seq: 300, Kind: invalidation.InvalidateTag, Key: "tag"}
+	decoded, err := invalidation.Decode(invalidation.Encode(e))
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if !reflect.DeepEqual(decoded, e) {
+		t.Errorf("decoded event %+v, want %+v", decoded, e)
+	}
+
+	msg := invalidation.Encode(e)
+	for _, malformed := range [][]byte{nil, {9, 1}, {1, 9}, msg[:len(msg)-1], append(msg, 0)} {
+		if _, err := invalidation.Decode(malformed); err == nil {
+			t.Errorf("expected error for %v", malformed)
+		}
+	}
+}
+
+func TestNew(t *testing.T) {
+	c, _ := lru.New(10)
+	if _, err := invalidation.New(c, invalidation.NewHub().Join(), invalidation.WithHeartbeat(0)); err == nil {
+		t.Errorf("expected error")
+	}
+
+	b := newBus(t, c, invalidation.NewHub().Join())
+	if b.ID() == "" {
+		t.Errorf("empty ID")
+	}
+}
+
+func TestBus(t *testing.T) {
+	hub := invalidation.NewHub()
+
+	lruCache, _ := lru.New(10)
+	lfuCache, _ := lfu.New(10)
+	exCache, _ := excache.New(10)
+
+	sender := newBus(t, lruCache, hub.Join())
+	lfuBus := newBus(t, lfuCache, hub.Join())
+	exBus := newBus(t, exCache, hub.Join())
+
+	lruCache.PutWithTags("a", 1, "tag")
+	lruCache.Put("b", 2)
+	lfuCache.PutWithTags("a", 1, "tag")
+	lfuCache.Put("b", 2)
+	exCache.PutWithTags("a", 1, time.Minute, "tag")
+	exCache.Put("b", 2, time.Minute)
+	exCache.Put("c", 3, time.Minute)
+
+	if err := sender.Delete("b"); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if n, err := sender.InvalidateTag("tag"); err != nil || n != 1 {
+		t.Fatalf("invalidated %v, %v, want %v", n, err, 1)
+	}
+
+	eventually(t, func() bool { return lfuBus.Stats().Applied == 2 && exBus.Stats().Applied == 2 })
+
+	if lruCache.Len() != 0 || lfuCache.Len() != 0 {
+		t.Errorf("cache lengths %v, %v, want 0", lruCache.Len(), lfuCache.Len())
+	}
+	if value, ok := exCache.Get("c"); !ok || value != 3 {
+		t.Errorf("cached value %v, want %v", value, 3)
+	}
+	if exCache.Len() != 1 {
+		t.Errorf("cache length %v, want %v", exCache.Len(), 1)
+	}
+
+	if stats := sender.Stats(); stats.Sent != 2 || stats.Applied != 0 {
+		t.Errorf("sender stats %+v", stats)
+	}
+	if stats := exBus.Stats(); stats.Gaps != 0 || stats.Malformed != 0 {
+		t.Errorf("receiver stats %+v", stats)
+	}
+}
+
+func TestBus_Gap(t *testing.T) {
+	hub := invalidation.NewHub()
+	transport := &lossy{Transport: hub.Join()}
+
+	senderCache, _ := lru.New(10)
+	cache, _ := lru.New(10)
+	sender := newBus(t, senderCache, transport, invalidation.WithHeartbeat(10*time.Millisecond))
+	receiver := newBus(t, cache, hub.Join())
+
+	cache.Put("a", 1)
+	cache.Put("b", 2)
+	cache.Put("c", 3)
+
+	// the first event is lost, the second one may be reordered with it until heartbeats reveal the gap
+	transport.setDrop(true)
+	sender.Delete("a")
+	transport.setDrop(false)
+	sender.Delete("b")
+
+	eventually(t, func() bool { return receiver.Stats().Applied == 1 && receiver.Stats().Gaps == 1 })
+
+	if stats := receiver.Stats(); stats.Gaps != 1 {
+		t.Errorf("gaps %v, want %v", stats.Gaps, 1)
+	}
+	if _, ok := cache.Get("c"); ok {
+		t.Errorf("cache isn't cleared after a gap")
+	}
+}
+
+func TestBus_Heartbeat(t *testing.T) {
+	hub := invalidation.NewHub()
+	transport := &lossy{Transport: hub.Join()}
+
+	senderCache, _ := lru.New(10)
+	cache, _ := lru.New(10)
+	cache.Put("a", 1)
+
+	receiver := newBus(t, cache, hub.Join())
+	transport.setDrop(true)
+	sender := newBus(t, senderCache, transport, invalidation.WithHeartbeat(10*time.Millisecond))
+
+	// the only event is lost, the next heartbeat reveals the gap
+	sender.Delete("a")
+	transport.setDrop(false)
+
+	eventually(t, func() bool { return receiver.Stats().Gaps == 1 })
+
+	if _, ok := cache.Get("a"); ok {
+		t.Errorf("cache isn't cleared after a gap")
+	}
+
+	// heartbeats without new events are not gaps
+	time.Sleep(50 * time.Millisecond)
+	if stats := receiver.Stats(); stats.Gaps != 1 || stats.Applied != 0 {
+		t.Errorf("receiver stats %+v", stats)
+	}
+}
+
+// swapping sends every two messages in reverse order
+type swapping struct {
+	invalidation.Transport
+
+	m    sync.Mutex
+	held []byte
+}
+
+func (s *swapping) Send(msg []byte) error {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	if s.held == nil {
+		s.held = msg
+		return nil
+	}
+	held := s.held
+	s.held = nil
+	if err := s.Transport.Send(msg); err != nil {
+		return err
+	}
+	return s.Transport.Send(held)
+}
+
+func TestBus_Reorder(t *testing.T) {
+	hub := invalidation.NewHub()
+
+	senderCache, _ := lru.New(10)
+	cache, _ := lru.New(10)
+	cache.Put("kept", 0)
+
+	receiver := newBus(t, cache, hub.Join())
+	sender := newBus(t, senderCache, &swapping{Transport: hub.Join()}, invalidation.WithHeartbeat(time.Hour))
+
+	// the first event is delivered after the second one
+	sender.Delete("a")
+	sender.Delete("b")
+	eventually(t, func() bool { return receiver.Stats().Applied == 2 })
+
+	// reordered events are not gaps
+	for i := 0; i < 10; i++ {
+		sender.Delete("a")
+	}
+	eventually(t, func() bool { return receiver.Stats().Applied == 12 })
+
+	if stats := receiver.Stats(); stats.Gaps != 0 {
+		t.Errorf("gaps %v, want %v", stats.Gaps, 0)
+	}
+	if _, ok := cache.Get("kept"); !ok {
+		t.Errorf("cache is cleared without gaps")
+	}
+}
+
+func TestBus_Window(t *testing.T) {
+	hub := invalidation.NewHub()
+	transport := &lossy{Transport: hub.Join()}
+
+	senderCache, _ := lru.New(10)
+	cache, _ := lru.New(10)
+
+	receiver := newBus(t, cache, hub.Join())
+	sender := newBus(t, senderCache, transport, invalidation.WithHeartbeat(time.Hour))
+
+	sender.Delete("a")
+	eventually(t, func() bool { return receiver.Stats().Applied == 1 })
+
+	// the lost event may still arrive until the window is exceeded
+	transport.setDrop(true)
+	sender.Delete("a")
+	transport.setDrop(false)
+	for i := 0; i < 63; i++ {
+		sender.Delete("a")
+	}
+	eventually(t, func() bool { return receiver.Stats().Applied == 64 })
+
+	cache.Put("b", 2)
+	if stats := receiver.Stats(); stats.Gaps != 0 {
+		t.Errorf("gaps %v, want %v", stats.Gaps, 0)
+	}
+
+	sender.Delete("a")
+	eventually(t, func() bool { return receiver.Stats().Applied == 65 })
+
+	if stats := receiver.Stats(); stats.Gaps != 1 {
+		t.Errorf("gaps %v, want %v", stats.Gaps, 1)
+	}
+	if _, ok := cache.Get("b"); ok {
+		t.Errorf("cache isn't cleared after a gap")
+	}
+}
+
+func TestBus_HeartbeatLoss(t *testing.T) {
+	hub := invalidation.NewHub()
+	transport := &lossy{Transport: hub.Join()}
+
+	senderCache, _ := lru.New(10)
+	cache, _ := lru.New(10)
+
+	receiver := newBus(t, cache, hub.Join())
+	sender := newBus(t, senderCache, transport, invalidation.WithHeartbeat(10*time.Millisecond))
+
+	sender.Delete("a")
+	eventually(t, func() bool { return receiver.Stats().Applied == 1 })
+	cache.Put("b", 2)
+
+	// the last event of a known sender is lost, later heartbeats reveal the gap
+	transport.setDrop(true)
+	sender.Delete("a")
+	transport.setDrop(false)
+
+	eventually(t, func() bool { return receiver.Stats().Gaps == 1 })
+
+	if _, ok := cache.Get("b"); ok {
+		t.Errorf("cache isn't cleared after a gap")
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	if stats := receiver.Stats(); stats.Gaps != 1 {
+		t.Errorf("gaps %v, want %v", stats.Gaps, 1)
+	}
+}
+
+// failing fails every Receive until it is closed
+type failing struct {
+	closed chan struct{}
+}
+
+func (f *failing) Send([]byte) error { return nil }
+
+func (f *failing) Receive() ([]byte, error) {
+	select {
+	case <-f.closed:
+		return nil, invalidation.ErrClosed
+	default:
+		return nil, errors.New("receive failed")
+	}
+}
+
+func (f *failing) Close() error {
+	close(f.closed)
+	return nil
+}
+
+func TestBus_ReceiveErrors(t *testing.T) {
+	c, _ := lru.New(10)
+	b := newBus(t, c, &failing{closed: make(chan struct{})})
+
+	// failed receives are retried with a growing delay
+	time.Sleep(100 * time.Millisecond)
+	if n := b.Stats().Errors; n == 0 || n > 10 {
+		t.Errorf("errors %v, want 1 to 10", n)
+	}
+
+	if err := b.Close(); err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+}
+
+func TestUDP(t *testing.T) {
+	transports := make([]*invalidation.UDP, 2)
+	for i := range transports {
+		transport, err := invalidation.ListenUDP("127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		transports[i] = transport
+	}
+	transports[0].SetPeers(transports[1].Addr().String())
+	transports[1].SetPeers(transports[0].Addr().String())
+
+	if err := transports[0].SetPeers("bad address"); err == nil {
+		t.Errorf("expected error")
+	}
+
+	caches := make([]*lru.Cache, 2)
+	buses := make([]*invalidation.Bus, 2)
+	for i := range buses {
+		caches[i], _ = lru.New(10)
+		caches[i].PutWithTags("a", 1, "tag")
+		caches[i].PutWithTags("b", 2, "tag")
+		buses[i] = newBus(t, caches[i], transports[i])
+	}
+
+	buses[0].Delete("a")
+	buses[1].InvalidateTag("tag")
+
+	eventually(t, func() bool { return buses[0].Stats().Applied == 1 && buses[1].Stats().Applied == 1 })
+
+	for i, c := range caches {
+		if c.Len() != 0 {
+			t.Errorf("cache %v length %v, want 0", i, c.Len())
+		}
+	}
+
+	buses[0].Close()
+	if err := transports[0].Send([]byte("message")); err != invalidation.ErrClosed {
+		t.Errorf("error %v, want %v", err, invalidation.ErrClosed)
+	}
+}
@@ -0,0 +1,198 @@
+package invalidation
+
+import (
+	"fmt"
+	"net"
+	"sync"
+)
+
+// inboxSize is the number of messages buffered by each member of a hub
+const inboxSize = 1024
+
+// Hub connects in-process members, e.g. for tests or several caches within a process
+type Hub struct {
+	m       *sync.Mutex
+	members map[*member]struct{}
+}
+
+// NewHub returns an empty hub
+func NewHub() *Hub {
+	return &Hub{
+		m:       &sync.Mutex{},
+		members: make(map[*member]struct{}),
+	}
+}
+
+// Join returns a transport delivering messages to other members of the hub.
+// Like a datagram network, the hub drops messages for members whose inboxes are full.
+func (h *Hub) Join() Transport {
+	m := &member{
+		hub:    h,
+		inbox:  make(chan []byte, inboxSize),
+		closed: make(chan struct{}),
+		once:   &sync.Once{},
+	}
+
+	h.m.Lock()
+	defer h.m.Unlock()
+
+	h.members[m] = struct{}{}
+	return m
+}
+
+type member struct {
+	hub    *Hub
+	inbox  chan []byte
+	closed chan struct{}
+	once   *sync.Once
+}
+
+// Send delivers the message to other members of the hub
+func (m *member) Send(msg []byte) error {
+	select {
+	case <-m.closed:
+		return ErrClosed
+	default:
+	}
+
+	m.hub.m.Lock()
+	defer m.hub.m.Unlock()
+
+	for other := range m.hub.members {
+		if other == m {
+			continue
+		}
+		select {
+		case other.inbox <- msg:
+		default:
+		}
+	}
+	return nil
+}
+
+// Receive returns the next message sent by other members
+func (m *member) Receive() ([]byte, error) {
+	select {
+	case msg := <-m.inbox:
+		return msg, nil
+	case <-m.closed:
+		return nil, ErrClosed
+	}
+}
+
+// Close leaves the hub
+func (m *member) Close() error {
+	m.once.Do(func() {
+		m.hub.m.Lock()
+		delete(m.hub.members, m)
+		m.hub.m.Unlock()
+
+		close(m.closed)
+	})
+	return nil
+}
+
+// maxDatagramSize is the maximum size of a UDP payload
+const maxDatagramSize = 65507
+
+// UDP sends messages to peers as UDP datagrams, one datagram per peer
+type UDP struct {
+	conn   *net.UDPConn
+	closed chan struct{}
+	once   *sync.Once
+
+	m     *sync.Mutex
+	peers []*net.UDPAddr
+}
+
+// ListenUDP returns a transport listening on the address, e.g. "127.0.0.1:7946"
+func ListenUDP(addr string) (*UDP, error) {
+	udpAddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := net.ListenUDP("udp", udpAddr)
+	if err != nil {
+		return nil, err
+	}
+
+	return &UDP{
+		conn:   conn,
+		closed: make(chan struct{}),
+		once:   &sync.Once{},
+		m:      &sync.Mutex{},
+	}, nil
+}
+
+// Addr returns the address the transport listens on
+func (u *UDP) Addr() net.Addr {
+	return u.conn.LocalAddr()
+}
+
+// SetPeers replaces the addresses messages are sent to
+func (u *UDP) SetPeers(peers ...string) error {
+	addrs := make([]*net.UDPAddr, 0, len(peers))
+	for _, peer := range peers {
+		addr, err := net.ResolveUDPAddr("udp", peer)
+		if err != nil {
+			return err
+		}
+		addrs = append(addrs, addr)
+	}
+
+	u.m.Lock()
+	defer u.m.Unlock()
+
+	u.peers = addrs
+	return nil
+}
+
+// Send sends the message to every peer returning the first error
+func (u *UDP) Send(msg []byte) error {
+	select {
+	case <-u.closed:
+		return ErrClosed
+	default:
+	}
+	if len(msg) > maxDatagramSize {
+		return fmt.Errorf("message of %d bytes exceeds a datagram", len(msg))
+	}
+
+	u.m.Lock()
+	peers := u.peers
+	u.m.Unlock()
+
+	var first error
+	for _, peer := range peers {
+		if _, err := u.conn.WriteToUDP(msg, peer); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// Receive returns the next received datagram
+func (u *UDP) Receive() ([]byte, error) {
+	buf := make([]byte, maxDatagramSize)
+	n, _, err := u.conn.ReadFromUDP(buf)
+	if err != nil {
+		select {
+		case <-u.closed:
+			return nil, ErrClosed
+		default:
+			return nil, err
+		}
+	}
+	return buf[:n], nil
+}
+
+// Close closes the socket
+func (u *UDP) Close() error {
+	var err error
+	u.once.Do(func() {
+		close(u.closed)
+		err = u.conn.Close()
+	})
+	return err
+}
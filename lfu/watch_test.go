@@ -0,0 +1,54 @@
+package lfu_test
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/faroyam/caches/lfu"
+	"github.com/faroyam/caches/watch"
+)
+
+// drain returns the buffered events
+func drain(events <-chan watch.Event) []watch.Event {
+	var drained []watch.Event
+	for {
+		select {
+		case e := <-events:
+			drained = append(drained, e)
+		default:
+			return drained
+		}
+	}
+}
+
+func TestCache_Watch(t *testing.T) {
+	if _, err := lfu.New(2, lfu.WithWatchBuffer(0, watch.Block)); err == nil {
+		t.Errorf("expected error")
+	}
+
+	cache, _ := lfu.New(2)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events := cache.Watch(ctx, nil)
+
+	cache.Put("a", 1)
+	cache.Put("a", 2)
+	cache.Put("b", 3)
+	cache.Put("c", 4)
+	cache.Delete("missing")
+	cache.Clear()
+
+	want := []watch.Event{
+		{Kind: watch.Put, Key: "a", Value: 1},
+		{Kind: watch.Update, Key: "a", Value: 2},
+		{Kind: watch.Put, Key: "b", Value: 3},
+		{Kind: watch.Evict, Key: "b", Value: 3},
+		{Kind: watch.Put, Key: "c", Value: 4},
+		{Kind: watch.Delete, Key: "c", Value: 4},
+		{Kind: watch.Delete, Key: "a", Value: 2},
+	}
+	if drained := drain(events); !reflect.DeepEqual(drained, want) {
+		t.Errorf("events %v, want %v", drained, want)
+	}
+}
@@ -0,0 +1,46 @@
+package lru
+
+import "time"
+
+// WithLeaseTTL sets the time after which leases granted by GetWithLease expire
+// and for which deleted values are returned as stale ones. 10s by default.
+func WithLeaseTTL(ttl time.Duration) Option {
+	return func(c *Cache) {
+		c.leases.TTL = ttl
+	}
+}
+
+// GetWithLease returns (value, 0, true) for a given key if it exists.
+// On a miss exactly one caller is granted a lease and gets (stale, token, false) with a non-zero token:
+// it is expected to load the value and save it with PutWithLease.
+// Other callers get (stale, 0, false) until the lease is filled or expires,
+// they should retry after a short wait or use the stale value.
+// stale is the value of the key deleted within the lease TTL or nil,
+// values are kept stale only once GetWithLease has been called.
+// Evicted values are not kept stale.
+func (c *Cache) GetWithLease(key string) (interface{}, uint64, bool) {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	if value, ok := c.get(key); ok {
+		return value, 0, true
+	}
+
+	token, stale := c.leases.Acquire(key)
+	return stale, token, false
+}
+
+// PutWithLease inserts a new record into the cache if the token is an unexpired lease of the key
+// granted by GetWithLease and not revoked since then by a write or a delete of the key.
+// Returns true if the record was inserted.
+func (c *Cache) PutWithLease(key string, value interface{}, token uint64) bool {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	if !c.leases.Release(key, token) {
+		return false
+	}
+
+	c.put(key, value)
+	return true
+}
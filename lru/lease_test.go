@@ -0,0 +1,105 @@
+package lru_test
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/faroyam/caches/lru"
+)
+
+func TestCache_GetWithLease(t *testing.T) {
+	if _, err := lru.New(2, lru.WithLeaseTTL(0)); err == nil {
+		t.Errorf("expected error")
+	}
+
+	cache, _ := lru.New(2)
+
+	v, token, ok := cache.GetWithLease("key")
+	if ok || v != nil || token == 0 {
+		t.Fatalf("got %v, %v, %v, want a lease", v, token, ok)
+	}
+
+	// other callers wait
+	if _, other, _ := cache.GetWithLease("key"); other != 0 {
+		t.Errorf("token %v while the lease is held, want 0", other)
+	}
+
+	if !cache.PutWithLease("key", 1, token) {
+		t.Errorf("lease isn't accepted")
+	}
+	if cache.PutWithLease("key", 2, token) {
+		t.Errorf("lease is accepted twice")
+	}
+	if v, token, ok := cache.GetWithLease("key"); !ok || v != 1 || token != 0 {
+		t.Errorf("got %v, %v, %v, want %v, 0, true", v, token, ok, 1)
+	}
+}
+
+func TestCache_GetWithLease_Delete(t *testing.T) {
+	cache, _ := lru.New(2)
+	cache.Put("key", 1)
+	cache.GetWithLease("other")
+
+	// the deleted value is stale, the lease is revoked by a delete racing with the fill
+	cache.Delete("key")
+	v, token, ok := cache.GetWithLease("key")
+	if ok || v != 1 || token == 0 {
+		t.Fatalf("got %v, %v, %v, want stale %v and a lease", v, token, ok, 1)
+	}
+
+	cache.Delete("key")
+	if cache.PutWithLease("key", 2, token) {
+		t.Errorf("revoked lease is accepted")
+	}
+
+	// a write revokes the lease as well
+	_, token, _ = cache.GetWithLease("key")
+	cache.Put("key", 3)
+	if cache.PutWithLease("key", 2, token) {
+		t.Errorf("revoked lease is accepted")
+	}
+	if v, _ := cache.Get("key"); v != 3 {
+		t.Errorf("cached value %v, want %v", v, 3)
+	}
+}
+
+func TestCache_GetWithLease_Expire(t *testing.T) {
+	cache, _ := lru.New(2, lru.WithLeaseTTL(10*time.Millisecond))
+
+	_, token, _ := cache.GetWithLease("key")
+	time.Sleep(20 * time.Millisecond)
+
+	_, next, _ := cache.GetWithLease("key")
+	if next == 0 || next == token {
+		t.Errorf("token %v after the lease expired, want a new one", next)
+	}
+	if cache.PutWithLease("key", 1, token) {
+		t.Errorf("expired lease is accepted")
+	}
+	if !cache.PutWithLease("key", 1, next) {
+		t.Errorf("lease isn't accepted")
+	}
+}
+
+func TestCache_GetWithLease_Race(t *testing.T) {
+	cache, _ := lru.New(2)
+
+	var leases int64
+	wg := &sync.WaitGroup{}
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, token, _ := cache.GetWithLease("key"); token != 0 {
+				atomic.AddInt64(&leases, 1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if leases != 1 {
+		t.Errorf("%v leases granted, want 1", leases)
+	}
+}
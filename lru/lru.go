@@ -3,7 +3,16 @@ package lru
 import (
 	"container/list"
 	"fmt"
+	"sort"
+	"strings"
 	"sync"
+
+	"github.com/faroyam/caches/internal/lease"
+	"github.com/faroyam/caches/internal/radix"
+	"github.com/faroyam/caches/internal/tagindex"
+	"github.com/faroyam/caches/internal/watchhub"
+	"github.com/faroyam/caches/wal"
+	"github.com/faroyam/caches/watch"
 )
 
 // Cache represents safe for concurrent use Least Recently Used cache
@@ -13,19 +22,84 @@ type Cache struct {
 
 	records *list.List
 	cache   map[string]*list.Element
+	tags    tagindex.Index
+	index   *radix.Tree
+	// version is the last version assigned to a record
+	version uint64
+
+	evictionHooks []func(key string, value interface{})
+	log           *wal.Log
+
+	leases lease.Leases
+
+	watchers watchhub.Hub
+}
+
+// Option configures a cache instance
+type Option func(*Cache)
+
+// WithPrefixIndex keeps the keys of the cache in a radix tree,
+// so that KeysWithPrefix and DeletePrefix take time proportional to the number of matching keys
+// instead of the number of records in the cache.
+func WithPrefixIndex() Option {
+	return func(c *Cache) {
+		c.index = radix.New()
+	}
+}
+
+// WithEvictionHook registers f to be called with the key and the value of every record
+// evicted to free space for a new one or to fit a reduced capacity.
+// f is called under the cache lock and must not use the cache.
+func WithEvictionHook(f func(key string, value interface{})) Option {
+	return func(c *Cache) {
+		c.evictionHooks = append(c.evictionHooks, f)
+	}
+}
+
+// WithWAL makes the cache append every change to the write-ahead log
+// and restores the records of the log when the cache is created.
+// Errors of the log are not returned by the cache methods and should be checked with log.Err.
+func WithWAL(log *wal.Log) Option {
+	return func(c *Cache) {
+		c.log = log
+	}
 }
 
 // New returns an initialized cache instance
-func New(capacity int) (*Cache, error) {
+func New(capacity int, options ...Option) (*Cache, error) {
 	if capacity <= 0 {
 		return nil, fmt.Errorf("capacity can't be negative")
 	}
-	return &Cache{
+	c := &Cache{
 		m:        &sync.Mutex{},
 		capacity: capacity,
 		records:  list.New(),
 		cache:    make(map[string]*list.Element, capacity),
-	}, nil
+		tags:     make(tagindex.Index),
+		leases:   lease.Leases{TTL: lease.DefaultTTL},
+
+		watchers: watchhub.New(),
+	}
+
+	for _, option := range options {
+		option(c)
+	}
+
+	if c.leases.TTL <= 0 {
+		return nil, fmt.Errorf("lease ttl must be positive")
+	}
+
+	err := c.watchers.Init()
+	if err != nil {
+		return nil, err
+	}
+
+	if c.log != nil {
+		if err = c.replay(); err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
 }
 
 // Get returns (value, true) or (nil, false) for a given key
@@ -33,35 +107,175 @@ func (c *Cache) Get(key string) (interface{}, bool) {
 	c.m.Lock()
 	defer c.m.Unlock()
 
+	return c.get(key)
+}
+
+// Put inserts a new record into the cache.
+// Tags of an existing record are kept.
+func (c *Cache) Put(key string, value interface{}) {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	c.put(key, value)
+}
+
+// PutWithTags inserts a new record tagged with the given tags into the cache.
+// Tags of an existing record are replaced.
+func (c *Cache) PutWithTags(key string, value interface{}, tags ...string) {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	c.put(key, value)
+	c.setTags(c.cache[key], tags)
+}
+
+// InvalidateTag removes all records tagged with the tag from the cache.
+// Returns the number of removed records.
+func (c *Cache) InvalidateTag(tag string) int {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	keys := c.tags.Keys(tag)
+	for _, key := range keys {
+		c.delete(key)
+	}
+	return len(keys)
+}
+
+// GetOrPut returns (value, true) for a given key if it exists.
+// Otherwise it inserts a new record and returns (value, false).
+func (c *Cache) GetOrPut(key string, value interface{}) (interface{}, bool) {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	if v, ok := c.get(key); ok {
+		return v, true
+	}
+
+	c.put(key, value)
+	return value, false
+}
+
+// PutIfAbsent inserts a new record into the cache if the key does not exist.
+// Returns true if the record was inserted.
+func (c *Cache) PutIfAbsent(key string, value interface{}) bool {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	if _, ok := c.cache[key]; ok {
+		return false
+	}
+
+	c.put(key, value)
+	return true
+}
+
+// Compute atomically replaces the value for a given key with the one returned by f.
+// f receives the current value and whether the key exists.
+// If f returns keep == false, the record is removed from the cache.
+// Returns the new value and keep.
+// f is called under the cache lock and must not use the cache.
+func (c *Cache) Compute(key string, f func(old interface{}, exists bool) (value interface{}, keep bool)) (interface{}, bool) {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	var old interface{}
+	e, exists := c.cache[key]
+	if exists {
+		old = e.Value.(record).value
+	}
+
+	value, keep := f(old, exists)
+	if !keep {
+		c.delete(key)
+		return value, false
+	}
+
+	c.put(key, value)
+	return value, true
+}
+
+// CompareAndSwap replaces the value for a given key with new if the current value equals old.
+// Returns true if the value was replaced.
+// Values are compared with ==, so old must be comparable.
+func (c *Cache) CompareAndSwap(key string, old, new interface{}) bool {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	e, ok := c.cache[key]
+	if !ok || e.Value.(record).value != old {
+		return false
+	}
+
+	c.put(key, new)
+	return true
+}
+
+// GetVersioned returns (value, version, true) or (nil, 0, false) for a given key.
+// Versions are positive and increase with every write of the record.
+// They are never reused within the cache, even if the record is deleted and inserted again,
+// so a version identifies a value for PutIfVersion.
+// Moves the record to the front like Get.
+func (c *Cache) GetVersioned(key string) (interface{}, uint64, bool) {
+	c.m.Lock()
+	defer c.m.Unlock()
+
 	e, ok := c.cache[key]
 	if !ok {
-		return nil, false
+		return nil, 0, false
 	}
 
 	c.records.MoveToFront(e)
-	return e.Value.(record).value, true
+	r := e.Value.(record)
+	return r.value, r.version, true
 }
 
-// Put inserts a new record into the cache
-func (c *Cache) Put(key string, value interface{}) {
+// PutIfVersion inserts a new record into the cache if the current version of the key equals version,
+// version 0 means that the key must not exist.
+// Returns the new version and true if the record was inserted,
+// or the current version and false otherwise.
+func (c *Cache) PutIfVersion(key string, value interface{}, version uint64) (uint64, bool) {
 	c.m.Lock()
 	defer c.m.Unlock()
 
-	e, ok := c.cache[key]
-	if ok {
-		c.records.Remove(e)
+	var current uint64
+	if e, ok := c.cache[key]; ok {
+		current = e.Value.(record).version
+	}
+	if current != version {
+		return current, false
+	}
+
+	c.put(key, value)
+	return c.version, true
+}
+
+// Increment atomically adds delta to the integer value for a given key and returns the result.
+// A missing key is treated as 0.
+// Returns an error if the current value is not an int or int64.
+func (c *Cache) Increment(key string, delta int64) (int64, error) {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	var old interface{} = int64(0)
+	if e, ok := c.cache[key]; ok {
+		old = e.Value.(record).value
 	}
 
-	if len(c.cache) >= c.capacity && !ok {
-		e := c.records.Remove(c.records.Back())
-		delete(c.cache, e.(record).key)
+	value, n, err := add(old, delta)
+	if err != nil {
+		return 0, fmt.Errorf("can't increment %q: %w", key, err)
 	}
 
-	e = c.records.PushFront(record{
-		key:   key,
-		value: value,
-	})
-	c.cache[key] = e
+	c.put(key, value)
+	return n, nil
+}
+
+// Decrement atomically subtracts delta from the integer value for a given key and returns the result.
+// A missing key is treated as 0.
+// Returns an error if the current value is not an int or int64.
+func (c *Cache) Decrement(key string, delta int64) (int64, error) {
+	return c.Increment(key, -delta)
 }
 
 // LRU returns (key, true) that was not touched for the longest time.
@@ -77,17 +291,122 @@ func (c *Cache) LRU() (string, bool) {
 	return "", false
 }
 
-// Delete removes the record associated with the specified key from the cache
-func (c *Cache) Delete(key string) {
+// Peek returns (value, true) or (nil, false) for a given key.
+// Does not "use" record i.e. returning record will remain untouched.
+func (c *Cache) Peek(key string) (interface{}, bool) {
 	c.m.Lock()
 	defer c.m.Unlock()
 
 	e, ok := c.cache[key]
 	if !ok {
-		return
+		return nil, false
 	}
-	r := c.records.Remove(e).(record)
-	delete(c.cache, r.key)
+	return e.Value.(record).value, true
+}
+
+// Contains reports whether the key is in the cache.
+// Does not "use" record i.e. the record will remain untouched.
+func (c *Cache) Contains(key string) bool {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	_, ok := c.cache[key]
+	return ok
+}
+
+// Keys returns the keys in eviction order i.e. the least recently used key comes first.
+// The keys are a snapshot taken under the lock: writes made after Keys returns are not reflected.
+func (c *Cache) Keys() []string {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	keys := make([]string, 0, len(c.cache))
+	for e := c.records.Back(); e != nil; e = e.Prev() {
+		keys = append(keys, e.Value.(record).key)
+	}
+	return keys
+}
+
+// Range calls f sequentially for each key and value in eviction order.
+// If f returns false, Range stops the iteration.
+// Range iterates over a snapshot taken under the lock and calls f without holding it,
+// so f may use the cache, but writes made during the iteration are not reflected.
+// Does not "use" records i.e. the order of records will remain untouched.
+func (c *Cache) Range(f func(key string, value interface{}) bool) {
+	for _, r := range c.snapshot() {
+		if !f(r.key, r.value) {
+			return
+		}
+	}
+}
+
+// Delete removes the record associated with the specified key from the cache
+func (c *Cache) Delete(key string) {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	c.delete(key)
+}
+
+// GetMany returns values for the given keys that exist in the cache.
+// Missing keys are absent from the result.
+// Takes the lock once and is equivalent to calling Get for each key.
+func (c *Cache) GetMany(keys []string) map[string]interface{} {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	values := make(map[string]interface{}, len(keys))
+	for _, key := range keys {
+		if value, ok := c.get(key); ok {
+			values[key] = value
+		}
+	}
+	return values
+}
+
+// PutMany inserts the given records into the cache.
+// Takes the lock once and is equivalent to calling Put for each record
+// in the iteration order of the map.
+func (c *Cache) PutMany(values map[string]interface{}) {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	for key, value := range values {
+		c.put(key, value)
+	}
+}
+
+// DeleteMany removes the records associated with the given keys from the cache.
+// Takes the lock once and is equivalent to calling Delete for each key.
+func (c *Cache) DeleteMany(keys []string) {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	for _, key := range keys {
+		c.delete(key)
+	}
+}
+
+// KeysWithPrefix returns the keys of records starting with the prefix in lexicographic order.
+// Does not "use" records.
+func (c *Cache) KeysWithPrefix(prefix string) []string {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	return c.keysWithPrefix(prefix)
+}
+
+// DeletePrefix removes the records which keys start with the prefix from the cache.
+// Returns the number of removed records.
+func (c *Cache) DeletePrefix(prefix string) int {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	keys := c.keysWithPrefix(prefix)
+	for _, key := range keys {
+		c.delete(key)
+	}
+	return len(keys)
 }
 
 // Clear removes all saved records
@@ -95,16 +414,211 @@ func (c *Cache) Clear() {
 	c.m.Lock()
 	defer c.m.Unlock()
 
+	if c.log != nil {
+		c.log.Clear()
+	}
+	if c.watchers.Active() {
+		for e := c.records.Back(); e != nil; e = e.Prev() {
+			r := e.Value.(record)
+			c.watchers.Publish(watch.Delete, r.key, r.value)
+		}
+	}
+
 	c.cache = make(map[string]*list.Element, c.capacity)
 	c.records = list.New()
+	c.tags = make(tagindex.Index)
+	if c.index != nil {
+		c.index = radix.New()
+	}
+	c.leases.Clear()
 }
 
 // Len returns the number of records in the cache
 func (c *Cache) Len() int {
+	c.m.Lock()
+	defer c.m.Unlock()
+
 	return len(c.cache)
 }
 
+// Cap returns the maximum number of records in the cache
+func (c *Cache) Cap() int {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	return c.capacity
+}
+
+// Resize changes the capacity of the cache.
+// Shrinking evicts the least recently used records until the cache fits the new capacity.
+func (c *Cache) Resize(capacity int) error {
+	if capacity <= 0 {
+		return fmt.Errorf("capacity can't be negative")
+	}
+
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	c.capacity = capacity
+	for len(c.cache) > c.capacity {
+		c.evict()
+	}
+	return nil
+}
+
+func (c *Cache) get(key string) (interface{}, bool) {
+	e, ok := c.cache[key]
+	if !ok {
+		return nil, false
+	}
+
+	c.records.MoveToFront(e)
+	return e.Value.(record).value, true
+}
+
+func (c *Cache) put(key string, value interface{}) {
+	c.leases.Written(key)
+	c.version++
+	if c.log != nil {
+		c.log.Put(key, value, 0)
+	}
+
+	if e, ok := c.cache[key]; ok {
+		r := e.Value.(record)
+		r.value = value
+		r.version = c.version
+		e.Value = r
+
+		c.records.MoveToFront(e)
+		c.watchers.Publish(watch.Update, key, value)
+		return
+	}
+
+	if len(c.cache) >= c.capacity {
+		c.evict()
+	}
+
+	c.cache[key] = c.records.PushFront(record{
+		key:     key,
+		value:   value,
+		version: c.version,
+	})
+	if c.index != nil {
+		c.index.Insert(key)
+	}
+	c.watchers.Publish(watch.Put, key, value)
+}
+
+func (c *Cache) delete(key string) {
+	e, ok := c.cache[key]
+	if !ok {
+		c.leases.Deleted(key, nil, false)
+		return
+	}
+
+	r := c.remove(e)
+	c.leases.Deleted(key, r.value, true)
+	c.watchers.Publish(watch.Delete, key, r.value)
+}
+
+// evict removes the least recently used record
+func (c *Cache) evict() {
+	r := c.remove(c.records.Back())
+	for _, hook := range c.evictionHooks {
+		hook(r.key, r.value)
+	}
+	c.watchers.Publish(watch.Evict, r.key, r.value)
+}
+
+// remove removes the record from the list, the map and the indexes
+func (c *Cache) remove(e *list.Element) record {
+	r := c.records.Remove(e).(record)
+	if c.log != nil {
+		c.log.Delete(r.key)
+	}
+
+	delete(c.cache, r.key)
+	c.tags.Remove(r.key, r.tags)
+	if c.index != nil {
+		c.index.Delete(r.key)
+	}
+	return r
+}
+
+// keysWithPrefix uses the prefix index if it is enabled and scans all keys otherwise
+func (c *Cache) keysWithPrefix(prefix string) []string {
+	var keys []string
+	if c.index != nil {
+		c.index.WalkPrefix(prefix, func(key string) bool {
+			keys = append(keys, key)
+			return true
+		})
+		return keys
+	}
+
+	for key := range c.cache {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (c *Cache) setTags(e *list.Element, tags []string) {
+	r := e.Value.(record)
+	c.tags.Remove(r.key, r.tags)
+
+	r.tags = append([]string(nil), tags...)
+	c.tags.Add(r.key, r.tags)
+	e.Value = r
+}
+
+// replay inserts the records of the log into the cache without logging them again
+func (c *Cache) replay() error {
+	entries, err := c.log.Entries()
+	if err != nil {
+		return err
+	}
+
+	log := c.log
+	c.log = nil
+	for _, e := range entries {
+		c.put(e.Key, e.Value)
+	}
+	c.log = log
+	return nil
+}
+
+func (c *Cache) snapshot() []record {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	records := make([]record, 0, len(c.cache))
+	for e := c.records.Back(); e != nil; e = e.Prev() {
+		records = append(records, e.Value.(record))
+	}
+	return records
+}
+
 type record struct {
-	key   string
-	value interface{}
+	key     string
+	value   interface{}
+	tags    []string
+	version uint64
+}
+
+// add returns the sum of an int or int64 value and delta
+// both as a value of the original type and as int64
+func add(value interface{}, delta int64) (interface{}, int64, error) {
+	switch v := value.(type) {
+	case int:
+		n := v + int(delta)
+		return n, int64(n), nil
+	case int64:
+		n := v + delta
+		return n, n, nil
+	default:
+		return nil, 0, fmt.Errorf("value of type %T is not an integer", value)
+	}
 }
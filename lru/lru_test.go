@@ -1,9 +1,12 @@
 package lru_test
 
 import (
+	"reflect"
+	"sync"
 	"testing"
 
 	"github.com/faroyam/caches/lru"
+	"github.com/faroyam/caches/wal"
 )
 
 func TestCache_New(t *testing.T) {
@@ -55,6 +58,87 @@ func TestCache_LRU(t *testing.T) {
 	}
 }
 
+func TestCache_Peek(t *testing.T) {
+	cache, _ := lru.New(2)
+	cache.Put("1", "1")
+	cache.Put("2", "2")
+
+	if value, ok := cache.Peek("1"); !ok || value != "1" {
+		t.Errorf("cached value %v, want %v", value, "1")
+	}
+
+	if value, ok := cache.Peek("non-existing-key"); ok {
+		t.Errorf("cached value %v, want %v", value, "nil")
+	}
+
+	if key, _ := cache.LRU(); key != "1" {
+		t.Errorf("lru %v, want %v", key, "1")
+	}
+}
+
+func TestCache_Contains(t *testing.T) {
+	cache, _ := lru.New(2)
+	cache.Put("1", "1")
+	cache.Put("2", "2")
+
+	if !cache.Contains("1") {
+		t.Errorf("contains %v, want %v", false, true)
+	}
+
+	if cache.Contains("non-existing-key") {
+		t.Errorf("contains %v, want %v", true, false)
+	}
+
+	if key, _ := cache.LRU(); key != "1" {
+		t.Errorf("lru %v, want %v", key, "1")
+	}
+}
+
+func TestCache_Keys(t *testing.T) {
+	cache, _ := lru.New(3)
+	if keys := cache.Keys(); len(keys) != 0 {
+		t.Errorf("keys %v, want %v", keys, []string{})
+	}
+
+	cache.Put("1", "1")
+	cache.Put("2", "2")
+	cache.Put("3", "3")
+	cache.Get("1")
+
+	want := []string{"2", "3", "1"}
+	if keys := cache.Keys(); !reflect.DeepEqual(keys, want) {
+		t.Errorf("keys %v, want %v", keys, want)
+	}
+}
+
+func TestCache_Range(t *testing.T) {
+	cache, _ := lru.New(3)
+	cache.Put("1", 1)
+	cache.Put("2", 2)
+	cache.Put("3", 3)
+
+	var keys []string
+	var values []interface{}
+	cache.Range(func(key string, value interface{}) bool {
+		keys = append(keys, key)
+		values = append(values, value)
+		cache.Get(key)
+		return key != "2"
+	})
+
+	if want := []string{"1", "2"}; !reflect.DeepEqual(keys, want) {
+		t.Errorf("keys %v, want %v", keys, want)
+	}
+
+	if want := []interface{}{1, 2}; !reflect.DeepEqual(values, want) {
+		t.Errorf("values %v, want %v", values, want)
+	}
+
+	if key, _ := cache.LRU(); key != "3" {
+		t.Errorf("lru %v, want %v", key, "3")
+	}
+}
+
 func TestCache_Delete(t *testing.T) {
 	cache, _ := lru.New(1)
 	cache.Put("key", "value")
@@ -71,6 +155,156 @@ func TestCache_Delete(t *testing.T) {
 	}
 }
 
+func TestCache_GetMany(t *testing.T) {
+	cache, _ := lru.New(3)
+	cache.Put("1", 1)
+	cache.Put("2", 2)
+
+	want := map[string]interface{}{"1": 1, "2": 2}
+	if values := cache.GetMany([]string{"1", "2", "non-existing-key"}); !reflect.DeepEqual(values, want) {
+		t.Errorf("cached values %v, want %v", values, want)
+	}
+}
+
+func TestCache_PutMany(t *testing.T) {
+	cache, _ := lru.New(2)
+	cache.PutMany(map[string]interface{}{"1": 1, "2": 2, "3": 3})
+
+	if cache.Len() != 2 {
+		t.Errorf("cache len %v, want %v", cache.Len(), 2)
+	}
+
+	cache.PutMany(map[string]interface{}{"4": 4})
+
+	if value, ok := cache.Get("4"); !ok || value != 4 {
+		t.Errorf("cached value %v, want %v", value, 4)
+	}
+}
+
+func TestCache_DeleteMany(t *testing.T) {
+	cache, _ := lru.New(3)
+	cache.Put("1", 1)
+	cache.Put("2", 2)
+	cache.Put("3", 3)
+
+	cache.DeleteMany([]string{"1", "3", "non-existing-key"})
+
+	if want := []string{"2"}; !reflect.DeepEqual(cache.Keys(), want) {
+		t.Errorf("keys %v, want %v", cache.Keys(), want)
+	}
+}
+
+func TestCache_InvalidateTag(t *testing.T) {
+	cache, _ := lru.New(4)
+	cache.PutWithTags("user/1", 1, "user", "tenant/a")
+	cache.PutWithTags("user/2", 2, "user", "tenant/b")
+	cache.PutWithTags("order/1", 3, "tenant/a")
+	cache.Put("4", 4)
+
+	if n := cache.InvalidateTag("tenant/a"); n != 2 {
+		t.Errorf("invalidated %v, want %v", n, 2)
+	}
+
+	if want := []string{"user/2", "4"}; !reflect.DeepEqual(cache.Keys(), want) {
+		t.Errorf("keys %v, want %v", cache.Keys(), want)
+	}
+
+	cache.Put("user/2", 20)
+	if n := cache.InvalidateTag("user"); n != 1 {
+		t.Errorf("invalidated %v, want %v", n, 1)
+	}
+
+	if n := cache.InvalidateTag("non-existing-tag"); n != 0 {
+		t.Errorf("invalidated %v, want %v", n, 0)
+	}
+}
+
+func TestCache_PutWithTags_Replace(t *testing.T) {
+	cache, _ := lru.New(2)
+	cache.PutWithTags("key", 1, "a")
+	cache.PutWithTags("key", 2, "b")
+
+	if n := cache.InvalidateTag("a"); n != 0 {
+		t.Errorf("invalidated %v, want %v", n, 0)
+	}
+
+	if n := cache.InvalidateTag("b"); n != 1 {
+		t.Errorf("invalidated %v, want %v", n, 1)
+	}
+}
+
+func TestCache_InvalidateTag_Removed(t *testing.T) {
+	cache, _ := lru.New(1)
+	cache.PutWithTags("1", 1, "tag")
+	cache.PutWithTags("2", 2, "tag")
+
+	// "1" is evicted
+
+	if n := cache.InvalidateTag("tag"); n != 1 {
+		t.Errorf("invalidated %v, want %v", n, 1)
+	}
+
+	cache.PutWithTags("3", 3, "tag")
+	cache.Delete("3")
+
+	if n := cache.InvalidateTag("tag"); n != 0 {
+		t.Errorf("invalidated %v, want %v", n, 0)
+	}
+
+	cache.PutWithTags("4", 4, "tag")
+	cache.Clear()
+
+	if n := cache.InvalidateTag("tag"); n != 0 {
+		t.Errorf("invalidated %v, want %v", n, 0)
+	}
+}
+
+func TestCache_KeysWithPrefix(t *testing.T) {
+	for _, options := range [][]lru.Option{nil, {lru.WithPrefixIndex()}} {
+		cache, _ := lru.New(3, options...)
+		cache.Put("org/1/repo/2", 1)
+		cache.Put("org/1/repo/1", 2)
+		cache.Put("org/12", 3)
+		cache.Put("org/2", 4)
+
+		// "org/1/repo/2" is evicted
+
+		want := []string{"org/1/repo/1", "org/12"}
+		if keys := cache.KeysWithPrefix("org/1"); !reflect.DeepEqual(keys, want) {
+			t.Errorf("keys %v, want %v", keys, want)
+		}
+
+		if keys := cache.KeysWithPrefix("user/"); len(keys) != 0 {
+			t.Errorf("keys %v, want %v", keys, []string{})
+		}
+	}
+}
+
+func TestCache_DeletePrefix(t *testing.T) {
+	for _, options := range [][]lru.Option{nil, {lru.WithPrefixIndex()}} {
+		cache, _ := lru.New(4, options...)
+		cache.Put("org/1/repo/1", 1)
+		cache.Put("org/1/repo/2", 2)
+		cache.Put("org/12", 3)
+		cache.Put("org/2", 4)
+
+		if n := cache.DeletePrefix("org/1/"); n != 2 {
+			t.Errorf("deleted %v, want %v", n, 2)
+		}
+
+		if want := []string{"org/12", "org/2"}; !reflect.DeepEqual(cache.KeysWithPrefix(""), want) {
+			t.Errorf("keys %v, want %v", cache.KeysWithPrefix(""), want)
+		}
+
+		cache.Clear()
+		cache.Put("org/3", 5)
+
+		if want := []string{"org/3"}; !reflect.DeepEqual(cache.KeysWithPrefix("org/"), want) {
+			t.Errorf("keys %v, want %v", cache.KeysWithPrefix("org/"), want)
+		}
+	}
+}
+
 func TestCache_Clear(t *testing.T) {
 	cache, _ := lru.New(10)
 	cache.Put("key1", "value1")
@@ -82,6 +316,288 @@ func TestCache_Clear(t *testing.T) {
 	}
 }
 
+func TestCache_Resize(t *testing.T) {
+	cache, _ := lru.New(3)
+	if err := cache.Resize(0); err == nil {
+		t.Errorf("expected error")
+	}
+
+	cache.Put("1", 1)
+	cache.Put("2", 2)
+	cache.Put("3", 3)
+	cache.Get("1")
+
+	if err := cache.Resize(2); err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+
+	if cache.Cap() != 2 {
+		t.Errorf("cache cap %v, want %v", cache.Cap(), 2)
+	}
+
+	if want := []string{"3", "1"}; !reflect.DeepEqual(cache.Keys(), want) {
+		t.Errorf("keys %v, want %v", cache.Keys(), want)
+	}
+
+	if err := cache.Resize(3); err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+
+	cache.Put("4", 4)
+
+	if cache.Len() != 3 {
+		t.Errorf("cache len %v, want %v", cache.Len(), 3)
+	}
+}
+
+func TestCache_GetOrPut(t *testing.T) {
+	cache, _ := lru.New(2)
+
+	if value, loaded := cache.GetOrPut("key", "value1"); loaded || value != "value1" {
+		t.Errorf("cached value %v, loaded %v, want %v, %v", value, loaded, "value1", false)
+	}
+
+	if value, loaded := cache.GetOrPut("key", "value2"); !loaded || value != "value1" {
+		t.Errorf("cached value %v, loaded %v, want %v, %v", value, loaded, "value1", true)
+	}
+}
+
+func TestCache_PutIfAbsent(t *testing.T) {
+	cache, _ := lru.New(2)
+
+	if ok := cache.PutIfAbsent("key", "value1"); !ok {
+		t.Errorf("put %v, want %v", ok, true)
+	}
+
+	if ok := cache.PutIfAbsent("key", "value2"); ok {
+		t.Errorf("put %v, want %v", ok, false)
+	}
+
+	if value, _ := cache.Get("key"); value != "value1" {
+		t.Errorf("cached value %v, want %v", value, "value1")
+	}
+}
+
+func TestCache_Compute(t *testing.T) {
+	cache, _ := lru.New(2)
+
+	appendValue := func(old interface{}, exists bool) (interface{}, bool) {
+		if !exists {
+			return "a", true
+		}
+		return old.(string) + "a", true
+	}
+
+	cache.Compute("key", appendValue)
+	if value, keep := cache.Compute("key", appendValue); !keep || value != "aa" {
+		t.Errorf("computed value %v, keep %v, want %v, %v", value, keep, "aa", true)
+	}
+
+	if value, _ := cache.Get("key"); value != "aa" {
+		t.Errorf("cached value %v, want %v", value, "aa")
+	}
+
+	cache.Compute("key", func(old interface{}, exists bool) (interface{}, bool) {
+		return nil, false
+	})
+
+	if cache.Contains("key") {
+		t.Errorf("contains %v, want %v", true, false)
+	}
+}
+
+func TestCache_CompareAndSwap(t *testing.T) {
+	cache, _ := lru.New(2)
+
+	if ok := cache.CompareAndSwap("key", nil, "value"); ok {
+		t.Errorf("swapped %v, want %v", ok, false)
+	}
+
+	cache.Put("key", "value1")
+
+	if ok := cache.CompareAndSwap("key", "value2", "value3"); ok {
+		t.Errorf("swapped %v, want %v", ok, false)
+	}
+
+	if ok := cache.CompareAndSwap("key", "value1", "value2"); !ok {
+		t.Errorf("swapped %v, want %v", ok, true)
+	}
+
+	if value, _ := cache.Get("key"); value != "value2" {
+		t.Errorf("cached value %v, want %v", value, "value2")
+	}
+}
+
+func TestCache_PutIfVersion(t *testing.T) {
+	cache, _ := lru.New(2)
+
+	if _, ok := cache.PutIfVersion("key", "value1", 1); ok {
+		t.Errorf("put %v, want %v", ok, false)
+	}
+
+	version, ok := cache.PutIfVersion("key", "value1", 0)
+	if !ok || version == 0 {
+		t.Fatalf("put %v, version %v, want a new version", ok, version)
+	}
+
+	if value, v, ok := cache.GetVersioned("key"); !ok || value != "value1" || v != version {
+		t.Errorf("got %v, %v, %v, want %v, %v, %v", value, v, ok, "value1", version, true)
+	}
+
+	// every write bumps the version
+	cache.Put("key", "value2")
+	if current, ok := cache.PutIfVersion("key", "value3", version); ok || current <= version {
+		t.Errorf("put %v, version %v, want %v and a version greater than %v", ok, current, false, version)
+	}
+
+	// versions are not reused after a delete
+	_, version, _ = cache.GetVersioned("key")
+	cache.Delete("key")
+	cache.Put("key", "value4")
+	if _, ok := cache.PutIfVersion("key", "value5", version); ok {
+		t.Errorf("put %v with a version of a deleted record, want %v", ok, false)
+	}
+
+	if _, v, ok := cache.GetVersioned("missing"); ok || v != 0 {
+		t.Errorf("got version %v, %v for a missing key, want %v, %v", v, ok, 0, false)
+	}
+}
+
+func TestCache_Increment(t *testing.T) {
+	cache, _ := lru.New(3)
+
+	if n, err := cache.Increment("int64", 2); err != nil || n != 2 {
+		t.Errorf("incremented value %v, error %v, want %v, %v", n, err, 2, nil)
+	}
+
+	if n, err := cache.Decrement("int64", 3); err != nil || n != -1 {
+		t.Errorf("decremented value %v, error %v, want %v, %v", n, err, -1, nil)
+	}
+
+	cache.Put("int", 1)
+
+	if n, err := cache.Increment("int", 1); err != nil || n != 2 {
+		t.Errorf("incremented value %v, error %v, want %v, %v", n, err, 2, nil)
+	}
+
+	if value, _ := cache.Get("int"); value != 2 {
+		t.Errorf("cached value %v, want %v", value, 2)
+	}
+
+	cache.Put("string", "1")
+
+	if _, err := cache.Increment("string", 1); err == nil {
+		t.Errorf("expected error")
+	}
+}
+
+func TestCache_Atomic_Race(t *testing.T) {
+	const (
+		goroutines = 8
+		iterations = 1000
+	)
+
+	cache, _ := lru.New(10)
+
+	var wg sync.WaitGroup
+	for i := 0; i < goroutines; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for j := 0; j < iterations; j++ {
+				cache.Increment("counter", 1)
+				cache.Compute("compute", func(old interface{}, exists bool) (interface{}, bool) {
+					if !exists {
+						return 1, true
+					}
+					return old.(int) + 1, true
+				})
+				for {
+					old, _ := cache.GetOrPut("cas", 0)
+					if cache.CompareAndSwap("cas", old, old.(int)+1) {
+						break
+					}
+				}
+				for {
+					old, version, _ := cache.GetVersioned("versioned")
+					n, _ := old.(int)
+					if _, ok := cache.PutIfVersion("versioned", n+1, version); ok {
+						break
+					}
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	for _, key := range []string{"counter", "compute", "cas", "versioned"} {
+		if n, _ := cache.Increment(key, 0); n != goroutines*iterations {
+			t.Errorf("%v value %v, want %v", key, n, goroutines*iterations)
+		}
+	}
+}
+
+func TestCache_WithEvictionHook(t *testing.T) {
+	var evicted []string
+	cache, _ := lru.New(2, lru.WithEvictionHook(func(key string, value interface{}) {
+		evicted = append(evicted, key+"="+value.(string))
+	}))
+
+	cache.Put("1", "1")
+	cache.Put("2", "2")
+	cache.Delete("2")
+	cache.Put("3", "3")
+	cache.Put("4", "4")
+	cache.Resize(1)
+
+	if want := []string{"1=1", "3=3"}; !reflect.DeepEqual(evicted, want) {
+		t.Errorf("evicted %v, want %v", evicted, want)
+	}
+}
+
+func TestCache_WithWAL(t *testing.T) {
+	dir := t.TempDir()
+	log, _ := wal.Open(dir)
+	cache, _ := lru.New(2, lru.WithWAL(log))
+
+	cache.Put("1", "1")
+	cache.Put("2", "2")
+	cache.Get("1")
+	cache.Get("1")
+	cache.Put("3", "3")
+	cache.Put("1", "1'")
+	log.Close()
+
+	// "2" is evicted before the restart
+
+	log, _ = wal.Open(dir)
+	cache, err := lru.New(2, lru.WithWAL(log))
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	if keys := cache.Keys(); !reflect.DeepEqual(keys, []string{"3", "1"}) {
+		t.Errorf("keys %v, want %v", keys, []string{"3", "1"})
+	}
+
+	if value, ok := cache.Get("1"); !ok || value != "1'" {
+		t.Errorf("cached value %v, want %v", value, "1'")
+	}
+
+	cache.Clear()
+	cache.Put("4", "4")
+	log.Close()
+
+	log, _ = wal.Open(dir)
+	defer log.Close()
+	cache, _ = lru.New(2, lru.WithWAL(log))
+
+	if keys := cache.Keys(); !reflect.DeepEqual(keys, []string{"4"}) {
+		t.Errorf("keys %v, want %v", keys, []string{"4"})
+	}
+}
+
 func TestReplace(t *testing.T) {
 	cache, _ := lru.New(10)
 	cache.Put("key", "value1")
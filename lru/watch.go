@@ -0,0 +1,24 @@
+package lru
+
+import (
+	"context"
+
+	"github.com/faroyam/caches/watch"
+)
+
+// WithWatchBuffer sets the number of events buffered for each watcher
+// and the policy applied to events published while the buffer is full.
+// watch.DefaultBuffer and watch.Disconnect by default.
+func WithWatchBuffer(size int, policy watch.Policy) Option {
+	return func(c *Cache) {
+		c.watchers.Buffer = size
+		c.watchers.Policy = policy
+	}
+}
+
+// Watch returns a channel of changes of the cache selected by the filter, nil selects all changes.
+// The channel is closed when ctx is done or the watcher is disconnected by the overflow policy.
+// Clear publishes a Delete event for every record.
+func (c *Cache) Watch(ctx context.Context, filter watch.Filter) <-chan watch.Event {
+	return c.watchers.Watch(ctx, filter)
+}
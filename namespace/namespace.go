@@ -0,0 +1,307 @@
+// Package namespace implements a Least Recently Used cache partitioned into namespaces.
+//
+// Every namespace has a quota: a share of the cache capacity that is guaranteed to it.
+// A namespace may borrow the unused capacity of other namespaces,
+// and the borrowed capacity is reclaimed as soon as the cache runs out of space:
+// the records are evicted from the namespace that exceeds its quota the most,
+// so one noisy namespace can't evict the records of the others.
+package namespace
+
+import (
+	"container/list"
+	"fmt"
+	"sync"
+)
+
+// Cache represents safe for concurrent use Least Recently Used cache partitioned into namespaces
+type Cache struct {
+	m        *sync.Mutex
+	capacity int64
+	used     int64
+	sizer    func(value interface{}) int64
+
+	namespaces map[string]*namespace
+}
+
+// Option configures a cache instance
+type Option func(*Cache)
+
+// WithSizer makes the cache measure capacity, quotas and usage in the sizes returned by sizer,
+// e.g. in bytes, instead of the number of records.
+func WithSizer(sizer func(value interface{}) int64) Option {
+	return func(c *Cache) {
+		c.sizer = sizer
+	}
+}
+
+// Stats represents usage statistics of a namespace
+type Stats struct {
+	Quota     int64
+	Used      int64
+	Len       int
+	Hits      int64
+	Misses    int64
+	Evictions int64
+}
+
+// Borrowed returns the capacity used by the namespace in excess of its quota
+func (s Stats) Borrowed() int64 {
+	if s.Used > s.Quota {
+		return s.Used - s.Quota
+	}
+	return 0
+}
+
+// New returns an initialized cache instance
+func New(capacity int64, options ...Option) (*Cache, error) {
+	if capacity <= 0 {
+		return nil, fmt.Errorf("capacity can't be negative")
+	}
+	c := &Cache{
+		m:          &sync.Mutex{},
+		capacity:   capacity,
+		sizer:      func(interface{}) int64 { return 1 },
+		namespaces: make(map[string]*namespace),
+	}
+
+	for _, option := range options {
+		option(c)
+	}
+	return c, nil
+}
+
+// Namespace returns a view of the cache restricted to the namespace with the given name.
+// Creates the namespace if it does not exist or updates its quota otherwise.
+// Quotas are guaranteed only while their sum does not exceed the cache capacity.
+func (c *Cache) Namespace(name string, quota int64) (*View, error) {
+	if quota <= 0 {
+		return nil, fmt.Errorf("quota can't be negative")
+	}
+
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	ns, ok := c.namespaces[name]
+	if !ok {
+		ns = &namespace{
+			records: list.New(),
+			cache:   make(map[string]*list.Element),
+		}
+		c.namespaces[name] = ns
+	}
+	ns.quota = quota
+
+	return &View{
+		cache: c,
+		name:  name,
+		ns:    ns,
+	}, nil
+}
+
+// Stats returns (statistics, true) of the namespace or (Stats{}, false) if there is no such namespace
+func (c *Cache) Stats(name string) (Stats, bool) {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	ns, ok := c.namespaces[name]
+	if !ok {
+		return Stats{}, false
+	}
+	return ns.stats(), true
+}
+
+// ClearNamespace removes all records of the namespace.
+// The namespace keeps its quota and statistics.
+func (c *Cache) ClearNamespace(name string) {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	if ns, ok := c.namespaces[name]; ok {
+		c.clear(ns)
+	}
+}
+
+// Len returns the number of records in all namespaces
+func (c *Cache) Len() int {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	n := 0
+	for _, ns := range c.namespaces {
+		n += len(ns.cache)
+	}
+	return n
+}
+
+// Used returns the capacity used by all namespaces
+func (c *Cache) Used() int64 {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	return c.used
+}
+
+func (c *Cache) put(ns *namespace, key string, value interface{}) {
+	size := c.sizer(value)
+
+	if e, ok := ns.cache[key]; ok {
+		c.remove(ns, e)
+	}
+
+	if size > c.capacity {
+		return
+	}
+
+	for c.used+size > c.capacity {
+		victim := c.victim(ns, size)
+		c.remove(victim, victim.records.Back())
+		victim.evictions++
+	}
+
+	ns.cache[key] = ns.records.PushFront(record{
+		key:   key,
+		value: value,
+		size:  size,
+	})
+	ns.used += size
+	c.used += size
+}
+
+// victim returns the namespace that exceeds its quota the most
+// taking into account the size of the record being inserted into ns
+func (c *Cache) victim(ns *namespace, size int64) *namespace {
+	var victim *namespace
+	var maxExcess int64
+
+	for _, candidate := range c.namespaces {
+		if len(candidate.cache) == 0 {
+			continue
+		}
+
+		excess := candidate.used - candidate.quota
+		if candidate == ns {
+			excess += size
+		}
+
+		if victim == nil || excess > maxExcess {
+			victim, maxExcess = candidate, excess
+		}
+	}
+	return victim
+}
+
+func (c *Cache) remove(ns *namespace, e *list.Element) {
+	r := ns.records.Remove(e).(record)
+	delete(ns.cache, r.key)
+	ns.used -= r.size
+	c.used -= r.size
+}
+
+func (c *Cache) clear(ns *namespace) {
+	c.used -= ns.used
+	ns.used = 0
+	ns.records = list.New()
+	ns.cache = make(map[string]*list.Element)
+}
+
+// View represents a namespace of the cache.
+// Keys of different namespaces never collide.
+type View struct {
+	cache *Cache
+	name  string
+	ns    *namespace
+}
+
+// Name returns the name of the namespace
+func (v *View) Name() string {
+	return v.name
+}
+
+// Get returns (value, true) or (nil, false) for a given key
+func (v *View) Get(key string) (interface{}, bool) {
+	v.cache.m.Lock()
+	defer v.cache.m.Unlock()
+
+	e, ok := v.ns.cache[key]
+	if !ok {
+		v.ns.misses++
+		return nil, false
+	}
+
+	v.ns.hits++
+	v.ns.records.MoveToFront(e)
+	return e.Value.(record).value, true
+}
+
+// Put inserts a new record into the namespace.
+// Evicts records of the namespaces exceeding their quotas if the cache is full.
+// Values larger than the whole cache capacity are not cached.
+func (v *View) Put(key string, value interface{}) {
+	v.cache.m.Lock()
+	defer v.cache.m.Unlock()
+
+	v.cache.put(v.ns, key, value)
+}
+
+// Delete removes the record associated with the specified key from the namespace
+func (v *View) Delete(key string) {
+	v.cache.m.Lock()
+	defer v.cache.m.Unlock()
+
+	if e, ok := v.ns.cache[key]; ok {
+		v.cache.remove(v.ns, e)
+	}
+}
+
+// Clear removes all records of the namespace
+func (v *View) Clear() {
+	v.cache.m.Lock()
+	defer v.cache.m.Unlock()
+
+	v.cache.clear(v.ns)
+}
+
+// Len returns the number of records in the namespace
+func (v *View) Len() int {
+	v.cache.m.Lock()
+	defer v.cache.m.Unlock()
+
+	return len(v.ns.cache)
+}
+
+// Stats returns statistics of the namespace
+func (v *View) Stats() Stats {
+	v.cache.m.Lock()
+	defer v.cache.m.Unlock()
+
+	return v.ns.stats()
+}
+
+type namespace struct {
+	quota int64
+	used  int64
+
+	records *list.List
+	cache   map[string]*list.Element
+
+	hits      int64
+	misses    int64
+	evictions int64
+}
+
+func (ns *namespace) stats() Stats {
+	return Stats{
+		Quota:     ns.quota,
+		Used:      ns.used,
+		Len:       len(ns.cache),
+		Hits:      ns.hits,
+		Misses:    ns.misses,
+		Evictions: ns.evictions,
+	}
+}
+
+type record struct {
+	key   string
+	value interface{}
+	size  int64
+}
@@ -0,0 +1,166 @@
+package namespace_test
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/faroyam/caches/namespace"
+)
+
+func TestCache_New(t *testing.T) {
+	_, err := namespace.New(0)
+	if err == nil {
+		t.Errorf("expected error")
+	}
+
+	cache, _ := namespace.New(1)
+	if _, err = cache.Namespace("tenant", 0); err == nil {
+		t.Errorf("expected error")
+	}
+}
+
+func TestView_Get(t *testing.T) {
+	cache, _ := namespace.New(2)
+	a, _ := cache.Namespace("a", 1)
+	b, _ := cache.Namespace("b", 1)
+
+	a.Put("key", "a")
+	b.Put("key", "b")
+
+	if value, ok := a.Get("key"); !ok || value != "a" {
+		t.Errorf("cached value %v, want %v", value, "a")
+	}
+
+	if value, ok := b.Get("key"); !ok || value != "b" {
+		t.Errorf("cached value %v, want %v", value, "b")
+	}
+
+	if value, ok := a.Get("non-existing-key"); ok {
+		t.Errorf("cached value %v, want %v", value, "nil")
+	}
+
+	stats := a.Stats()
+	if stats.Hits != 1 || stats.Misses != 1 {
+		t.Errorf("hits %v, misses %v, want %v, %v", stats.Hits, stats.Misses, 1, 1)
+	}
+}
+
+func TestView_Put_Borrow(t *testing.T) {
+	cache, _ := namespace.New(4)
+	noisy, _ := cache.Namespace("noisy", 2)
+	quiet, _ := cache.Namespace("quiet", 2)
+
+	for i := 0; i < 4; i++ {
+		noisy.Put(strconv.Itoa(i), i)
+	}
+
+	// noisy borrowed the whole quota of quiet
+
+	if stats := noisy.Stats(); stats.Len != 4 || stats.Borrowed() != 2 {
+		t.Errorf("len %v, borrowed %v, want %v, %v", stats.Len, stats.Borrowed(), 4, 2)
+	}
+
+	quiet.Put("1", 1)
+	quiet.Put("2", 2)
+	quiet.Put("3", 3)
+
+	// quiet reclaimed its quota and then evicts its own records
+
+	if quiet.Len() != 2 || noisy.Len() != 2 {
+		t.Errorf("len %v, %v, want %v, %v", quiet.Len(), noisy.Len(), 2, 2)
+	}
+
+	if value, ok := quiet.Get("1"); ok {
+		t.Errorf("cached value %v, want %v", value, "nil")
+	}
+
+	if value, ok := noisy.Get("0"); ok {
+		t.Errorf("cached value %v, want %v", value, "nil")
+	}
+
+	for i := 4; i < 10; i++ {
+		noisy.Put(strconv.Itoa(i), i)
+	}
+
+	// noisy can't evict records of quiet within its quota
+
+	if quiet.Len() != 2 || noisy.Len() != 2 {
+		t.Errorf("len %v, %v, want %v, %v", quiet.Len(), noisy.Len(), 2, 2)
+	}
+
+	if stats := noisy.Stats(); stats.Evictions != 8 {
+		t.Errorf("evictions %v, want %v", stats.Evictions, 8)
+	}
+}
+
+func TestView_Put_Sizer(t *testing.T) {
+	cache, _ := namespace.New(10, namespace.WithSizer(func(value interface{}) int64 {
+		return int64(len(value.(string)))
+	}))
+	a, _ := cache.Namespace("a", 5)
+	b, _ := cache.Namespace("b", 5)
+
+	a.Put("1", "12345678")
+	b.Put("1", "123")
+
+	if a.Len() != 0 || cache.Used() != 3 {
+		t.Errorf("len %v, used %v, want %v, %v", a.Len(), cache.Used(), 0, 3)
+	}
+
+	a.Put("2", "12345678901")
+
+	if a.Len() != 0 || b.Len() != 1 {
+		t.Errorf("len %v, %v, want %v, %v", a.Len(), b.Len(), 0, 1)
+	}
+
+	a.Put("3", "1234")
+	a.Put("3", "12")
+
+	if stats := a.Stats(); stats.Used != 2 {
+		t.Errorf("used %v, want %v", stats.Used, 2)
+	}
+}
+
+func TestCache_ClearNamespace(t *testing.T) {
+	cache, _ := namespace.New(4)
+	a, _ := cache.Namespace("a", 2)
+	b, _ := cache.Namespace("b", 2)
+
+	a.Put("1", 1)
+	a.Put("2", 2)
+	b.Put("1", 1)
+
+	cache.ClearNamespace("a")
+	cache.ClearNamespace("non-existing-namespace")
+
+	if a.Len() != 0 || b.Len() != 1 || cache.Len() != 1 || cache.Used() != 1 {
+		t.Errorf("len %v, %v, %v, used %v, want %v, %v, %v, %v", a.Len(), b.Len(), cache.Len(), cache.Used(), 0, 1, 1, 1)
+	}
+
+	b.Clear()
+
+	if cache.Len() != 0 {
+		t.Errorf("cache len %v, want %v", cache.Len(), 0)
+	}
+}
+
+func TestCache_Stats(t *testing.T) {
+	cache, _ := namespace.New(4)
+	if _, ok := cache.Stats("a"); ok {
+		t.Errorf("stats %v, want %v", ok, false)
+	}
+
+	a, _ := cache.Namespace("a", 2)
+	a.Put("1", 1)
+	a.Delete("1")
+	a.Delete("non-existing-key")
+
+	if _, err := cache.Namespace("a", 3); err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+
+	stats, ok := cache.Stats("a")
+	if !ok || stats.Quota != 3 || stats.Used != 0 {
+		t.Errorf("quota %v, used %v, want %v, %v", stats.Quota, stats.Used, 3, 0)
+	}
+}
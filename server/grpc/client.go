@@ -0,0 +1,139 @@
+package grpc
+
+import (
+	"context"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/types/known/durationpb"
+
+	"github.com/faroyam/caches/server"
+	"github.com/faroyam/caches/server/grpc/cachepb"
+)
+
+// Client represents a typed client of CacheService
+type Client struct {
+	c cachepb.CacheServiceClient
+}
+
+// Entry represents a record put by BatchPut
+type Entry struct {
+	Key   string
+	Value []byte
+	// TTL of the record, the default TTL of the cache is used if zero
+	TTL time.Duration
+}
+
+// Invalidation represents a change of a key received from Watch
+type Invalidation struct {
+	Key string
+	// Deleted reports whether the key was deleted rather than updated
+	Deleted bool
+}
+
+// NewClient returns a client using the connection
+func NewClient(conn grpc.ClientConnInterface) *Client {
+	return &Client{c: cachepb.NewCacheServiceClient(conn)}
+}
+
+// Get returns the value of the key and reports whether it was found
+func (c *Client) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	resp, err := c.c.Get(ctx, &cachepb.GetRequest{Key: key})
+	if err != nil {
+		return nil, false, err
+	}
+	return resp.Value, resp.Found, nil
+}
+
+// Put saves the value of the key, the default TTL of the cache is used if ttl is zero
+func (c *Client) Put(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	_, err := c.c.Put(ctx, &cachepb.PutRequest{Entry: entry(Entry{Key: key, Value: value, TTL: ttl})})
+	return err
+}
+
+// Delete removes the key and reports whether it existed
+func (c *Client) Delete(ctx context.Context, key string) (bool, error) {
+	resp, err := c.c.Delete(ctx, &cachepb.DeleteRequest{Key: key})
+	if err != nil {
+		return false, err
+	}
+	return resp.Deleted, nil
+}
+
+// BatchGet returns the values of the keys found in the cache
+func (c *Client) BatchGet(ctx context.Context, keys ...string) (map[string][]byte, error) {
+	resp, err := c.c.BatchGet(ctx, &cachepb.BatchGetRequest{Keys: keys})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Values, nil
+}
+
+// BatchPut saves the entries, none of them is saved if any is invalid
+func (c *Client) BatchPut(ctx context.Context, entries ...Entry) error {
+	req := &cachepb.BatchPutRequest{Entries: make([]*cachepb.Entry, len(entries))}
+	for i, e := range entries {
+		req.Entries[i] = entry(e)
+	}
+
+	_, err := c.c.BatchPut(ctx, req)
+	return err
+}
+
+// Stats returns statistics of the cache
+func (c *Client) Stats(ctx context.Context) (server.Stats, error) {
+	resp, err := c.c.Stats(ctx, &cachepb.StatsRequest{})
+	if err != nil {
+		return server.Stats{}, err
+	}
+
+	return server.Stats{
+		Len:    int(resp.Len),
+		Cap:    int(resp.Cap),
+		Hits:   resp.Hits,
+		Misses: resp.Misses,
+	}, nil
+}
+
+// Watch starts streaming invalidations of keys starting with the prefix until the context is done.
+// Returns once the server is ready to stream invalidations of subsequent writes.
+func (c *Client) Watch(ctx context.Context, prefix string) (*Watcher, error) {
+	stream, err := c.c.Watch(ctx, &cachepb.WatchRequest{Prefix: prefix})
+	if err != nil {
+		return nil, err
+	}
+
+	// the server sends headers after registering the watcher
+	if _, err := stream.Header(); err != nil {
+		return nil, err
+	}
+	return &Watcher{stream: stream}, nil
+}
+
+// Watcher represents a stream of invalidations
+type Watcher struct {
+	stream cachepb.CacheService_WatchClient
+}
+
+// Recv blocks until the next invalidation is received.
+// Returns an error with codes.ResourceExhausted if the watcher fell behind the server,
+// in which case any key may have been missed.
+func (w *Watcher) Recv() (Invalidation, error) {
+	invalidation, err := w.stream.Recv()
+	if err != nil {
+		return Invalidation{}, err
+	}
+
+	return Invalidation{
+		Key:     invalidation.Key,
+		Deleted: invalidation.Kind == cachepb.Invalidation_KIND_DELETED,
+	}, nil
+}
+
+func entry(e Entry) *cachepb.Entry {
+	pb := &cachepb.Entry{Key: e.Key, Value: e.Value}
+	if e.TTL != 0 {
+		pb.Ttl = durationpb.New(e.TTL)
+	}
+	return pb
+}
@@ -0,0 +1,322 @@
+// Package grpc serves a cache over gRPC, see cachepb/cache.proto for the CacheService definition.
+//
+// Watch streams invalidations caused by writes through the server,
+// records evicted or expired by the cache itself are not reported.
+// Watchers too slow to keep up with invalidations are disconnected
+// with codes.ResourceExhausted, so they never miss an invalidation silently.
+package grpc
+
+//go:generate protoc -I cachepb --go_out=cachepb --go_opt=paths=source_relative --go-grpc_out=cachepb --go-grpc_opt=paths=source_relative cache.proto
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/faroyam/caches/server"
+	"github.com/faroyam/caches/server/grpc/cachepb"
+)
+
+// Server represents a gRPC server of a cache implementing cachepb.CacheServiceServer
+type Server struct {
+	cachepb.UnimplementedCacheServiceServer
+
+	cache   server.Cache
+	counter *server.Counter
+	grpc    *grpc.Server
+
+	m        *sync.Mutex
+	watchers map[*watcher]struct{}
+	done     chan struct{}
+	closing  bool
+
+	maxKeySize    int
+	watchBuffer   int
+	serverOptions []grpc.ServerOption
+}
+
+// Option configures a server instance
+type Option func(*Server)
+
+// WithMaxKeySize limits the size of keys in bytes. 250 bytes by default.
+func WithMaxKeySize(size int) Option {
+	return func(s *Server) {
+		s.maxKeySize = size
+	}
+}
+
+// WithWatchBuffer sets the number of invalidations buffered for each watcher. 1024 by default.
+func WithWatchBuffer(size int) Option {
+	return func(s *Server) {
+		s.watchBuffer = size
+	}
+}
+
+// WithServerOptions configures the grpc.Server used by Serve
+func WithServerOptions(options ...grpc.ServerOption) Option {
+	return func(s *Server) {
+		s.serverOptions = append(s.serverOptions, options...)
+	}
+}
+
+// New returns an initialized server instance
+func New(cache server.Cache, options ...Option) (*Server, error) {
+	s := &Server{
+		cache:       cache,
+		counter:     &server.Counter{},
+		m:           &sync.Mutex{},
+		watchers:    make(map[*watcher]struct{}),
+		done:        make(chan struct{}),
+		maxKeySize:  250,
+		watchBuffer: 1024,
+	}
+
+	for _, option := range options {
+		option(s)
+	}
+
+	if s.maxKeySize <= 0 {
+		return nil, fmt.Errorf("max key size can't be negative")
+	}
+	if s.watchBuffer <= 0 {
+		return nil, fmt.Errorf("watch buffer size can't be negative")
+	}
+
+	s.grpc = grpc.NewServer(s.serverOptions...)
+	cachepb.RegisterCacheServiceServer(s.grpc, s)
+	return s, nil
+}
+
+// Serve accepts connections on the listener until Shutdown is called
+func (s *Server) Serve(l net.Listener) error {
+	if err := s.grpc.Serve(l); err != grpc.ErrServerStopped {
+		return err
+	}
+	return nil
+}
+
+// ListenAndServe listens on the TCP address and accepts connections until Shutdown is called
+func (s *Server) ListenAndServe(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(l)
+}
+
+// Shutdown ends watch streams, stops accepting connections and waits for active RPCs to complete
+// until the context is done
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.m.Lock()
+	if !s.closing {
+		s.closing = true
+		close(s.done)
+	}
+	s.m.Unlock()
+
+	stopped := make(chan struct{})
+	go func() {
+		s.grpc.GracefulStop()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+		return nil
+	case <-ctx.Done():
+		s.grpc.Stop()
+		return ctx.Err()
+	}
+}
+
+// Get implements cachepb.CacheServiceServer
+func (s *Server) Get(_ context.Context, req *cachepb.GetRequest) (*cachepb.GetResponse, error) {
+	if err := s.validate(req.Key); err != nil {
+		return nil, err
+	}
+
+	value, ok := s.cache.Get(req.Key)
+	s.counter.Count(ok)
+	return &cachepb.GetResponse{Value: value, Found: ok}, nil
+}
+
+// Put implements cachepb.CacheServiceServer
+func (s *Server) Put(_ context.Context, req *cachepb.PutRequest) (*cachepb.PutResponse, error) {
+	if req.Entry == nil {
+		return nil, status.Error(codes.InvalidArgument, "entry is required")
+	}
+	if err := s.validateEntry(req.Entry); err != nil {
+		return nil, err
+	}
+
+	s.put(req.Entry)
+	return &cachepb.PutResponse{}, nil
+}
+
+// Delete implements cachepb.CacheServiceServer
+func (s *Server) Delete(_ context.Context, req *cachepb.DeleteRequest) (*cachepb.DeleteResponse, error) {
+	if err := s.validate(req.Key); err != nil {
+		return nil, err
+	}
+
+	deleted := s.cache.Delete(req.Key)
+	if deleted {
+		s.publish(req.Key, cachepb.Invalidation_KIND_DELETED)
+	}
+	return &cachepb.DeleteResponse{Deleted: deleted}, nil
+}
+
+// BatchGet implements cachepb.CacheServiceServer
+func (s *Server) BatchGet(_ context.Context, req *cachepb.BatchGetRequest) (*cachepb.BatchGetResponse, error) {
+	for _, key := range req.Keys {
+		if err := s.validate(key); err != nil {
+			return nil, err
+		}
+	}
+
+	values := make(map[string][]byte, len(req.Keys))
+	for _, key := range req.Keys {
+		value, ok := s.cache.Get(key)
+		s.counter.Count(ok)
+		if ok {
+			values[key] = value
+		}
+	}
+	return &cachepb.BatchGetResponse{Values: values}, nil
+}
+
+// BatchPut implements cachepb.CacheServiceServer.
+// Entries are validated before saving any of them.
+func (s *Server) BatchPut(_ context.Context, req *cachepb.BatchPutRequest) (*cachepb.BatchPutResponse, error) {
+	for _, entry := range req.Entries {
+		if err := s.validateEntry(entry); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, entry := range req.Entries {
+		s.put(entry)
+	}
+	return &cachepb.BatchPutResponse{}, nil
+}
+
+// Stats implements cachepb.CacheServiceServer
+func (s *Server) Stats(context.Context, *cachepb.StatsRequest) (*cachepb.StatsResponse, error) {
+	stats := s.counter.Stats(s.cache)
+	return &cachepb.StatsResponse{
+		Len:    int64(stats.Len),
+		Cap:    int64(stats.Cap),
+		Hits:   stats.Hits,
+		Misses: stats.Misses,
+	}, nil
+}
+
+// Watch implements cachepb.CacheServiceServer.
+// Headers are sent once the watcher is registered,
+// so invalidations of writes made after receiving them are streamed.
+func (s *Server) Watch(req *cachepb.WatchRequest, stream cachepb.CacheService_WatchServer) error {
+	w := &watcher{
+		prefix:        req.Prefix,
+		invalidations: make(chan *cachepb.Invalidation, s.watchBuffer),
+		overflow:      make(chan struct{}),
+	}
+
+	s.m.Lock()
+	if s.closing {
+		s.m.Unlock()
+		return status.Error(codes.Unavailable, "server is shutting down")
+	}
+	s.watchers[w] = struct{}{}
+	s.m.Unlock()
+
+	defer func() {
+		s.m.Lock()
+		delete(s.watchers, w)
+		s.m.Unlock()
+	}()
+
+	if err := stream.SendHeader(metadata.MD{}); err != nil {
+		return err
+	}
+
+	for {
+		select {
+		case invalidation := <-w.invalidations:
+			if err := stream.Send(invalidation); err != nil {
+				return err
+			}
+		case <-w.overflow:
+			return status.Error(codes.ResourceExhausted, "watcher is too slow")
+		case <-stream.Context().Done():
+			return stream.Context().Err()
+		case <-s.done:
+			return status.Error(codes.Unavailable, "server is shutting down")
+		}
+	}
+}
+
+func (s *Server) put(entry *cachepb.Entry) {
+	s.cache.Put(entry.Key, entry.Value, entry.Ttl.AsDuration())
+	s.publish(entry.Key, cachepb.Invalidation_KIND_UPDATED)
+}
+
+// publish sends the invalidation to the watchers of the key disconnecting the overflowed ones
+func (s *Server) publish(key string, kind cachepb.Invalidation_Kind) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	invalidation := &cachepb.Invalidation{Key: key, Kind: kind}
+	for w := range s.watchers {
+		if !strings.HasPrefix(key, w.prefix) {
+			continue
+		}
+
+		select {
+		case w.invalidations <- invalidation:
+		default:
+			delete(s.watchers, w)
+			close(w.overflow)
+		}
+	}
+}
+
+func (s *Server) validate(key string) error {
+	if key == "" {
+		return status.Error(codes.InvalidArgument, "key is required")
+	}
+	if len(key) > s.maxKeySize {
+		return status.Errorf(codes.InvalidArgument, "key is longer than %d bytes", s.maxKeySize)
+	}
+	return nil
+}
+
+func (s *Server) validateEntry(entry *cachepb.Entry) error {
+	if err := s.validate(entry.Key); err != nil {
+		return err
+	}
+
+	if entry.Ttl == nil {
+		return nil
+	}
+	if err := entry.Ttl.CheckValid(); err != nil || entry.Ttl.AsDuration() <= 0 {
+		return status.Error(codes.InvalidArgument, "TTL must be positive")
+	}
+	if !s.cache.Expiring() {
+		return status.Error(codes.InvalidArgument, "cache does not support TTL")
+	}
+	return nil
+}
+
+// watcher represents a Watch stream
+type watcher struct {
+	prefix        string
+	invalidations chan *cachepb.Invalidation
+	overflow      chan struct{}
+}
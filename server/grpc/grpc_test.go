@@ -0,0 +1,228 @@
+package grpc_test
+
+import (
+	"context"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	"github.com/faroyam/caches/server"
+	cachegrpc "github.com/faroyam/caches/server/grpc"
+)
+
+func newClient(t *testing.T, policy string, options ...cachegrpc.Option) (*cachegrpc.Client, *cachegrpc.Server) {
+	cache, _ := server.New(policy, 10, time.Minute)
+	s, err := cachegrpc.New(cache, options...)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	l := bufconn.Listen(1 << 20)
+	go s.Serve(l)
+	t.Cleanup(func() { s.Shutdown(context.Background()) })
+
+	conn, err := grpc.Dial("bufconn",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return l.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	return cachegrpc.NewClient(conn), s
+}
+
+func code(err error) codes.Code {
+	return status.Code(err)
+}
+
+func TestNew(t *testing.T) {
+	cache, _ := server.New("lru", 10, 0)
+
+	if _, err := cachegrpc.New(cache, cachegrpc.WithMaxKeySize(0)); err == nil {
+		t.Errorf("expected error")
+	}
+
+	if _, err := cachegrpc.New(cache, cachegrpc.WithWatchBuffer(-1)); err == nil {
+		t.Errorf("expected error")
+	}
+}
+
+func TestServer(t *testing.T) {
+	ctx := context.Background()
+
+	for _, policy := range []string{"lru", "lfu", "expiring"} {
+		c, _ := newClient(t, policy)
+
+		if err := c.Put(ctx, "key1", []byte("value1"), 0); err != nil {
+			t.Fatalf("%s: unexpected error %v", policy, err)
+		}
+
+		if value, ok, err := c.Get(ctx, "key1"); err != nil || !ok || string(value) != "value1" {
+			t.Errorf("%s: cached value %s, %v, %v, want %v", policy, value, ok, err, "value1")
+		}
+
+		if _, ok, _ := c.Get(ctx, "non-existing-key"); ok {
+			t.Errorf("%s: non-existing key is found", policy)
+		}
+
+		if deleted, _ := c.Delete(ctx, "key1"); !deleted {
+			t.Errorf("%s: existing key is not deleted", policy)
+		}
+		if deleted, _ := c.Delete(ctx, "key1"); deleted {
+			t.Errorf("%s: non-existing key is deleted", policy)
+		}
+
+		err := c.BatchPut(ctx, cachegrpc.Entry{Key: "key2", Value: []byte("value2")}, cachegrpc.Entry{Key: "key3", Value: []byte("value3")})
+		if err != nil {
+			t.Fatalf("%s: unexpected error %v", policy, err)
+		}
+
+		values, _ := c.BatchGet(ctx, "key1", "key2", "key3")
+		if want := map[string][]byte{"key2": []byte("value2"), "key3": []byte("value3")}; !reflect.DeepEqual(values, want) {
+			t.Errorf("%s: values %q, want %q", policy, values, want)
+		}
+
+		stats, _ := c.Stats(ctx)
+		if want := (server.Stats{Len: 2, Cap: 10, Hits: 3, Misses: 2}); stats != want {
+			t.Errorf("%s: stats %+v, want %+v", policy, stats, want)
+		}
+	}
+}
+
+func TestServer_Validation(t *testing.T) {
+	ctx := context.Background()
+	c, _ := newClient(t, "lru", cachegrpc.WithMaxKeySize(3))
+
+	if _, _, err := c.Get(ctx, ""); code(err) != codes.InvalidArgument {
+		t.Errorf("code %v, want %v", code(err), codes.InvalidArgument)
+	}
+
+	if err := c.Put(ctx, "long", nil, 0); code(err) != codes.InvalidArgument {
+		t.Errorf("code %v, want %v", code(err), codes.InvalidArgument)
+	}
+
+	// caches without TTL reject it
+	if err := c.Put(ctx, "key", nil, time.Second); code(err) != codes.InvalidArgument {
+		t.Errorf("code %v, want %v", code(err), codes.InvalidArgument)
+	}
+
+	// batches are validated as a whole
+	err := c.BatchPut(ctx, cachegrpc.Entry{Key: "ok"}, cachegrpc.Entry{Key: "long"})
+	if code(err) != codes.InvalidArgument {
+		t.Errorf("code %v, want %v", code(err), codes.InvalidArgument)
+	}
+	if _, ok, _ := c.Get(ctx, "ok"); ok {
+		t.Errorf("entry of an invalid batch is saved")
+	}
+}
+
+func TestServer_TTL(t *testing.T) {
+	ctx := context.Background()
+	c, _ := newClient(t, "expiring")
+
+	c.Put(ctx, "short", []byte("value"), 50*time.Millisecond)
+	c.BatchPut(ctx, cachegrpc.Entry{Key: "default", Value: []byte("value")})
+
+	if err := c.Put(ctx, "negative", nil, -time.Second); code(err) != codes.InvalidArgument {
+		t.Errorf("code %v, want %v", code(err), codes.InvalidArgument)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+
+	values, _ := c.BatchGet(ctx, "short", "default")
+	if want := map[string][]byte{"default": []byte("value")}; !reflect.DeepEqual(values, want) {
+		t.Errorf("values %q, want %q", values, want)
+	}
+}
+
+func TestServer_Watch(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	c, _ := newClient(t, "lru")
+
+	w, err := c.Watch(ctx, "user:")
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	c.Put(ctx, "user:1", []byte("value"), 0)
+	c.Put(ctx, "order:1", []byte("value"), 0)
+	c.BatchPut(ctx, cachegrpc.Entry{Key: "user:2"}, cachegrpc.Entry{Key: "order:2"})
+	c.Delete(ctx, "user:1")
+	c.Delete(ctx, "user:3")
+
+	var invalidations []cachegrpc.Invalidation
+	for i := 0; i < 3; i++ {
+		invalidation, err := w.Recv()
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		invalidations = append(invalidations, invalidation)
+	}
+
+	want := []cachegrpc.Invalidation{{Key: "user:1"}, {Key: "user:2"}, {Key: "user:1", Deleted: true}}
+	if !reflect.DeepEqual(invalidations, want) {
+		t.Errorf("invalidations %+v, want %+v", invalidations, want)
+	}
+}
+
+func TestServer_WatchOverflow(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	c, _ := newClient(t, "lru", cachegrpc.WithWatchBuffer(1))
+
+	w, err := c.Watch(ctx, "")
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	// invalidations are published faster than the stream sends them
+	entries := make([]cachegrpc.Entry, 100)
+	for i := range entries {
+		entries[i] = cachegrpc.Entry{Key: "key"}
+	}
+	c.BatchPut(ctx, entries...)
+
+	for {
+		_, err := w.Recv()
+		if err == nil {
+			continue
+		}
+		if code(err) != codes.ResourceExhausted {
+			t.Errorf("code %v, want %v", code(err), codes.ResourceExhausted)
+		}
+		break
+	}
+}
+
+func TestServer_Shutdown(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	c, s := newClient(t, "lru")
+
+	w, err := c.Watch(ctx, "")
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	// watch streams do not block shutdown
+	if err := s.Shutdown(ctx); err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+
+	if _, err := w.Recv(); code(err) != codes.Unavailable {
+		t.Errorf("code %v, want %v", code(err), codes.Unavailable)
+	}
+}
@@ -0,0 +1,235 @@
+// Package http serves a cache over a REST API:
+//
+//	GET    /keys/{key}      returns the value of the key
+//	PUT    /keys/{key}      sets the value of the key to the request body,
+//	                        the TTL header sets the TTL of the record for expiring caches
+//	DELETE /keys/{key}      removes the key
+//	GET    /keys?prefix=p   returns a JSON array of the keys starting with p
+//	GET    /stats           returns JSON statistics of the cache
+package http
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/faroyam/caches/server"
+)
+
+// TTLHeader is the request header holding the TTL of a record,
+// either a duration like "1m30s" or a number of seconds
+const TTLHeader = "Cache-TTL"
+
+const keysPath = "/keys/"
+
+// Server represents an HTTP server of a cache
+type Server struct {
+	cache   server.Cache
+	counter *server.Counter
+	mux     *http.ServeMux
+	srv     *http.Server
+
+	maxKeySize   int
+	maxValueSize int64
+}
+
+// Option configures a server instance
+type Option func(*Server)
+
+// WithMaxKeySize limits the size of keys in bytes. 250 bytes by default.
+func WithMaxKeySize(size int) Option {
+	return func(s *Server) {
+		s.maxKeySize = size
+	}
+}
+
+// WithMaxValueSize limits the size of values in bytes. 1MB by default.
+func WithMaxValueSize(size int64) Option {
+	return func(s *Server) {
+		s.maxValueSize = size
+	}
+}
+
+// New returns an initialized server instance
+func New(cache server.Cache, options ...Option) (*Server, error) {
+	s := &Server{
+		cache:        cache,
+		counter:      &server.Counter{},
+		mux:          http.NewServeMux(),
+		maxKeySize:   250,
+		maxValueSize: 1 << 20,
+	}
+
+	for _, option := range options {
+		option(s)
+	}
+
+	if s.maxKeySize <= 0 {
+		return nil, fmt.Errorf("max key size can't be negative")
+	}
+	if s.maxValueSize <= 0 {
+		return nil, fmt.Errorf("max value size can't be negative")
+	}
+
+	s.mux.HandleFunc(keysPath, s.handleKey)
+	s.mux.HandleFunc("/keys", s.handleKeys)
+	s.mux.HandleFunc("/stats", s.handleStats)
+	s.srv = &http.Server{
+		Handler:           s.mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	return s, nil
+}
+
+// ServeHTTP implements http.Handler
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+// Serve accepts connections on the listener until Shutdown is called
+func (s *Server) Serve(l net.Listener) error {
+	if err := s.srv.Serve(l); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// ListenAndServe listens on the TCP address and accepts connections until Shutdown is called
+func (s *Server) ListenAndServe(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(l)
+}
+
+// Shutdown stops accepting connections and waits for active requests to complete
+// until the context is done
+func (s *Server) Shutdown(ctx context.Context) error {
+	return s.srv.Shutdown(ctx)
+}
+
+func (s *Server) handleKey(w http.ResponseWriter, r *http.Request) {
+	key := strings.TrimPrefix(r.URL.Path, keysPath)
+	if key == "" {
+		http.Error(w, "empty key", http.StatusBadRequest)
+		return
+	}
+	if len(key) > s.maxKeySize {
+		http.Error(w, "key is too large", http.StatusRequestURITooLong)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		value, ok := s.cache.Get(key)
+		s.counter.Count(ok)
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("Content-Length", strconv.Itoa(len(value)))
+		w.Write(value)
+	case http.MethodPut:
+		s.put(w, r, key)
+	case http.MethodDelete:
+		if !s.cache.Delete(key) {
+			http.NotFound(w, r)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.Header().Set("Allow", "GET, HEAD, PUT, DELETE")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) put(w http.ResponseWriter, r *http.Request, key string) {
+	var ttl time.Duration
+	if header := r.Header.Get(TTLHeader); header != "" {
+		if !s.cache.Expiring() {
+			http.Error(w, "cache does not support TTL", http.StatusBadRequest)
+			return
+		}
+
+		var err error
+		if ttl, err = parseTTL(header); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	if r.ContentLength > s.maxValueSize {
+		http.Error(w, "value is too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	var buf bytes.Buffer
+	n, err := buf.ReadFrom(http.MaxBytesReader(w, r.Body, s.maxValueSize))
+	if err != nil {
+		if n >= s.maxValueSize {
+			http.Error(w, "value is too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	s.cache.Put(key, buf.Bytes(), ttl)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) handleKeys(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	keys := s.cache.KeysWithPrefix(r.URL.Query().Get("prefix"))
+	if keys == nil {
+		keys = []string{}
+	}
+	writeJSON(w, keys)
+}
+
+func (s *Server) handleStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	writeJSON(w, s.counter.Stats(s.cache))
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+// parseTTL parses a duration like "1m30s" or a number of seconds
+func parseTTL(s string) (time.Duration, error) {
+	ttl, err := time.ParseDuration(s)
+	if err != nil {
+		seconds, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid TTL %q", s)
+		}
+		ttl = time.Duration(seconds) * time.Second
+	}
+
+	if ttl <= 0 {
+		return 0, fmt.Errorf("TTL must be positive")
+	}
+	return ttl, nil
+}
@@ -0,0 +1,263 @@
+package http_test
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/faroyam/caches/server"
+	cachehttp "github.com/faroyam/caches/server/http"
+)
+
+func newServer(t *testing.T, policy string, options ...cachehttp.Option) *httptest.Server {
+	cache, _ := server.New(policy, 10, time.Minute)
+	s, err := cachehttp.New(cache, options...)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	ts := httptest.NewServer(s)
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+func do(t *testing.T, method, url, body string, header http.Header) (int, string) {
+	t.Helper()
+
+	req, _ := http.NewRequest(method, url, strings.NewReader(body))
+	for key, values := range header {
+		req.Header[key] = values
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	defer resp.Body.Close()
+
+	data, _ := ioutil.ReadAll(resp.Body)
+	return resp.StatusCode, string(data)
+}
+
+func TestNew(t *testing.T) {
+	cache, _ := server.New("lru", 10, 0)
+
+	if _, err := cachehttp.New(cache, cachehttp.WithMaxKeySize(0)); err == nil {
+		t.Errorf("expected error")
+	}
+
+	if _, err := cachehttp.New(cache, cachehttp.WithMaxValueSize(-1)); err == nil {
+		t.Errorf("expected error")
+	}
+}
+
+func TestServer_Keys(t *testing.T) {
+	ts := newServer(t, "lru")
+
+	if code, _ := do(t, http.MethodPut, ts.URL+"/keys/key1", "value1", nil); code != http.StatusNoContent {
+		t.Errorf("status %v, want %v", code, http.StatusNoContent)
+	}
+
+	if code, body := do(t, http.MethodGet, ts.URL+"/keys/key1", "", nil); code != http.StatusOK || body != "value1" {
+		t.Errorf("status %v, body %q, want %v, %q", code, body, http.StatusOK, "value1")
+	}
+
+	if code, _ := do(t, http.MethodGet, ts.URL+"/keys/non-existing-key", "", nil); code != http.StatusNotFound {
+		t.Errorf("status %v, want %v", code, http.StatusNotFound)
+	}
+
+	if code, _ := do(t, http.MethodDelete, ts.URL+"/keys/key1", "", nil); code != http.StatusNoContent {
+		t.Errorf("status %v, want %v", code, http.StatusNoContent)
+	}
+
+	if code, _ := do(t, http.MethodDelete, ts.URL+"/keys/key1", "", nil); code != http.StatusNotFound {
+		t.Errorf("status %v, want %v", code, http.StatusNotFound)
+	}
+
+	if code, _ := do(t, http.MethodPost, ts.URL+"/keys/key1", "", nil); code != http.StatusMethodNotAllowed {
+		t.Errorf("status %v, want %v", code, http.StatusMethodNotAllowed)
+	}
+
+	if code, _ := do(t, http.MethodGet, ts.URL+"/keys/", "", nil); code != http.StatusBadRequest {
+		t.Errorf("status %v, want %v", code, http.StatusBadRequest)
+	}
+
+	// escaped keys
+
+	do(t, http.MethodPut, ts.URL+"/keys/a%20b%2Fc", "value", nil)
+	if code, _ := do(t, http.MethodGet, ts.URL+"/keys/a%20b%2Fc", "", nil); code != http.StatusOK {
+		t.Errorf("status %v, want %v", code, http.StatusOK)
+	}
+}
+
+func TestServer_Prefix(t *testing.T) {
+	ts := newServer(t, "lfu")
+
+	for _, key := range []string{"user:2", "user:1", "order:1"} {
+		do(t, http.MethodPut, ts.URL+"/keys/"+key, "value", nil)
+	}
+
+	_, body := do(t, http.MethodGet, ts.URL+"/keys?prefix=user:", "", nil)
+
+	var keys []string
+	json.Unmarshal([]byte(body), &keys)
+	if want := []string{"user:1", "user:2"}; !reflect.DeepEqual(keys, want) {
+		t.Errorf("keys %v, want %v", keys, want)
+	}
+
+	if _, body := do(t, http.MethodGet, ts.URL+"/keys?prefix=none", "", nil); strings.TrimSpace(body) != "[]" {
+		t.Errorf("body %q, want %q", body, "[]")
+	}
+}
+
+func TestServer_TTL(t *testing.T) {
+	ts := newServer(t, "expiring")
+
+	for ttl, want := range map[string]int{
+		"50ms": http.StatusNoContent,
+		"1":    http.StatusNoContent,
+		"-1s":  http.StatusBadRequest,
+		"soon": http.StatusBadRequest,
+	} {
+		header := http.Header{cachehttp.TTLHeader: {ttl}}
+		if code, _ := do(t, http.MethodPut, ts.URL+"/keys/"+ttl, "value", header); code != want {
+			t.Errorf("ttl %q: status %v, want %v", ttl, code, want)
+		}
+	}
+
+	time.Sleep(100 * time.Millisecond)
+
+	if code, _ := do(t, http.MethodGet, ts.URL+"/keys/50ms", "", nil); code != http.StatusNotFound {
+		t.Errorf("status %v, want %v", code, http.StatusNotFound)
+	}
+
+	if code, _ := do(t, http.MethodGet, ts.URL+"/keys/1", "", nil); code != http.StatusOK {
+		t.Errorf("status %v, want %v", code, http.StatusOK)
+	}
+
+	// caches without TTL reject it
+
+	ts = newServer(t, "lru")
+	header := http.Header{cachehttp.TTLHeader: {"1s"}}
+	if code, _ := do(t, http.MethodPut, ts.URL+"/keys/key", "value", header); code != http.StatusBadRequest {
+		t.Errorf("status %v, want %v", code, http.StatusBadRequest)
+	}
+}
+
+func TestServer_Limits(t *testing.T) {
+	ts := newServer(t, "lru", cachehttp.WithMaxKeySize(5), cachehttp.WithMaxValueSize(5))
+
+	if code, _ := do(t, http.MethodPut, ts.URL+"/keys/123456", "value", nil); code != http.StatusRequestURITooLong {
+		t.Errorf("status %v, want %v", code, http.StatusRequestURITooLong)
+	}
+
+	if code, _ := do(t, http.MethodPut, ts.URL+"/keys/key", "value!", nil); code != http.StatusRequestEntityTooLarge {
+		t.Errorf("status %v, want %v", code, http.StatusRequestEntityTooLarge)
+	}
+
+	// a chunked body of unknown length is limited while being read
+
+	req, _ := http.NewRequest(http.MethodPut, ts.URL+"/keys/key", ioutil.NopCloser(strings.NewReader("value!")))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Errorf("status %v, want %v", resp.StatusCode, http.StatusRequestEntityTooLarge)
+	}
+
+	if code, _ := do(t, http.MethodPut, ts.URL+"/keys/key", "value", nil); code != http.StatusNoContent {
+		t.Errorf("status %v, want %v", code, http.StatusNoContent)
+	}
+}
+
+func TestServer_Stats(t *testing.T) {
+	ts := newServer(t, "lru")
+
+	do(t, http.MethodPut, ts.URL+"/keys/key", "value", nil)
+	do(t, http.MethodGet, ts.URL+"/keys/key", "", nil)
+	do(t, http.MethodGet, ts.URL+"/keys/non-existing-key", "", nil)
+
+	_, body := do(t, http.MethodGet, ts.URL+"/stats", "", nil)
+
+	var stats server.Stats
+	json.Unmarshal([]byte(body), &stats)
+	if want := (server.Stats{Len: 1, Cap: 10, Hits: 1, Misses: 1}); stats != want {
+		t.Errorf("stats %+v, want %+v", stats, want)
+	}
+}
+
+func TestServer_Shutdown(t *testing.T) {
+	cache, _ := server.New("lru", 10, 0)
+	s, _ := cachehttp.New(cache)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	served := make(chan error)
+	go func() { served <- s.Serve(l) }()
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+
+	// the connection is accepted once the first request is served
+
+	io.WriteString(conn, "GET /stats HTTP/1.1\r\nHost: cache\r\n\r\n")
+	resp, err := http.ReadResponse(r, nil)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	// the second request is active until its body is written
+
+	io.WriteString(conn, "PUT /keys/key HTTP/1.1\r\nHost: cache\r\nContent-Length: 5\r\n\r\nval")
+	time.Sleep(20 * time.Millisecond)
+
+	shutdown := make(chan error)
+	go func() { shutdown <- s.Shutdown(context.Background()) }()
+
+	select {
+	case <-shutdown:
+		t.Fatalf("shutdown does not wait for the active request")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	io.WriteString(conn, "ue")
+	resp, err = http.ReadResponse(r, nil)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if resp.StatusCode != http.StatusNoContent {
+		t.Errorf("status %v, want %v", resp.StatusCode, http.StatusNoContent)
+	}
+
+	if err := <-shutdown; err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+	if err := <-served; err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+
+	if value, _ := cache.Get("key"); string(value) != "value" {
+		t.Errorf("cached value %s, want %v", value, "value")
+	}
+}
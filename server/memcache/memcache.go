@@ -0,0 +1,569 @@
+// Package memcache serves a cache over the memcached text protocol.
+// The binary protocol, deprecated by memcached, is not supported.
+//
+// Supported commands are get, gets, set, add, replace, append, prepend, cas, delete,
+// incr, decr, touch, flush_all, stats, version and quit.
+// Expiration times are mapped to TTLs of expiring caches and ignored by other caches:
+// 0 means the default TTL of the cache for storage commands and no expiration for touch,
+// values up to 30 days are relative in seconds,
+// larger values are Unix timestamps and negative values expire records immediately.
+// Reads don't extend TTLs. incr and decr reset the TTL of expiring caches to the default one.
+// add of an existing key and cas with a stale CAS unique leave the record untouched.
+package memcache
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/faroyam/caches/server"
+)
+
+const (
+	// maxRelativeExptime is the largest expiration time treated as relative by memcached
+	maxRelativeExptime = 30 * 24 * 60 * 60
+
+	// item header: flags, CAS unique
+	headerSize = 4 + 8
+
+	maxLineSize = 2048
+
+	// noExpiration is the TTL of records touched with expiration time 0, long enough to never elapse
+	noExpiration = 100 * 365 * 24 * time.Hour
+
+	version = "1.6.0-caches"
+
+	locksCount = 64
+)
+
+// Server represents a memcached protocol server of a cache
+type Server struct {
+	cache   server.Cache
+	tcp     *server.TCP
+	counter *server.Counter
+	cas     uint64
+	started time.Time
+
+	// locks serialize commands on keys sharing a lock,
+	// so that conditions of add and cas checked with Peek hold until the record is updated
+	locks []sync.Mutex
+
+	maxKeySize   int
+	maxValueSize int
+}
+
+// Option configures a server instance
+type Option func(*Server)
+
+// WithMaxKeySize limits the size of keys in bytes. 250 bytes by default.
+func WithMaxKeySize(size int) Option {
+	return func(s *Server) {
+		s.maxKeySize = size
+	}
+}
+
+// WithMaxValueSize limits the size of values in bytes. 1MB by default.
+func WithMaxValueSize(size int) Option {
+	return func(s *Server) {
+		s.maxValueSize = size
+	}
+}
+
+// New returns an initialized server instance.
+// Values of the cache are prefixed with memcached flags and CAS uniques,
+// so the cache must not be shared with other front-ends.
+func New(cache server.Cache, options ...Option) (*Server, error) {
+	s := &Server{
+		cache:        cache,
+		tcp:          server.NewTCP(),
+		counter:      &server.Counter{},
+		started:      time.Now(),
+		locks:        make([]sync.Mutex, locksCount),
+		maxKeySize:   250,
+		maxValueSize: 1 << 20,
+	}
+
+	for _, option := range options {
+		option(s)
+	}
+
+	if s.maxKeySize <= 0 {
+		return nil, fmt.Errorf("max key size can't be negative")
+	}
+	if s.maxValueSize <= 0 {
+		return nil, fmt.Errorf("max value size can't be negative")
+	}
+	return s, nil
+}
+
+// Serve accepts connections on the listener until Shutdown is called
+func (s *Server) Serve(l net.Listener) error {
+	return s.tcp.Serve(l, s.handle)
+}
+
+// ListenAndServe listens on the TCP address and accepts connections until Shutdown is called
+func (s *Server) ListenAndServe(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(l)
+}
+
+// Shutdown stops accepting connections and waits for active commands to complete
+// until the context is done
+func (s *Server) Shutdown(ctx context.Context) error {
+	return s.tcp.Shutdown(ctx)
+}
+
+func (s *Server) handle(c *server.Conn) {
+	for {
+		line, err := c.ReadLine(maxLineSize)
+		if err != nil {
+			if errors.Is(err, server.ErrLineTooLong) {
+				fmt.Fprintf(c.W, "CLIENT_ERROR %v\r\n", err)
+				c.W.Flush()
+			}
+			return
+		}
+
+		if !c.Begin() {
+			return
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			c.W.WriteString("ERROR\r\n")
+		} else if fields[0] == "quit" {
+			c.End()
+			return
+		} else if err := s.execute(c, fields[0], fields[1:]); err != nil {
+			// the rest of the stream can't be parsed
+			fmt.Fprintf(c.W, "CLIENT_ERROR %v\r\n", err)
+			c.End()
+			return
+		}
+
+		if !c.End() {
+			return
+		}
+	}
+}
+
+// execute executes the command writing its response.
+// Returns an error if the connection must be closed.
+func (s *Server) execute(c *server.Conn, command string, args []string) error {
+	switch command {
+	case "get", "gets":
+		s.get(c, args, command == "gets")
+	case "set", "add", "replace", "append", "prepend", "cas":
+		return s.store(c, command, args)
+	case "delete":
+		s.delete(c, args)
+	case "incr", "decr":
+		s.incr(c, args, command == "decr")
+	case "touch":
+		s.touch(c, args)
+	case "flush_all":
+		s.flush(c, args)
+	case "stats":
+		s.stats(c, args)
+	case "version":
+		c.W.WriteString("VERSION " + version + "\r\n")
+	default:
+		c.W.WriteString("ERROR\r\n")
+	}
+	return nil
+}
+
+func (s *Server) get(c *server.Conn, keys []string, withCAS bool) {
+	if len(keys) == 0 {
+		c.W.WriteString("ERROR\r\n")
+		return
+	}
+
+	for _, key := range keys {
+		data, ok := s.read(key)
+		s.counter.Count(ok)
+		if !ok {
+			continue
+		}
+
+		it := decode(data)
+		if withCAS {
+			fmt.Fprintf(c.W, "VALUE %s %d %d %d\r\n", key, it.flags, len(it.value), it.cas)
+		} else {
+			fmt.Fprintf(c.W, "VALUE %s %d %d\r\n", key, it.flags, len(it.value))
+		}
+		c.W.Write(it.value)
+		c.W.WriteString("\r\n")
+	}
+	c.W.WriteString("END\r\n")
+}
+
+// read returns the record of the key, expiring caches are peeked so that reads don't extend TTLs
+func (s *Server) read(key string) ([]byte, bool) {
+	if s.cache.Expiring() {
+		return s.cache.Peek(key)
+	}
+	return s.cache.Get(key)
+}
+
+// store handles set, add, replace, append, prepend and cas:
+// <command> <key> <flags> <exptime> <bytes> [<cas unique>] [noreply]
+func (s *Server) store(c *server.Conn, command string, args []string) error {
+	n := 4
+	if command == "cas" {
+		n = 5
+	}
+	if len(args) < n || len(args) > n+1 {
+		// the data block is skipped if its size is known, otherwise the stream can't be parsed
+		size := -1
+		if len(args) > 3 {
+			size, _ = strconv.Atoi(args[3])
+		}
+		if size < 0 {
+			return errors.New("bad command line format")
+		}
+		if _, err := c.R.Discard(size + 2); err != nil {
+			return err
+		}
+		c.W.WriteString("ERROR\r\n")
+		return nil
+	}
+	noreply := len(args) == n+1 && args[n] == "noreply"
+
+	key := args[0]
+	flags, err1 := strconv.ParseUint(args[1], 10, 32)
+	exptime, err2 := strconv.ParseInt(args[2], 10, 64)
+	size, err3 := strconv.Atoi(args[3])
+	var unique uint64
+	var err4 error
+	if command == "cas" {
+		unique, err4 = strconv.ParseUint(args[4], 10, 64)
+	}
+	if err1 != nil || err2 != nil || err3 != nil || err4 != nil || size < 0 {
+		return errors.New("bad command line format")
+	}
+
+	// the data block is read even if the command is rejected to keep the stream in sync
+	value, err := s.readData(c, size)
+	if err != nil {
+		return err
+	}
+
+	var reply string
+	switch {
+	case len(key) > s.maxKeySize:
+		reply = "CLIENT_ERROR key is too long"
+	case size > s.maxValueSize:
+		reply = "SERVER_ERROR object too large for cache"
+	default:
+		reply = s.update(command, key, uint32(flags), exptime, unique, value)
+	}
+
+	if !noreply {
+		c.W.WriteString(reply + "\r\n")
+	}
+	return nil
+}
+
+func (s *Server) update(command, key string, flags uint32, exptime int64, unique uint64, value []byte) string {
+	lock := s.lock(key)
+	lock.Lock()
+	defer lock.Unlock()
+
+	// Compute rewrites the record even if it is kept as is, so failed conditions are checked before
+	if reply := s.check(command, key, unique); reply != "" {
+		return reply
+	}
+
+	ttl, expired := ttl(exptime)
+
+	reply := "STORED"
+	s.cache.Compute(key, ttl, func(old []byte, exists bool) ([]byte, bool) {
+		var it item
+		if exists {
+			it = decode(old)
+		}
+
+		switch {
+		case command == "add" && exists:
+			reply = "NOT_STORED"
+		case (command == "replace" || command == "append" || command == "prepend") && !exists:
+			reply = "NOT_STORED"
+		case command == "cas" && !exists:
+			reply = "NOT_FOUND"
+		case command == "cas" && it.cas != unique:
+			reply = "EXISTS"
+		}
+		if reply != "STORED" {
+			return old, exists
+		}
+
+		switch command {
+		case "append":
+			value = append(append([]byte(nil), it.value...), value...)
+			flags = it.flags
+		case "prepend":
+			value = append(append([]byte(nil), value...), it.value...)
+			flags = it.flags
+		}
+		// memcached accepts already expired items removing the stored ones
+		return s.encode(flags, value), !expired
+	})
+	return reply
+}
+
+// check returns the reply of add or cas failing its condition without touching the record,
+// or "" if the command may proceed
+func (s *Server) check(command, key string, unique uint64) string {
+	if command != "add" && command != "cas" {
+		return ""
+	}
+
+	data, exists := s.cache.Peek(key)
+	switch {
+	case command == "add" && exists:
+		return "NOT_STORED"
+	case command == "cas" && !exists:
+		return "NOT_FOUND"
+	case command == "cas" && decode(data).cas != unique:
+		return "EXISTS"
+	}
+	return ""
+}
+
+// delete handles delete <key> [noreply]
+func (s *Server) delete(c *server.Conn, args []string) {
+	if len(args) == 0 || len(args) > 2 {
+		c.W.WriteString("ERROR\r\n")
+		return
+	}
+
+	lock := s.lock(args[0])
+	lock.Lock()
+	defer lock.Unlock()
+
+	reply := "NOT_FOUND"
+	if s.cache.Delete(args[0]) {
+		reply = "DELETED"
+	}
+	if !noreply(args, 1) {
+		c.W.WriteString(reply + "\r\n")
+	}
+}
+
+// incr handles incr and decr <key> <value> [noreply]
+func (s *Server) incr(c *server.Conn, args []string, decr bool) {
+	if len(args) < 2 || len(args) > 3 {
+		c.W.WriteString("ERROR\r\n")
+		return
+	}
+
+	delta, err := strconv.ParseUint(args[1], 10, 64)
+	if err != nil {
+		c.W.WriteString("CLIENT_ERROR invalid numeric delta argument\r\n")
+		return
+	}
+
+	lock := s.lock(args[0])
+	lock.Lock()
+	defer lock.Unlock()
+
+	reply := "NOT_FOUND"
+	s.cache.Compute(args[0], 0, func(old []byte, exists bool) ([]byte, bool) {
+		if !exists {
+			return nil, false
+		}
+
+		it := decode(old)
+		n, err := strconv.ParseUint(string(it.value), 10, 64)
+		if err != nil {
+			reply = "CLIENT_ERROR cannot increment or decrement non-numeric value"
+			return old, true
+		}
+
+		switch {
+		case !decr:
+			n += delta
+		case delta > n:
+			n = 0
+		default:
+			n -= delta
+		}
+
+		reply = strconv.FormatUint(n, 10)
+		return s.encode(it.flags, []byte(reply)), true
+	})
+
+	if !noreply(args, 2) {
+		c.W.WriteString(reply + "\r\n")
+	}
+}
+
+// touch handles touch <key> <exptime> [noreply]
+func (s *Server) touch(c *server.Conn, args []string) {
+	if len(args) < 2 || len(args) > 3 {
+		c.W.WriteString("ERROR\r\n")
+		return
+	}
+
+	exptime, err := strconv.ParseInt(args[1], 10, 64)
+	if err != nil {
+		c.W.WriteString("CLIENT_ERROR invalid exptime argument\r\n")
+		return
+	}
+
+	lock := s.lock(args[0])
+	lock.Lock()
+	defer lock.Unlock()
+
+	reply := "NOT_FOUND"
+	ttl, expired := ttl(exptime)
+	if exptime == 0 {
+		ttl = noExpiration
+	}
+	s.cache.Compute(args[0], ttl, func(old []byte, exists bool) ([]byte, bool) {
+		if exists {
+			reply = "TOUCHED"
+		}
+		return old, exists && !expired
+	})
+
+	if !noreply(args, 2) {
+		c.W.WriteString(reply + "\r\n")
+	}
+}
+
+// flush handles flush_all [delay] [noreply]
+func (s *Server) flush(c *server.Conn, args []string) {
+	delay := 0
+	if len(args) > 0 && args[0] != "noreply" {
+		var err error
+		if delay, err = strconv.Atoi(args[0]); err != nil || delay < 0 {
+			c.W.WriteString("CLIENT_ERROR invalid delay argument\r\n")
+			return
+		}
+	}
+
+	if delay == 0 {
+		s.cache.Clear()
+	} else {
+		time.AfterFunc(time.Duration(delay)*time.Second, s.cache.Clear)
+	}
+
+	if len(args) == 0 || args[len(args)-1] != "noreply" {
+		c.W.WriteString("OK\r\n")
+	}
+}
+
+func (s *Server) stats(c *server.Conn, args []string) {
+	if len(args) > 0 {
+		// stats groups like "stats items" are not supported
+		c.W.WriteString("END\r\n")
+		return
+	}
+
+	stats := s.counter.Stats(s.cache)
+	for _, stat := range []struct {
+		name  string
+		value interface{}
+	}{
+		{"pid", os.Getpid()},
+		{"uptime", int64(time.Since(s.started).Seconds())},
+		{"time", time.Now().Unix()},
+		{"version", version},
+		{"curr_items", stats.Len},
+		{"limit_items", stats.Cap},
+		{"cmd_get", stats.Hits + stats.Misses},
+		{"get_hits", stats.Hits},
+		{"get_misses", stats.Misses},
+	} {
+		fmt.Fprintf(c.W, "STAT %s %v\r\n", stat.name, stat.value)
+	}
+	c.W.WriteString("END\r\n")
+}
+
+// lock returns the lock serializing commands on the key
+func (s *Server) lock(key string) *sync.Mutex {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return &s.locks[h.Sum32()%locksCount]
+}
+
+// readData reads a data block of the size followed by \r\n
+func (s *Server) readData(c *server.Conn, size int) ([]byte, error) {
+	if size > s.maxValueSize {
+		// discard the block without buffering it
+		if _, err := c.R.Discard(size + 2); err != nil {
+			return nil, err
+		}
+		return nil, nil
+	}
+
+	data := make([]byte, size+2)
+	if _, err := io.ReadFull(c.R, data); err != nil {
+		return nil, err
+	}
+	if !bytes.HasSuffix(data, []byte("\r\n")) {
+		return nil, errors.New("bad data chunk")
+	}
+	return data[:size], nil
+}
+
+func (s *Server) encode(flags uint32, value []byte) []byte {
+	data := make([]byte, headerSize+len(value))
+	binary.LittleEndian.PutUint32(data[0:4], flags)
+	binary.LittleEndian.PutUint64(data[4:12], atomic.AddUint64(&s.cas, 1))
+	copy(data[headerSize:], value)
+	return data
+}
+
+// item represents a value stored with its memcached metadata
+type item struct {
+	flags uint32
+	cas   uint64
+	value []byte
+}
+
+func decode(data []byte) item {
+	if len(data) < headerSize {
+		return item{value: data}
+	}
+	return item{
+		flags: binary.LittleEndian.Uint32(data[0:4]),
+		cas:   binary.LittleEndian.Uint64(data[4:12]),
+		value: data[headerSize:],
+	}
+}
+
+// ttl converts memcached expiration time to TTL and reports whether the record is already expired
+func ttl(exptime int64) (time.Duration, bool) {
+	switch {
+	case exptime == 0:
+		return 0, false
+	case exptime < 0:
+		return 0, true
+	case exptime <= maxRelativeExptime:
+		return time.Duration(exptime) * time.Second, false
+	default:
+		ttl := time.Until(time.Unix(exptime, 0))
+		return ttl, ttl <= 0
+	}
+}
+
+func noreply(args []string, i int) bool {
+	return len(args) > i && args[i] == "noreply"
+}
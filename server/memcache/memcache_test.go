@@ -0,0 +1,290 @@
+package memcache_test
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/faroyam/caches/server"
+	"github.com/faroyam/caches/server/memcache"
+)
+
+type client struct {
+	t    *testing.T
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+func newClient(t *testing.T, policy string, options ...memcache.Option) (*client, *memcache.Server) {
+	cache, _ := server.New(policy, 10, time.Second)
+	s, err := memcache.New(cache, options...)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	go s.Serve(l)
+	t.Cleanup(func() { s.Shutdown(context.Background()) })
+
+	return dial(t, l.Addr().String()), s
+}
+
+func dial(t *testing.T, addr string) *client {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	return &client{t: t, conn: conn, r: bufio.NewReader(conn)}
+}
+
+// do sends the request and reads the given number of response lines
+func (c *client) do(request string, lines int) string {
+	c.t.Helper()
+
+	c.conn.SetDeadline(time.Now().Add(time.Second))
+	if _, err := io.WriteString(c.conn, request); err != nil {
+		c.t.Fatalf("unexpected error %v", err)
+	}
+
+	var response strings.Builder
+	for i := 0; i < lines; i++ {
+		line, err := c.r.ReadString('\n')
+		if err != nil {
+			c.t.Fatalf("unexpected error %v after %q", err, response.String())
+		}
+		response.WriteString(line)
+	}
+	return response.String()
+}
+
+func (c *client) expect(request string, want ...string) {
+	c.t.Helper()
+
+	response := c.do(request, len(want))
+	if expected := strings.Join(want, "\r\n") + "\r\n"; response != expected {
+		c.t.Errorf("request %q: response %q, want %q", request, response, expected)
+	}
+}
+
+func TestNew(t *testing.T) {
+	cache, _ := server.New("lru", 10, 0)
+
+	if _, err := memcache.New(cache, memcache.WithMaxKeySize(0)); err == nil {
+		t.Errorf("expected error")
+	}
+
+	if _, err := memcache.New(cache, memcache.WithMaxValueSize(-1)); err == nil {
+		t.Errorf("expected error")
+	}
+}
+
+func TestServer_Storage(t *testing.T) {
+	for _, policy := range []string{"lru", "lfu", "expiring"} {
+		c, _ := newClient(t, policy)
+
+		c.expect("set key1 5 0 6\r\nvalue1\r\n", "STORED")
+		c.expect("get key1 non-existing-key\r\n", "VALUE key1 5 6", "value1", "END")
+
+		c.expect("add key1 0 0 1\r\nx\r\n", "NOT_STORED")
+		c.expect("add key2 0 0 6\r\nvalue2\r\n", "STORED")
+		c.expect("replace key3 0 0 1\r\nx\r\n", "NOT_STORED")
+		c.expect("replace key2 1 0 1\r\nx\r\n", "STORED")
+		c.expect("get key1 key2\r\n", "VALUE key1 5 6", "value1", "VALUE key2 1 1", "x", "END")
+
+		c.expect("append key1 0 0 1\r\n!\r\n", "STORED")
+		c.expect("prepend key1 0 0 1\r\n>\r\n", "STORED")
+		c.expect("append key3 0 0 1\r\n!\r\n", "NOT_STORED")
+		c.expect("get key1\r\n", "VALUE key1 5 8", ">value1!", "END")
+
+		c.expect("delete key1\r\n", "DELETED")
+		c.expect("delete key1\r\n", "NOT_FOUND")
+		c.expect("get key1\r\n", "END")
+
+		// noreply suppresses responses
+		c.expect("set key4 0 0 1 noreply\r\na\r\ndelete key2 noreply\r\nget key4 key2\r\n", "VALUE key4 0 1", "a", "END")
+
+		// binary values
+		c.expect("set key5 0 0 4\r\na\r\nb\r\n", "STORED")
+		c.expect("get key5\r\n", "VALUE key5 0 4", "a", "b", "END")
+
+		c.expect("version\r\n", "VERSION 1.6.0-caches")
+		c.expect("unknown\r\n", "ERROR")
+		c.expect("get\r\n", "ERROR")
+	}
+}
+
+func TestServer_CAS(t *testing.T) {
+	c, _ := newClient(t, "lru")
+
+	c.expect("cas key 0 0 1 1\r\na\r\n", "NOT_FOUND")
+	c.expect("set key 0 0 1\r\na\r\n", "STORED")
+
+	var unique uint64
+	fmt.Sscanf(c.do("gets key\r\n", 3), "VALUE key 0 1 %d", &unique)
+
+	c.expect(fmt.Sprintf("cas key 0 0 1 %d\r\nb\r\n", unique+1), "EXISTS")
+	c.expect(fmt.Sprintf("cas key 0 0 1 %d\r\nb\r\n", unique), "STORED")
+	c.expect(fmt.Sprintf("cas key 0 0 1 %d\r\nc\r\n", unique), "EXISTS")
+	c.expect("get key\r\n", "VALUE key 0 1", "b", "END")
+}
+
+func TestServer_Incr(t *testing.T) {
+	c, _ := newClient(t, "lfu")
+
+	c.expect("incr key 1\r\n", "NOT_FOUND")
+	c.expect("set key 3 0 2\r\n10\r\n", "STORED")
+	c.expect("incr key 5\r\n", "15")
+	c.expect("decr key 20\r\n", "0")
+	c.expect("incr key 18446744073709551615\r\n", "18446744073709551615")
+	c.expect("incr key 2\r\n", "1")
+	c.expect("get key\r\n", "VALUE key 3 1", "1", "END")
+
+	c.expect("incr key x\r\n", "CLIENT_ERROR invalid numeric delta argument")
+	c.expect("set text 0 0 4\r\ntext\r\n", "STORED")
+	c.expect("incr text 1\r\n", "CLIENT_ERROR cannot increment or decrement non-numeric value")
+}
+
+func TestServer_Expiration(t *testing.T) {
+	c, _ := newClient(t, "expiring")
+
+	c.expect("set relative 0 1 1\r\na\r\n", "STORED")
+	c.expect(fmt.Sprintf("set absolute 0 %d 1\r\na\r\n", time.Now().Add(time.Minute).Unix()), "STORED")
+	c.expect(fmt.Sprintf("set past 0 %d 1\r\na\r\n", time.Now().Add(-time.Minute).Unix()), "STORED")
+	c.expect("set negative 0 -1 1\r\na\r\n", "STORED")
+	c.expect("set default 0 0 1\r\na\r\n", "STORED")
+	c.expect("get past negative\r\n", "END")
+
+	c.expect("touch absolute 1\r\n", "TOUCHED")
+	c.expect("touch default -1\r\n", "TOUCHED")
+	c.expect("touch default 1\r\n", "NOT_FOUND")
+	// touch with 0 keeps the record longer than the default TTL
+	c.expect("set forever 0 0 1\r\na\r\n", "STORED")
+	c.expect("touch forever 0\r\n", "TOUCHED")
+
+	// failed add and cas keep the TTL of the record
+	c.expect("set kept 0 1 1\r\na\r\n", "STORED")
+	c.expect("add kept 0 0 1\r\nb\r\n", "NOT_STORED")
+	c.expect("cas kept 0 0 1 0\r\nb\r\n", "EXISTS")
+
+	// reads don't extend the TTL
+	time.Sleep(600 * time.Millisecond)
+	c.expect("get relative\r\n", "VALUE relative 0 1", "a", "END")
+	time.Sleep(500 * time.Millisecond)
+
+	c.expect("get relative absolute default kept\r\n", "END")
+	c.expect("get forever\r\n", "VALUE forever 0 1", "a", "END")
+}
+
+func TestServer_Flush(t *testing.T) {
+	c, _ := newClient(t, "lru")
+
+	c.expect("set key 0 0 1\r\na\r\n", "STORED")
+	c.expect("flush_all 1\r\n", "OK")
+	c.expect("get key\r\n", "VALUE key 0 1", "a", "END")
+
+	time.Sleep(1100 * time.Millisecond)
+	c.expect("get key\r\n", "END")
+
+	c.expect("set key 0 0 1\r\na\r\n", "STORED")
+	c.expect("flush_all noreply\r\nget key\r\n", "END")
+	c.expect("flush_all x\r\n", "CLIENT_ERROR invalid delay argument")
+}
+
+func TestServer_Stats(t *testing.T) {
+	c, _ := newClient(t, "lru")
+
+	c.expect("set key 0 0 1\r\na\r\n", "STORED")
+	c.do("get key non-existing-key\r\n", 3)
+
+	c.conn.SetDeadline(time.Now().Add(time.Second))
+	io.WriteString(c.conn, "stats\r\n")
+
+	stats := make(map[string]string)
+	for {
+		line, err := c.r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		if line == "END\r\n" {
+			break
+		}
+
+		var name, value string
+		fmt.Sscanf(line, "STAT %s %s", &name, &value)
+		stats[name] = value
+	}
+
+	for name, want := range map[string]string{
+		"curr_items":  "1",
+		"limit_items": "10",
+		"cmd_get":     "2",
+		"get_hits":    "1",
+		"get_misses":  "1",
+	} {
+		if stats[name] != want {
+			t.Errorf("stat %s %q, want %q", name, stats[name], want)
+		}
+	}
+}
+
+func TestServer_Limits(t *testing.T) {
+	c, _ := newClient(t, "lru", memcache.WithMaxKeySize(3), memcache.WithMaxValueSize(3))
+
+	c.expect("set long 0 0 1\r\na\r\n", "CLIENT_ERROR key is too long")
+	c.expect("set key 0 0 4\r\nabcd\r\n", "SERVER_ERROR object too large for cache")
+	c.expect("set key 0 0 3\r\nabc\r\n", "STORED")
+
+	// the data block of a command with a wrong number of arguments is skipped
+	c.expect("cas key 0 0 1\r\na\r\nget key\r\n", "ERROR", "VALUE key 0 3", "abc", "END")
+	c.expect("set key 0 0 1 2 3\r\na\r\nversion\r\n", "ERROR", "VERSION 1.6.0-caches")
+
+	// malformed commands close the connection
+	c.expect("set key 0 0 x\r\n", "CLIENT_ERROR bad command line format")
+	if _, err := c.r.ReadString('\n'); err != io.EOF {
+		t.Errorf("error %v, want %v", err, io.EOF)
+	}
+}
+
+func TestServer_Shutdown(t *testing.T) {
+	c, s := newClient(t, "lru")
+	idle := dial(t, c.conn.RemoteAddr().String())
+
+	idle.expect("version\r\n", "VERSION 1.6.0-caches")
+
+	// the command is active until its data block is written
+	io.WriteString(c.conn, "set key 0 0 5\r\nval")
+	time.Sleep(20 * time.Millisecond)
+
+	shutdown := make(chan error)
+	go func() { shutdown <- s.Shutdown(context.Background()) }()
+
+	select {
+	case <-shutdown:
+		t.Fatalf("shutdown does not wait for the active command")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// idle connections are closed at once
+	if _, err := idle.r.ReadString('\n'); err != io.EOF {
+		t.Errorf("error %v, want %v", err, io.EOF)
+	}
+
+	c.expect("ue\r\n", "STORED")
+	if err := <-shutdown; err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+
+	if _, err := c.r.ReadString('\n'); err != io.EOF {
+		t.Errorf("error %v, want %v", err, io.EOF)
+	}
+}
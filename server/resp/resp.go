@@ -0,0 +1,689 @@
+// Package resp serves caches over the Redis serialization protocol, versions 2 and 3.
+//
+// Supported commands are GET, SET with EX, PX, NX, XX and KEEPTTL, DEL, EXISTS, EXPIRE, TTL, PERSIST,
+// MGET, MSET, INCR, FLUSHDB, DBSIZE and INFO as well as HELLO, PING, ECHO, SELECT 0, CLIENT SETNAME,
+// CLIENT SETINFO, COMMAND and QUIT used by clients on connection.
+// Keys without TTL are kept in a cache evicting records by capacity,
+// while keys with TTL are kept in an expiring cache.
+// Commands are executed one at a time like in Redis.
+package resp
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/faroyam/caches/server"
+)
+
+const (
+	version = "7.0.0-caches"
+
+	maxLineSize = 64 * 1024
+	maxArgs     = 1024 * 1024
+)
+
+// Server represents a RESP server of caches
+type Server struct {
+	m          *sync.Mutex
+	persistent server.Cache
+	volatile   server.Cache
+	tcp        *server.TCP
+	counter    *server.Counter
+	commands   map[string]command
+	started    time.Time
+
+	maxValueSize int
+}
+
+// command represents a command handler with the number of arguments including the command name,
+// negative arity is the minimal number of arguments
+type command struct {
+	arity int
+	run   func(w *writer, args [][]byte)
+}
+
+// Option configures a server instance
+type Option func(*Server)
+
+// WithMaxValueSize limits the size of bulk strings of requests in bytes. 1MB by default.
+func WithMaxValueSize(size int) Option {
+	return func(s *Server) {
+		s.maxValueSize = size
+	}
+}
+
+// New returns an initialized server instance keeping keys without TTL in the persistent cache
+// and keys with TTL in the volatile one.
+// Values of the volatile cache are prefixed with expiration times,
+// so the caches must not be shared with other front-ends.
+func New(persistent, volatile server.Cache, options ...Option) (*Server, error) {
+	if persistent.Expiring() {
+		return nil, fmt.Errorf("persistent cache can't expire records")
+	}
+	if !volatile.Expiring() {
+		return nil, fmt.Errorf("volatile cache must expire records")
+	}
+
+	s := &Server{
+		m:            &sync.Mutex{},
+		persistent:   persistent,
+		volatile:     volatile,
+		tcp:          server.NewTCP(),
+		counter:      &server.Counter{},
+		started:      time.Now(),
+		maxValueSize: 1 << 20,
+	}
+
+	for _, option := range options {
+		option(s)
+	}
+
+	if s.maxValueSize <= 0 {
+		return nil, fmt.Errorf("max value size can't be negative")
+	}
+
+	s.commands = map[string]command{
+		"get":     {2, s.get},
+		"set":     {-3, s.set},
+		"del":     {-2, s.del},
+		"exists":  {-2, s.exists},
+		"expire":  {3, s.expire},
+		"ttl":     {2, s.ttl},
+		"persist": {2, s.persist},
+		"mget":    {-2, s.mget},
+		"mset":    {-3, s.mset},
+		"incr":    {2, s.incr},
+		"flushdb": {-1, s.flush},
+		"dbsize":  {1, s.dbsize},
+		"info":    {-1, s.info},
+		"hello":   {-1, s.hello},
+		"ping":    {-1, ping},
+		"echo":    {2, echo},
+		"select":  {2, selectDB},
+		"client":  {-2, client},
+		"command": {-1, commands},
+	}
+	return s, nil
+}
+
+// Serve accepts connections on the listener until Shutdown is called
+func (s *Server) Serve(l net.Listener) error {
+	return s.tcp.Serve(l, s.handle)
+}
+
+// ListenAndServe listens on the TCP address and accepts connections until Shutdown is called
+func (s *Server) ListenAndServe(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(l)
+}
+
+// Shutdown stops accepting connections and waits for active commands to complete
+// until the context is done
+func (s *Server) Shutdown(ctx context.Context) error {
+	return s.tcp.Shutdown(ctx)
+}
+
+func (s *Server) handle(c *server.Conn) {
+	w := &writer{Writer: c.W, proto: 2}
+
+	for {
+		line, err := c.ReadLine(maxLineSize)
+		if err != nil {
+			if errors.Is(err, server.ErrLineTooLong) {
+				w.error("ERR Protocol error: " + err.Error())
+				c.W.Flush()
+			}
+			return
+		}
+
+		if !c.Begin() {
+			return
+		}
+
+		args, err := s.readArgs(c, line)
+		if err != nil {
+			// the rest of the stream can't be parsed
+			w.error("ERR Protocol error: " + err.Error())
+			c.End()
+			return
+		}
+
+		if len(args) > 0 && strings.EqualFold(string(args[0]), "quit") {
+			w.simple("OK")
+			c.End()
+			return
+		}
+		if len(args) > 0 {
+			s.execute(w, args)
+		}
+
+		if !c.End() {
+			return
+		}
+	}
+}
+
+// readArgs reads the arguments of a request sent as an array of bulk strings or as an inline command
+func (s *Server) readArgs(c *server.Conn, line string) ([][]byte, error) {
+	if !strings.HasPrefix(line, "*") {
+		fields := strings.Fields(line)
+		args := make([][]byte, len(fields))
+		for i, field := range fields {
+			args[i] = []byte(field)
+		}
+		return args, nil
+	}
+
+	n, err := strconv.Atoi(line[1:])
+	if err != nil || n < 0 || n > maxArgs {
+		return nil, errors.New("invalid multibulk length")
+	}
+
+	args := make([][]byte, 0, min(n, 1024))
+	for i := 0; i < n; i++ {
+		line, err := c.ReadLine(maxLineSize)
+		if err != nil {
+			return nil, err
+		}
+		if !strings.HasPrefix(line, "$") {
+			return nil, fmt.Errorf("expected '$', got '%.1s'", line)
+		}
+
+		size, err := strconv.Atoi(line[1:])
+		if err != nil || size < 0 || size > s.maxValueSize {
+			return nil, errors.New("invalid bulk length")
+		}
+
+		arg := make([]byte, size+2)
+		if _, err := io.ReadFull(c.R, arg); err != nil {
+			return nil, err
+		}
+		if arg[size] != '\r' || arg[size+1] != '\n' {
+			return nil, errors.New("invalid bulk string ending")
+		}
+		args = append(args, arg[:size])
+	}
+	return args, nil
+}
+
+func (s *Server) execute(w *writer, args [][]byte) {
+	name := strings.ToLower(string(args[0]))
+	cmd, ok := s.commands[name]
+	if !ok {
+		w.error(fmt.Sprintf("ERR unknown command '%s'", args[0]))
+		return
+	}
+
+	if cmd.arity > 0 && len(args) != cmd.arity || cmd.arity < 0 && len(args) < -cmd.arity {
+		w.error(fmt.Sprintf("ERR wrong number of arguments for '%s' command", name))
+		return
+	}
+
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	cmd.run(w, args)
+}
+
+// GET key
+func (s *Server) get(w *writer, args [][]byte) {
+	value, _, ok := s.lookup(string(args[1]))
+	s.counter.Count(ok)
+	if !ok {
+		w.null()
+		return
+	}
+	w.bulk(value)
+}
+
+// SET key value [NX | XX] [EX seconds | PX milliseconds | KEEPTTL]
+func (s *Server) set(w *writer, args [][]byte) {
+	var expireAt time.Time
+	var nx, xx, keepTTL bool
+
+	for i := 3; i < len(args); i++ {
+		switch option := strings.ToUpper(string(args[i])); option {
+		case "NX":
+			nx = true
+		case "XX":
+			xx = true
+		case "KEEPTTL":
+			keepTTL = true
+		case "EX", "PX":
+			if i+1 == len(args) || !expireAt.IsZero() {
+				w.error("ERR syntax error")
+				return
+			}
+			i++
+
+			n, err := strconv.ParseInt(string(args[i]), 10, 64)
+			if err != nil {
+				w.error("ERR value is not an integer or out of range")
+				return
+			}
+
+			unit := time.Second
+			if option == "PX" {
+				unit = time.Millisecond
+			}
+			if n <= 0 || n > math.MaxInt64/int64(unit) {
+				w.error("ERR invalid expire time in 'set' command")
+				return
+			}
+			expireAt = time.Now().Add(time.Duration(n) * unit)
+		default:
+			w.error("ERR syntax error")
+			return
+		}
+	}
+
+	if nx && xx || keepTTL && !expireAt.IsZero() {
+		w.error("ERR syntax error")
+		return
+	}
+
+	key := string(args[1])
+	_, oldExpireAt, exists := s.lookup(key)
+	if nx && exists || xx && !exists {
+		w.null()
+		return
+	}
+	if keepTTL {
+		expireAt = oldExpireAt
+	}
+
+	s.store(key, args[2], expireAt)
+	w.simple("OK")
+}
+
+// DEL key [key ...]
+func (s *Server) del(w *writer, args [][]byte) {
+	var n int64
+	for _, key := range args[1:] {
+		if s.remove(string(key)) {
+			n++
+		}
+	}
+	w.integer(n)
+}
+
+// EXISTS key [key ...]
+func (s *Server) exists(w *writer, args [][]byte) {
+	var n int64
+	for _, key := range args[1:] {
+		if _, _, ok := s.lookup(string(key)); ok {
+			n++
+		}
+	}
+	w.integer(n)
+}
+
+// EXPIRE key seconds
+func (s *Server) expire(w *writer, args [][]byte) {
+	seconds, err := strconv.ParseInt(string(args[2]), 10, 64)
+	if err != nil {
+		w.error("ERR value is not an integer or out of range")
+		return
+	}
+	if seconds > math.MaxInt64/int64(time.Second) {
+		w.error("ERR invalid expire time in 'expire' command")
+		return
+	}
+
+	key := string(args[1])
+	value, _, ok := s.lookup(key)
+	if !ok {
+		w.integer(0)
+		return
+	}
+
+	if seconds <= 0 {
+		s.remove(key)
+	} else {
+		s.store(key, value, time.Now().Add(time.Duration(seconds)*time.Second))
+	}
+	w.integer(1)
+}
+
+// TTL key
+func (s *Server) ttl(w *writer, args [][]byte) {
+	_, expireAt, ok := s.lookup(string(args[1]))
+	switch {
+	case !ok:
+		w.integer(-2)
+	case expireAt.IsZero():
+		w.integer(-1)
+	default:
+		w.integer(int64((time.Until(expireAt) + time.Second/2) / time.Second))
+	}
+}
+
+// PERSIST key
+func (s *Server) persist(w *writer, args [][]byte) {
+	key := string(args[1])
+	value, expireAt, ok := s.lookup(key)
+	if !ok || expireAt.IsZero() {
+		w.integer(0)
+		return
+	}
+
+	s.store(key, value, time.Time{})
+	w.integer(1)
+}
+
+// MGET key [key ...]
+func (s *Server) mget(w *writer, args [][]byte) {
+	w.array(len(args) - 1)
+	for _, key := range args[1:] {
+		value, _, ok := s.lookup(string(key))
+		s.counter.Count(ok)
+		if !ok {
+			w.null()
+			continue
+		}
+		w.bulk(value)
+	}
+}
+
+// MSET key value [key value ...]
+func (s *Server) mset(w *writer, args [][]byte) {
+	if len(args)%2 == 0 {
+		w.error("ERR wrong number of arguments for 'mset' command")
+		return
+	}
+
+	for i := 1; i < len(args); i += 2 {
+		s.store(string(args[i]), args[i+1], time.Time{})
+	}
+	w.simple("OK")
+}
+
+// INCR key
+func (s *Server) incr(w *writer, args [][]byte) {
+	key := string(args[1])
+	value, expireAt, ok := s.lookup(key)
+
+	var n int64
+	if ok {
+		var err error
+		if n, err = strconv.ParseInt(string(value), 10, 64); err != nil {
+			w.error("ERR value is not an integer or out of range")
+			return
+		}
+	}
+	if n == math.MaxInt64 {
+		w.error("ERR increment or decrement would overflow")
+		return
+	}
+
+	n++
+	s.store(key, []byte(strconv.FormatInt(n, 10)), expireAt)
+	w.integer(n)
+}
+
+// FLUSHDB [ASYNC | SYNC]
+func (s *Server) flush(w *writer, args [][]byte) {
+	if len(args) > 2 || len(args) == 2 && !isOneOf(args[1], "async", "sync") {
+		w.error("ERR syntax error")
+		return
+	}
+
+	s.persistent.Clear()
+	s.volatile.Clear()
+	w.simple("OK")
+}
+
+// DBSIZE
+func (s *Server) dbsize(w *writer, _ [][]byte) {
+	w.integer(int64(s.persistent.Len() + s.volatile.Len()))
+}
+
+// INFO [section ...]
+func (s *Server) info(w *writer, args [][]byte) {
+	stats := s.counter.Stats(s.persistent)
+	sections := []struct {
+		name   string
+		fields [][2]interface{}
+	}{
+		{"Server", [][2]interface{}{
+			{"redis_version", version},
+			{"redis_mode", "standalone"},
+			{"process_id", os.Getpid()},
+			{"uptime_in_seconds", int64(time.Since(s.started).Seconds())},
+		}},
+		{"Stats", [][2]interface{}{
+			{"keyspace_hits", stats.Hits},
+			{"keyspace_misses", stats.Misses},
+		}},
+		{"Keyspace", [][2]interface{}{
+			{"db0", fmt.Sprintf("keys=%d,expires=%d,avg_ttl=0", s.persistent.Len()+s.volatile.Len(), s.volatile.Len())},
+		}},
+	}
+
+	filter := toStrings(args[1:])
+	all := len(filter) == 0 || isOneOf([]byte("all"), filter...) || isOneOf([]byte("everything"), filter...)
+
+	var b strings.Builder
+	for _, section := range sections {
+		if !all && !isOneOf([]byte(section.name), filter...) {
+			continue
+		}
+
+		if b.Len() > 0 {
+			b.WriteString("\r\n")
+		}
+		fmt.Fprintf(&b, "# %s\r\n", section.name)
+		for _, field := range section.fields {
+			fmt.Fprintf(&b, "%v:%v\r\n", field[0], field[1])
+		}
+	}
+	w.verbatim(b.String())
+}
+
+// HELLO [protover [SETNAME clientname]]
+func (s *Server) hello(w *writer, args [][]byte) {
+	if len(args) > 1 {
+		proto, err := strconv.Atoi(string(args[1]))
+		if err != nil {
+			w.error("ERR Protocol version is not an integer or out of range")
+			return
+		}
+		if proto != 2 && proto != 3 {
+			w.error("NOPROTO unsupported protocol version")
+			return
+		}
+		if len(args) > 2 && (len(args) != 4 || !isOneOf(args[2], "setname")) {
+			w.error("ERR syntax error")
+			return
+		}
+		w.proto = proto
+	}
+
+	w.dict(6)
+	w.bulk([]byte("server"))
+	w.bulk([]byte("redis"))
+	w.bulk([]byte("version"))
+	w.bulk([]byte(version))
+	w.bulk([]byte("proto"))
+	w.integer(int64(w.proto))
+	w.bulk([]byte("mode"))
+	w.bulk([]byte("standalone"))
+	w.bulk([]byte("role"))
+	w.bulk([]byte("master"))
+	w.bulk([]byte("modules"))
+	w.array(0)
+}
+
+// PING [message]
+func ping(w *writer, args [][]byte) {
+	if len(args) > 1 {
+		w.bulk(args[1])
+		return
+	}
+	w.simple("PONG")
+}
+
+// ECHO message
+func echo(w *writer, args [][]byte) {
+	w.bulk(args[1])
+}
+
+// SELECT index, only the database 0 exists
+func selectDB(w *writer, args [][]byte) {
+	if string(args[1]) != "0" {
+		w.error("ERR DB index is out of range")
+		return
+	}
+	w.simple("OK")
+}
+
+// CLIENT SETNAME name or CLIENT SETINFO attribute value, client names and attributes are ignored
+func client(w *writer, args [][]byte) {
+	if !isOneOf(args[1], "setname", "setinfo") {
+		w.error(fmt.Sprintf("ERR unknown subcommand '%s'", args[1]))
+		return
+	}
+	w.simple("OK")
+}
+
+// COMMAND [subcommand], command docs are not provided
+func commands(w *writer, _ [][]byte) {
+	w.array(0)
+}
+
+// lookup returns the value of the key and its expiration time, zero for keys without TTL.
+// Volatile keys are peeked, so that reads don't extend their TTL.
+func (s *Server) lookup(key string) ([]byte, time.Time, bool) {
+	if data, ok := s.volatile.Peek(key); ok {
+		expireAt := time.Unix(0, int64(binary.LittleEndian.Uint64(data)))
+		if !expireAt.After(time.Now()) {
+			s.volatile.Delete(key)
+			return nil, time.Time{}, false
+		}
+		return data[8:], expireAt, true
+	}
+	if value, ok := s.persistent.Get(key); ok {
+		return value, time.Time{}, true
+	}
+	return nil, time.Time{}, false
+}
+
+// store saves the value of the key in the cache matching its expiration time
+func (s *Server) store(key string, value []byte, expireAt time.Time) {
+	if expireAt.IsZero() {
+		s.volatile.Delete(key)
+		s.persistent.Put(key, value, 0)
+		return
+	}
+
+	s.persistent.Delete(key)
+
+	ttl := time.Until(expireAt)
+	if ttl <= 0 {
+		s.volatile.Delete(key)
+		return
+	}
+
+	data := make([]byte, 8+len(value))
+	binary.LittleEndian.PutUint64(data, uint64(expireAt.UnixNano()))
+	copy(data[8:], value)
+	s.volatile.Put(key, data, ttl)
+}
+
+func (s *Server) remove(key string) bool {
+	volatile := s.volatile.Delete(key)
+	persistent := s.persistent.Delete(key)
+	return volatile || persistent
+}
+
+// writer writes replies of the protocol version
+type writer struct {
+	*bufio.Writer
+	proto int
+}
+
+func (w *writer) simple(s string) {
+	w.WriteString("+" + s + "\r\n")
+}
+
+func (w *writer) error(s string) {
+	w.WriteString("-" + s + "\r\n")
+}
+
+func (w *writer) integer(n int64) {
+	w.WriteString(":" + strconv.FormatInt(n, 10) + "\r\n")
+}
+
+func (w *writer) bulk(b []byte) {
+	w.WriteString("$" + strconv.Itoa(len(b)) + "\r\n")
+	w.Write(b)
+	w.WriteString("\r\n")
+}
+
+// verbatim writes a verbatim text string of RESP3 or a bulk string of RESP2
+func (w *writer) verbatim(s string) {
+	if w.proto == 2 {
+		w.bulk([]byte(s))
+		return
+	}
+	w.WriteString("=" + strconv.Itoa(len(s)+4) + "\r\ntxt:" + s + "\r\n")
+}
+
+func (w *writer) null() {
+	if w.proto == 2 {
+		w.WriteString("$-1\r\n")
+		return
+	}
+	w.WriteString("_\r\n")
+}
+
+func (w *writer) array(n int) {
+	w.WriteString("*" + strconv.Itoa(n) + "\r\n")
+}
+
+// dict writes a map header of RESP3 or a header of a flat array of pairs of RESP2
+func (w *writer) dict(n int) {
+	if w.proto == 2 {
+		w.array(2 * n)
+		return
+	}
+	w.WriteString("%" + strconv.Itoa(n) + "\r\n")
+}
+
+func isOneOf(arg []byte, values ...string) bool {
+	for _, value := range values {
+		if strings.EqualFold(string(arg), value) {
+			return true
+		}
+	}
+	return false
+}
+
+func toStrings(args [][]byte) []string {
+	s := make([]string, len(args))
+	for i, arg := range args {
+		s[i] = string(arg)
+	}
+	return s
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
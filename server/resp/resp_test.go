@@ -0,0 +1,364 @@
+package resp_test
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/faroyam/caches/server"
+	"github.com/faroyam/caches/server/resp"
+)
+
+type client struct {
+	t    *testing.T
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+// replyError represents an error reply
+type replyError string
+
+func newClient(t *testing.T, policy string, options ...resp.Option) (*client, *resp.Server) {
+	persistent, _ := server.New(policy, 10, 0)
+	volatile, _ := server.New("expiring", 10, time.Minute)
+	s, err := resp.New(persistent, volatile, options...)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	go s.Serve(l)
+	t.Cleanup(func() { s.Shutdown(context.Background()) })
+
+	return dial(t, l.Addr().String()), s
+}
+
+func dial(t *testing.T, addr string) *client {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	return &client{t: t, conn: conn, r: bufio.NewReader(conn)}
+}
+
+// do sends the command as an array of bulk strings and reads the reply
+func (c *client) do(args ...string) interface{} {
+	c.t.Helper()
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	return c.send(b.String())
+}
+
+func (c *client) send(request string) interface{} {
+	c.t.Helper()
+
+	c.conn.SetDeadline(time.Now().Add(time.Second))
+	if _, err := io.WriteString(c.conn, request); err != nil {
+		c.t.Fatalf("unexpected error %v", err)
+	}
+
+	reply, err := c.read()
+	if err != nil {
+		c.t.Fatalf("unexpected error %v", err)
+	}
+	return reply
+}
+
+func (c *client) expect(want interface{}, args ...string) {
+	c.t.Helper()
+
+	if reply := c.do(args...); !reflect.DeepEqual(reply, want) {
+		c.t.Errorf("%v: reply %#v, want %#v", args, reply, want)
+	}
+}
+
+// read reads a reply decoding simple and bulk strings as string, integers as int64,
+// arrays as []interface{} and maps as map[string]interface{}
+func (c *client) read() (interface{}, error) {
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return replyError(line[1:]), nil
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '_':
+		return nil, nil
+	case '$', '=':
+		n, _ := strconv.Atoi(line[1:])
+		if n < 0 {
+			return nil, nil
+		}
+		data := make([]byte, n+2)
+		if _, err := io.ReadFull(c.r, data); err != nil {
+			return nil, err
+		}
+		return string(data[:n]), nil
+	case '*':
+		n, _ := strconv.Atoi(line[1:])
+		array := make([]interface{}, n)
+		for i := range array {
+			if array[i], err = c.read(); err != nil {
+				return nil, err
+			}
+		}
+		return array, nil
+	case '%':
+		n, _ := strconv.Atoi(line[1:])
+		m := make(map[string]interface{}, n)
+		for i := 0; i < n; i++ {
+			key, err := c.read()
+			if err != nil {
+				return nil, err
+			}
+			if m[key.(string)], err = c.read(); err != nil {
+				return nil, err
+			}
+		}
+		return m, nil
+	}
+	return nil, fmt.Errorf("unexpected reply %q", line)
+}
+
+func TestNew(t *testing.T) {
+	lru, _ := server.New("lru", 10, 0)
+	expiring, _ := server.New("expiring", 10, time.Minute)
+
+	if _, err := resp.New(expiring, expiring); err == nil {
+		t.Errorf("expected error")
+	}
+
+	if _, err := resp.New(lru, lru); err == nil {
+		t.Errorf("expected error")
+	}
+
+	if _, err := resp.New(lru, expiring, resp.WithMaxValueSize(0)); err == nil {
+		t.Errorf("expected error")
+	}
+}
+
+func TestServer_Strings(t *testing.T) {
+	for _, policy := range []string{"lru", "lfu"} {
+		c, _ := newClient(t, policy)
+
+		c.expect("OK", "SET", "key1", "value1")
+		c.expect("value1", "GET", "key1")
+		c.expect(nil, "GET", "non-existing-key")
+
+		c.expect(nil, "SET", "key1", "other", "NX")
+		c.expect(nil, "SET", "key2", "other", "XX")
+		c.expect("OK", "set", "key2", "value2", "nx")
+		c.expect("OK", "SET", "key1", "value1!", "XX")
+		c.expect([]interface{}{"value1!", nil, "value2"}, "MGET", "key1", "key3", "key2")
+
+		c.expect("OK", "MSET", "key3", "value3", "key4", "value4")
+		c.expect(int64(3), "EXISTS", "key1", "key3", "key3", "key5")
+		c.expect(int64(2), "DEL", "key1", "key3", "key5")
+		c.expect(int64(2), "DBSIZE")
+
+		c.expect(int64(1), "INCR", "counter")
+		c.expect(int64(2), "INCR", "counter")
+		c.expect(replyError("ERR value is not an integer or out of range"), "INCR", "key2")
+		c.expect("OK", "SET", "max", "9223372036854775807")
+		c.expect(replyError("ERR increment or decrement would overflow"), "INCR", "max")
+
+		c.expect("OK", "FLUSHDB")
+		c.expect(int64(0), "DBSIZE")
+
+		// binary values
+		c.expect("OK", "SET", "binary", "a\r\nb\x00")
+		c.expect("a\r\nb\x00", "GET", "binary")
+	}
+}
+
+func TestServer_TTL(t *testing.T) {
+	c, _ := newClient(t, "lru")
+
+	c.expect("OK", "SET", "seconds", "value", "EX", "100")
+	c.expect("OK", "SET", "milliseconds", "value", "PX", "50")
+	c.expect("OK", "SET", "persistent", "value")
+
+	c.expect(int64(100), "TTL", "seconds")
+	c.expect(int64(-1), "TTL", "persistent")
+	c.expect(int64(-2), "TTL", "non-existing-key")
+
+	// KEEPTTL and INCR keep the TTL, while SET and MSET discard it
+	c.expect("OK", "SET", "seconds", "1", "KEEPTTL")
+	c.expect(int64(2), "INCR", "seconds")
+	c.expect(int64(100), "TTL", "seconds")
+	c.expect("OK", "MSET", "seconds", "value")
+	c.expect(int64(-1), "TTL", "seconds")
+
+	c.expect(int64(1), "EXPIRE", "persistent", "200")
+	c.expect(int64(200), "TTL", "persistent")
+	c.expect(int64(1), "PERSIST", "persistent")
+	c.expect(int64(0), "PERSIST", "persistent")
+	c.expect(int64(-1), "TTL", "persistent")
+	c.expect(int64(0), "EXPIRE", "non-existing-key", "1")
+
+	// reads don't extend TTLs
+	c.expect("OK", "SET", "read", "value", "PX", "300")
+	time.Sleep(200 * time.Millisecond)
+	c.expect("value", "GET", "read")
+	time.Sleep(150 * time.Millisecond)
+	c.expect(nil, "GET", "read")
+	c.expect(int64(-2), "TTL", "read")
+	c.expect(nil, "GET", "milliseconds")
+
+	// non-positive TTLs delete keys
+	c.expect(int64(1), "EXPIRE", "persistent", "0")
+	c.expect(int64(0), "EXISTS", "persistent")
+
+	c.expect(replyError("ERR invalid expire time in 'set' command"), "SET", "key", "value", "EX", "0")
+	c.expect(replyError("ERR value is not an integer or out of range"), "SET", "key", "value", "PX", "x")
+	c.expect(replyError("ERR syntax error"), "SET", "key", "value", "NX", "XX")
+	c.expect(replyError("ERR syntax error"), "SET", "key", "value", "EX", "1", "KEEPTTL")
+	c.expect(replyError("ERR syntax error"), "SET", "key", "value", "EX")
+}
+
+func TestServer_Errors(t *testing.T) {
+	c, _ := newClient(t, "lru")
+
+	c.expect(replyError("ERR unknown command 'UNKNOWN'"), "UNKNOWN")
+	c.expect(replyError("ERR wrong number of arguments for 'get' command"), "GET")
+	c.expect(replyError("ERR wrong number of arguments for 'mset' command"), "MSET", "key", "value", "key")
+	c.expect(replyError("ERR DB index is out of range"), "SELECT", "1")
+	c.expect("OK", "SELECT", "0")
+}
+
+func TestServer_Protocols(t *testing.T) {
+	c, _ := newClient(t, "lru")
+
+	// inline commands
+	if reply := c.send("PING\r\n"); reply != "PONG" {
+		t.Errorf("reply %#v, want %#v", reply, "PONG")
+	}
+	if reply := c.send("ECHO hello\r\n"); reply != "hello" {
+		t.Errorf("reply %#v, want %#v", reply, "hello")
+	}
+
+	// lines longer than the read buffer
+	long := strings.Repeat("x", 10000)
+	if reply := c.send("ECHO " + long + "\r\n"); reply != long {
+		t.Errorf("reply of %d bytes, want %d bytes", len(fmt.Sprint(reply)), len(long))
+	}
+
+	hello := c.do("HELLO")
+	if array, ok := hello.([]interface{}); !ok || len(array) != 12 || array[5] != int64(2) {
+		t.Errorf("RESP2 hello %#v", hello)
+	}
+
+	hello = c.do("HELLO", "3")
+	if m, ok := hello.(map[string]interface{}); !ok || m["proto"] != int64(3) || m["server"] != "redis" {
+		t.Errorf("RESP3 hello %#v", hello)
+	}
+
+	// RESP3 null
+	c.conn.SetDeadline(time.Now().Add(time.Second))
+	io.WriteString(c.conn, "*2\r\n$3\r\nGET\r\n$3\r\nkey\r\n")
+	if line, _ := c.r.ReadString('\n'); line != "_\r\n" {
+		t.Errorf("null %q, want %q", line, "_\r\n")
+	}
+
+	c.expect(replyError("NOPROTO unsupported protocol version"), "HELLO", "4")
+	c.expect("OK", "CLIENT", "SETINFO", "LIB-NAME", "test")
+	c.expect([]interface{}{}, "COMMAND", "DOCS")
+}
+
+func TestServer_Info(t *testing.T) {
+	c, _ := newClient(t, "lru")
+
+	c.do("SET", "key1", "value")
+	c.do("SET", "key2", "value", "EX", "10")
+	c.do("GET", "key1")
+	c.do("GET", "non-existing-key")
+
+	info, _ := c.do("INFO").(string)
+	for _, want := range []string{"# Server\r\n", "keyspace_hits:1\r\n", "keyspace_misses:1\r\n", "db0:keys=2,expires=1,"} {
+		if !strings.Contains(info, want) {
+			t.Errorf("info %q does not contain %q", info, want)
+		}
+	}
+
+	info, _ = c.do("INFO", "keyspace").(string)
+	if strings.Contains(info, "# Server") || !strings.Contains(info, "# Keyspace") {
+		t.Errorf("info %q contains wrong sections", info)
+	}
+}
+
+func TestServer_Limits(t *testing.T) {
+	c, _ := newClient(t, "lru", resp.WithMaxValueSize(3))
+
+	c.expect("OK", "SET", "key", "abc")
+
+	// protocol errors close the connection
+	if reply := c.send("ECHO " + strings.Repeat("x", 64*1024) + "\r\n"); reply != replyError("ERR Protocol error: line is too long") {
+		t.Errorf("reply %#v, want %#v", reply, replyError("ERR Protocol error: line is too long"))
+	}
+
+	c = dial(t, c.conn.RemoteAddr().String())
+	if reply := c.send("*-1\r\n"); reply != replyError("ERR Protocol error: invalid multibulk length") {
+		t.Errorf("reply %#v, want %#v", reply, replyError("ERR Protocol error: invalid multibulk length"))
+	}
+
+	c = dial(t, c.conn.RemoteAddr().String())
+	c.expect(replyError("ERR Protocol error: invalid bulk length"), "SET", "key", "abcd")
+	if _, err := c.read(); !errors.Is(err, io.EOF) {
+		t.Errorf("error %v, want %v", err, io.EOF)
+	}
+}
+
+func TestServer_Shutdown(t *testing.T) {
+	c, s := newClient(t, "lru")
+	idle := dial(t, c.conn.RemoteAddr().String())
+
+	idle.expect("PONG", "PING")
+
+	// the command is active until all of its arguments are written
+	io.WriteString(c.conn, "*3\r\n$3\r\nSET\r\n$3\r\nkey\r\n$5\r\nval")
+	time.Sleep(20 * time.Millisecond)
+
+	shutdown := make(chan error)
+	go func() { shutdown <- s.Shutdown(context.Background()) }()
+
+	select {
+	case <-shutdown:
+		t.Fatalf("shutdown does not wait for the active command")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// idle connections are closed at once
+	if _, err := idle.read(); !errors.Is(err, io.EOF) {
+		t.Errorf("error %v, want %v", err, io.EOF)
+	}
+
+	if reply := c.send("ue\r\n"); reply != "OK" {
+		t.Errorf("reply %#v, want %#v", reply, "OK")
+	}
+	if err := <-shutdown; err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+}
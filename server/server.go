@@ -0,0 +1,272 @@
+// Package server adapts the caches of this module to a common interface
+// used by the network front-ends in its subpackages.
+package server
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/faroyam/caches/excache"
+	"github.com/faroyam/caches/lfu"
+	"github.com/faroyam/caches/lru"
+)
+
+// Cache represents safe for concurrent use cache of []byte values served over the network
+type Cache interface {
+	// Get returns (value, true) or (nil, false) for a given key
+	Get(key string) ([]byte, bool)
+	// Peek returns (value, true) or (nil, false) for a given key
+	// without touching the record, i.e. its recency, frequency and TTL stay the same
+	Peek(key string) ([]byte, bool)
+	// Put inserts a new record into the cache.
+	// ttl is ignored by caches that do not expire records, 0 means the default TTL.
+	Put(key string, value []byte, ttl time.Duration)
+	// Compute atomically replaces the value for a given key with the one returned by f
+	// or removes the record if f returns keep == false. Returns the new value and keep.
+	// ttl is used as in Put.
+	Compute(key string, ttl time.Duration, f func(old []byte, exists bool) (value []byte, keep bool)) ([]byte, bool)
+	// Delete removes the record associated with the specified key and reports whether it existed
+	Delete(key string) bool
+	// KeysWithPrefix returns the keys starting with the prefix in lexicographic order
+	KeysWithPrefix(prefix string) []string
+	// Clear removes all saved records
+	Clear()
+	// Len returns the number of records in the cache
+	Len() int
+	// Cap returns the maximum number of records in the cache
+	Cap() int
+	// Expiring reports whether the cache supports TTL
+	Expiring() bool
+}
+
+// New returns a cache of the policy adapted for serving, policy is one of "lru", "lfu" or "expiring".
+// Keys of the cache are indexed for prefix queries,
+// ttl is used by the expiring cache for records put without TTL.
+func New(policy string, capacity int, ttl time.Duration) (Cache, error) {
+	switch policy {
+	case "lru":
+		c, err := lru.New(capacity, lru.WithPrefixIndex())
+		if err != nil {
+			return nil, err
+		}
+		return LRU(c), nil
+	case "lfu":
+		c, err := lfu.New(capacity, lfu.WithPrefixIndex())
+		if err != nil {
+			return nil, err
+		}
+		return LFU(c), nil
+	case "expiring":
+		c, err := excache.New(capacity, excache.WithPrefixIndex())
+		if err != nil {
+			return nil, err
+		}
+		return Expiring(c, ttl), nil
+	default:
+		return nil, fmt.Errorf("unknown policy %q", policy)
+	}
+}
+
+// Stats represents statistics of a cache served over the network
+type Stats struct {
+	Len    int   `json:"len"`
+	Cap    int   `json:"cap"`
+	Hits   int64 `json:"hits"`
+	Misses int64 `json:"misses"`
+}
+
+// Counter counts cache hits and misses of a front-end
+type Counter struct {
+	hits   int64
+	misses int64
+}
+
+// Count counts a hit if ok is true and a miss otherwise
+func (c *Counter) Count(ok bool) {
+	if ok {
+		atomic.AddInt64(&c.hits, 1)
+		return
+	}
+	atomic.AddInt64(&c.misses, 1)
+}
+
+// Stats returns statistics of the cache including the hits and misses counted so far
+func (c *Counter) Stats(cache Cache) Stats {
+	return Stats{
+		Len:    cache.Len(),
+		Cap:    cache.Cap(),
+		Hits:   atomic.LoadInt64(&c.hits),
+		Misses: atomic.LoadInt64(&c.misses),
+	}
+}
+
+// LRU adapts lru.Cache to Cache, records of the cache must hold []byte values
+func LRU(c *lru.Cache) Cache {
+	return &policyCache{c: c}
+}
+
+// LFU adapts lfu.Cache to Cache, records of the cache must hold []byte values
+func LFU(c *lfu.Cache) Cache {
+	return &policyCache{c: c}
+}
+
+// policy represents the methods shared by lru.Cache and lfu.Cache
+type policy interface {
+	Get(key string) (interface{}, bool)
+	Peek(key string) (interface{}, bool)
+	Put(key string, value interface{})
+	Compute(key string, f func(old interface{}, exists bool) (value interface{}, keep bool)) (interface{}, bool)
+	KeysWithPrefix(prefix string) []string
+	Clear()
+	Len() int
+	Cap() int
+}
+
+// policyCache adapts caches that do not expire records to Cache
+type policyCache struct {
+	c policy
+}
+
+func (c *policyCache) Get(key string) ([]byte, bool) {
+	value, ok := c.c.Get(key)
+	if !ok {
+		return nil, false
+	}
+	return toBytes(value), true
+}
+
+func (c *policyCache) Peek(key string) ([]byte, bool) {
+	value, ok := c.c.Peek(key)
+	if !ok {
+		return nil, false
+	}
+	return toBytes(value), true
+}
+
+func (c *policyCache) Put(key string, value []byte, _ time.Duration) {
+	c.c.Put(key, value)
+}
+
+func (c *policyCache) Compute(key string, _ time.Duration, f func(old []byte, exists bool) ([]byte, bool)) ([]byte, bool) {
+	value, keep := c.c.Compute(key, compute(f))
+	return toBytes(value), keep
+}
+
+func (c *policyCache) Delete(key string) bool {
+	return remove(func(f func(old interface{}, exists bool) (interface{}, bool)) {
+		c.c.Compute(key, f)
+	})
+}
+
+func (c *policyCache) KeysWithPrefix(prefix string) []string {
+	return c.c.KeysWithPrefix(prefix)
+}
+
+func (c *policyCache) Clear() {
+	c.c.Clear()
+}
+
+func (c *policyCache) Len() int {
+	return c.c.Len()
+}
+
+func (c *policyCache) Cap() int {
+	return c.c.Cap()
+}
+
+func (c *policyCache) Expiring() bool {
+	return false
+}
+
+// Expiring adapts excache.Cache to Cache using ttl for records put without TTL,
+// records of the cache must hold []byte values
+func Expiring(c *excache.Cache, ttl time.Duration) Cache {
+	return &expiringCache{c: c, ttl: ttl}
+}
+
+type expiringCache struct {
+	c   *excache.Cache
+	ttl time.Duration
+}
+
+func (c *expiringCache) Get(key string) ([]byte, bool) {
+	value, ok := c.c.Get(key)
+	if !ok {
+		return nil, false
+	}
+	return toBytes(value), true
+}
+
+func (c *expiringCache) Peek(key string) ([]byte, bool) {
+	value, ok := c.c.Peek(key)
+	if !ok {
+		return nil, false
+	}
+	return toBytes(value), true
+}
+
+func (c *expiringCache) Put(key string, value []byte, ttl time.Duration) {
+	c.c.Put(key, value, c.orDefault(ttl))
+}
+
+func (c *expiringCache) Compute(key string, ttl time.Duration, f func(old []byte, exists bool) ([]byte, bool)) ([]byte, bool) {
+	value, keep := c.c.Compute(key, c.orDefault(ttl), compute(f))
+	return toBytes(value), keep
+}
+
+func (c *expiringCache) Delete(key string) bool {
+	return remove(func(f func(old interface{}, exists bool) (interface{}, bool)) {
+		c.c.Compute(key, c.ttl, f)
+	})
+}
+
+func (c *expiringCache) KeysWithPrefix(prefix string) []string {
+	return c.c.KeysWithPrefix(prefix)
+}
+
+func (c *expiringCache) Clear() {
+	c.c.Clear()
+}
+
+func (c *expiringCache) Len() int {
+	return c.c.Len()
+}
+
+func (c *expiringCache) Cap() int {
+	return c.c.Cap()
+}
+
+func (c *expiringCache) Expiring() bool {
+	return true
+}
+
+func (c *expiringCache) orDefault(ttl time.Duration) time.Duration {
+	if ttl == 0 {
+		return c.ttl
+	}
+	return ttl
+}
+
+// compute adapts f to the Compute methods of the caches
+func compute(f func(old []byte, exists bool) ([]byte, bool)) func(old interface{}, exists bool) (interface{}, bool) {
+	return func(old interface{}, exists bool) (interface{}, bool) {
+		value, keep := f(toBytes(old), exists)
+		return value, keep
+	}
+}
+
+// remove atomically removes a record with the Compute method of a cache and reports whether it existed
+func remove(compute func(f func(old interface{}, exists bool) (interface{}, bool))) bool {
+	var existed bool
+	compute(func(_ interface{}, exists bool) (interface{}, bool) {
+		existed = exists
+		return nil, false
+	})
+	return existed
+}
+
+func toBytes(value interface{}) []byte {
+	b, _ := value.([]byte)
+	return b
+}
@@ -0,0 +1,92 @@
+package server_test
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/faroyam/caches/server"
+)
+
+func TestNew(t *testing.T) {
+	if _, err := server.New("fifo", 10, 0); err == nil {
+		t.Errorf("expected error")
+	}
+
+	if _, err := server.New("lru", 0, 0); err == nil {
+		t.Errorf("expected error")
+	}
+}
+
+func TestCache(t *testing.T) {
+	for _, policy := range []string{"lru", "lfu", "expiring"} {
+		cache, _ := server.New(policy, 10, time.Minute)
+
+		cache.Put("key1", []byte("value1"), 0)
+		cache.Put("key2", []byte("value2"), time.Minute)
+		cache.Put("other", []byte("value3"), 0)
+
+		if value, ok := cache.Get("key1"); !ok || string(value) != "value1" {
+			t.Errorf("%s: cached value %s, want %v", policy, value, "value1")
+		}
+
+		value, keep := cache.Compute("key1", 0, func(old []byte, exists bool) ([]byte, bool) {
+			return append(old, '!'), exists
+		})
+		if !keep || string(value) != "value1!" {
+			t.Errorf("%s: computed value %s, want %v", policy, value, "value1!")
+		}
+
+		if keys := cache.KeysWithPrefix("key"); !reflect.DeepEqual(keys, []string{"key1", "key2"}) {
+			t.Errorf("%s: keys %v, want %v", policy, keys, []string{"key1", "key2"})
+		}
+
+		if !cache.Delete("key1") || cache.Delete("key1") {
+			t.Errorf("%s: delete reports wrong existence", policy)
+		}
+
+		if cache.Len() != 2 || cache.Cap() != 10 {
+			t.Errorf("%s: len %v, cap %v", policy, cache.Len(), cache.Cap())
+		}
+
+		if cache.Expiring() != (policy == "expiring") {
+			t.Errorf("%s: expiring %v", policy, cache.Expiring())
+		}
+
+		cache.Clear()
+		if cache.Len() != 0 {
+			t.Errorf("%s: len %v, want %v", policy, cache.Len(), 0)
+		}
+	}
+}
+
+func TestExpiring(t *testing.T) {
+	cache, _ := server.New("expiring", 10, 10*time.Millisecond)
+
+	cache.Put("default", []byte("value"), 0)
+	cache.Put("custom", []byte("value"), time.Minute)
+	time.Sleep(20 * time.Millisecond)
+
+	if _, ok := cache.Get("default"); ok {
+		t.Errorf("record with the default TTL is not expired")
+	}
+
+	if _, ok := cache.Get("custom"); !ok {
+		t.Errorf("record with a custom TTL is expired")
+	}
+}
+
+func TestCounter(t *testing.T) {
+	cache, _ := server.New("lru", 10, 0)
+	cache.Put("key", []byte("value"), 0)
+
+	counter := &server.Counter{}
+	counter.Count(true)
+	counter.Count(true)
+	counter.Count(false)
+
+	want := server.Stats{Len: 1, Cap: 10, Hits: 2, Misses: 1}
+	if stats := counter.Stats(cache); stats != want {
+		t.Errorf("stats %+v, want %+v", stats, want)
+	}
+}
@@ -0,0 +1,206 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	// ErrServerClosed is returned by TCP.Serve after Shutdown
+	ErrServerClosed = errors.New("server closed")
+	// ErrLineTooLong is returned by Conn.ReadLine for lines exceeding the limit
+	ErrLineTooLong = errors.New("line is too long")
+)
+
+// TCP serves connections of a line-based protocol and shuts down gracefully:
+// idle connections are closed at once, while active ones finish their current command.
+type TCP struct {
+	m         *sync.Mutex
+	listeners map[net.Listener]struct{}
+	conns     map[*Conn]struct{}
+	closing   int32
+	wg        *sync.WaitGroup
+}
+
+// NewTCP returns an initialized TCP instance
+func NewTCP() *TCP {
+	return &TCP{
+		m:         &sync.Mutex{},
+		listeners: make(map[net.Listener]struct{}),
+		conns:     make(map[*Conn]struct{}),
+		wg:        &sync.WaitGroup{},
+	}
+}
+
+// Serve accepts connections on the listener and handles each of them in its own goroutine
+// until Shutdown is called. Returns nil after Shutdown.
+func (s *TCP) Serve(l net.Listener, handle func(c *Conn)) error {
+	s.m.Lock()
+	if s.isClosing() {
+		s.m.Unlock()
+		l.Close()
+		return ErrServerClosed
+	}
+	s.listeners[l] = struct{}{}
+	s.m.Unlock()
+
+	for {
+		nc, err := l.Accept()
+		if err != nil {
+			if s.isClosing() {
+				return nil
+			}
+			return err
+		}
+
+		c := &Conn{
+			Conn: nc,
+			R:    bufio.NewReader(nc),
+			W:    bufio.NewWriter(nc),
+			m:    &sync.Mutex{},
+			s:    s,
+		}
+
+		s.m.Lock()
+		if s.isClosing() {
+			s.m.Unlock()
+			nc.Close()
+			return nil
+		}
+		s.conns[c] = struct{}{}
+		s.wg.Add(1)
+		s.m.Unlock()
+
+		go func() {
+			defer s.wg.Done()
+			defer s.remove(c)
+
+			handle(c)
+		}()
+	}
+}
+
+// Shutdown stops accepting connections, closes idle connections
+// and waits for active ones to finish their current command until the context is done.
+// Connections still active by then are closed.
+func (s *TCP) Shutdown(ctx context.Context) error {
+	atomic.StoreInt32(&s.closing, 1)
+
+	s.m.Lock()
+	for l := range s.listeners {
+		l.Close()
+	}
+	for c := range s.conns {
+		c.interrupt()
+	}
+	s.m.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		s.m.Lock()
+		for c := range s.conns {
+			c.Close()
+		}
+		s.m.Unlock()
+		return ctx.Err()
+	}
+}
+
+func (s *TCP) remove(c *Conn) {
+	c.Close()
+
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	delete(s.conns, c)
+}
+
+func (s *TCP) isClosing() bool {
+	return atomic.LoadInt32(&s.closing) == 1
+}
+
+// Conn represents a connection served by TCP.
+// Handlers read commands from R while the connection is idle,
+// call Begin before executing a command and End after writing the response to W.
+type Conn struct {
+	net.Conn
+	R *bufio.Reader
+	W *bufio.Writer
+
+	m      *sync.Mutex
+	s      *TCP
+	active bool
+}
+
+// Begin marks the connection active.
+// Returns false if the server is shutting down and the connection must be closed.
+func (c *Conn) Begin() bool {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	if c.s.isClosing() {
+		return false
+	}
+	c.active = true
+	return true
+}
+
+// End flushes W and marks the connection idle.
+// Returns false if the connection must be closed.
+func (c *Conn) End() bool {
+	err := c.W.Flush()
+
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	c.active = false
+	return err == nil && !c.s.isClosing()
+}
+
+// ReadLine reads a line of at most size bytes from R and trims the line ending.
+// Lines longer than the buffer of R are accumulated up to the size.
+func (c *Conn) ReadLine(size int) (string, error) {
+	var long []byte
+	for {
+		line, err := c.R.ReadSlice('\n')
+		if len(long)+len(line) > size {
+			return "", ErrLineTooLong
+		}
+		if errors.Is(err, bufio.ErrBufferFull) {
+			long = append(long, line...)
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+
+		if long != nil {
+			line = append(long, line...)
+		}
+		return strings.TrimRight(string(line), "\r\n"), nil
+	}
+}
+
+// interrupt unblocks reading of an idle connection
+func (c *Conn) interrupt() {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	if !c.active {
+		c.SetReadDeadline(time.Now())
+	}
+}
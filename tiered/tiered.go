@@ -0,0 +1,340 @@
+// Package tiered implements a two-tier cache:
+// an in-memory cache of any policy as the first tier (L1) and an on-disk store as the second one (L2).
+//
+// Records evicted from L1 are written to L2, and L2 hits are promoted back to L1,
+// so that a record lives in one tier at a time.
+// Promotions, demotions, Put and Delete of the same key are serialized, so neither of them overwrites a newer value.
+// For that Evicted must be registered as an eviction hook of L1.
+// It queues evicted records, which are written to L2 by Get and Put after they release L1,
+// so that writes to L1 don't wait for the disk. Flush writes the queue of records evicted otherwise, e.g. by Resize of L1.
+//
+//	var t *tiered.Cache
+//	l1, _ := lru.New(1000, lru.WithEvictionHook(func(key string, value interface{}) {
+//		t.Evicted(key, value)
+//	}))
+//	l2, _ := disk.Open(dir)
+//	t = tiered.New(l1, l2)
+package tiered
+
+import (
+	"fmt"
+	"hash/fnv"
+	"sync"
+)
+
+// L1 represents an in-memory cache, e.g. lru.Cache or lfu.Cache
+type L1 interface {
+	Get(key string) (interface{}, bool)
+	Put(key string, value interface{})
+	Delete(key string)
+}
+
+// L2 represents an on-disk store, e.g. disk.Store
+type L2 interface {
+	Get(key string) ([]byte, bool, error)
+	Put(key string, value []byte) error
+	Delete(key string) error
+}
+
+// Stats represents hit statistics of the cache
+type Stats struct {
+	L1Hits  int64
+	L2Hits  int64
+	Misses  int64
+	Demoted int64
+	// Dropped is the number of evicted records not demoted since the queue was full
+	Dropped int64
+	Errors  int64
+}
+
+// Cache represents safe for concurrent use two-tier cache
+type Cache struct {
+	l1 L1
+	l2 L2
+
+	marshal   func(key string, value interface{}) ([]byte, error)
+	unmarshal func(key string, data []byte) (interface{}, error)
+
+	locks []sync.Mutex // serialize promotions and demotions with Put and Delete of keys sharing a lock
+
+	m     *sync.Mutex
+	stats Stats
+	// queue holds records evicted from L1 and not written to L2 yet
+	queue     map[string]demotion
+	queueSize int
+	sequence  uint64
+}
+
+// demotion is a record waiting to be written to L2
+type demotion struct {
+	value interface{}
+	// sequence tells a record from the one evicted again while it was written
+	sequence uint64
+}
+
+const locksCount = 64
+
+// defaultQueueSize is the number of evicted records waiting for L2 by default
+const defaultQueueSize = 1024
+
+// Option configures a cache instance
+type Option func(*Cache)
+
+// WithMarshaling sets the functions converting values to bytes stored in L2 and back.
+// By default only []byte values can be demoted to L2.
+func WithMarshaling(marshal func(value interface{}) ([]byte, error), unmarshal func(data []byte) (interface{}, error)) Option {
+	return WithKeyMarshaling(
+		func(_ string, value interface{}) ([]byte, error) {
+			return marshal(value)
+		},
+		func(_ string, data []byte) (interface{}, error) {
+			return unmarshal(data)
+		},
+	)
+}
+
+// WithKeyMarshaling is WithMarshaling passing the functions the keys of values as well,
+// e.g. to bind encrypted values to their keys with encryption.Codec.Marshaling
+func WithKeyMarshaling(marshal func(key string, value interface{}) ([]byte, error), unmarshal func(key string, data []byte) (interface{}, error)) Option {
+	return func(c *Cache) {
+		c.marshal = marshal
+		c.unmarshal = unmarshal
+	}
+}
+
+// WithQueueSize sets the number of evicted records waiting to be written to L2,
+// records evicted while the queue is full are dropped. 1024 by default.
+func WithQueueSize(size int) Option {
+	return func(c *Cache) {
+		c.queueSize = size
+	}
+}
+
+// New returns an initialized cache instance
+func New(l1 L1, l2 L2, options ...Option) *Cache {
+	c := &Cache{
+		l1:        l1,
+		l2:        l2,
+		marshal:   marshalBytes,
+		unmarshal: unmarshalBytes,
+		locks:     make([]sync.Mutex, locksCount),
+		m:         &sync.Mutex{},
+		queue:     make(map[string]demotion),
+		queueSize: defaultQueueSize,
+	}
+
+	for _, option := range options {
+		option(c)
+	}
+	return c
+}
+
+// Get returns (value, true, nil) or (nil, false, nil) for a given key.
+// A record found in L2 or in the queue of evicted records is promoted to L1.
+func (c *Cache) Get(key string) (interface{}, bool, error) {
+	if value, ok := c.l1.Get(key); ok {
+		c.count(func(s *Stats) { s.L1Hits++ })
+		return value, true, nil
+	}
+
+	value, ok, err := c.get(key)
+	c.demote()
+	return value, ok, err
+}
+
+// get looks the key up in the queue and L2 and promotes the record to L1
+func (c *Cache) get(key string) (interface{}, bool, error) {
+	lock := c.lock(key)
+	lock.Lock()
+	defer lock.Unlock()
+
+	// the record may have been promoted or put while waiting for the lock
+
+	if value, ok := c.l1.Get(key); ok {
+		c.count(func(s *Stats) { s.L1Hits++ })
+		return value, true, nil
+	}
+
+	if d, ok := c.dequeue(key); ok {
+		c.count(func(s *Stats) { s.L1Hits++ })
+		c.l1.Put(key, d.value)
+		return d.value, true, nil
+	}
+
+	data, ok, err := c.l2.Get(key)
+	if err != nil {
+		c.count(func(s *Stats) { s.Errors++ })
+		return nil, false, err
+	}
+	if !ok {
+		c.count(func(s *Stats) { s.Misses++ })
+		return nil, false, nil
+	}
+
+	value, err := c.unmarshal(key, data)
+	if err != nil {
+		c.count(func(s *Stats) { s.Errors++ })
+		return nil, false, err
+	}
+
+	c.count(func(s *Stats) { s.L2Hits++ })
+
+	// deleting from L2 first keeps the record if L1 evicts it right after the promotion
+
+	if err := c.l2.Delete(key); err != nil {
+		return nil, false, err
+	}
+	c.l1.Put(key, value)
+
+	return value, true, nil
+}
+
+// Put inserts a new record into L1 and removes a stale copy from L2
+func (c *Cache) Put(key string, value interface{}) error {
+	err := c.put(key, value)
+	c.demote()
+	return err
+}
+
+func (c *Cache) put(key string, value interface{}) error {
+	lock := c.lock(key)
+	lock.Lock()
+	defer lock.Unlock()
+
+	c.dequeue(key)
+	if err := c.l2.Delete(key); err != nil {
+		return err
+	}
+	c.l1.Put(key, value)
+	return nil
+}
+
+// Delete removes the record associated with the specified key from both tiers
+func (c *Cache) Delete(key string) error {
+	lock := c.lock(key)
+	lock.Lock()
+	defer lock.Unlock()
+
+	c.l1.Delete(key)
+	c.dequeue(key)
+	return c.l2.Delete(key)
+}
+
+// Evicted queues the record evicted from L1 to be written to L2.
+// It is meant to be registered as an eviction hook of L1.
+// It does not take the key lock, since L1 evicts records while a promotion or Put holds one.
+// Records evicted while the queue is full are dropped and counted in Stats.Dropped.
+func (c *Cache) Evicted(key string, value interface{}) {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	if _, ok := c.queue[key]; !ok && len(c.queue) >= c.queueSize {
+		c.stats.Dropped++
+		return
+	}
+	c.sequence++
+	c.queue[key] = demotion{value: value, sequence: c.sequence}
+}
+
+// Flush writes the queued records evicted from L1 to L2.
+// Records that can't be marshaled or written are dropped and counted in Stats.Errors,
+// the first error is returned.
+func (c *Cache) Flush() error {
+	c.m.Lock()
+	keys := make([]string, 0, len(c.queue))
+	for key := range c.queue {
+		keys = append(keys, key)
+	}
+	c.m.Unlock()
+
+	var firstErr error
+	for _, key := range keys {
+		if err := c.write(key); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// Stats returns hit statistics of the cache
+func (c *Cache) Stats() Stats {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	return c.stats
+}
+
+// demote writes the queued records to L2, errors are counted in Stats
+func (c *Cache) demote() {
+	_ = c.Flush()
+}
+
+// write writes the queued record of the key to L2 keeping it queued until then,
+// so that Get finds it meanwhile
+func (c *Cache) write(key string) error {
+	lock := c.lock(key)
+	lock.Lock()
+	defer lock.Unlock()
+
+	c.m.Lock()
+	d, ok := c.queue[key]
+	c.m.Unlock()
+	if !ok {
+		// promoted, written or deleted in the meantime
+		return nil
+	}
+
+	data, err := c.marshal(key, d.value)
+	if err == nil {
+		err = c.l2.Put(key, data)
+	}
+
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	if current, ok := c.queue[key]; ok && current.sequence == d.sequence {
+		delete(c.queue, key)
+	}
+	if err != nil {
+		c.stats.Errors++
+		return err
+	}
+	c.stats.Demoted++
+	return nil
+}
+
+// dequeue removes the queued record of the key. The caller must hold the key lock.
+func (c *Cache) dequeue(key string) (demotion, bool) {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	d, ok := c.queue[key]
+	delete(c.queue, key)
+	return d, ok
+}
+
+// lock returns the lock serializing operations on the key
+func (c *Cache) lock(key string) *sync.Mutex {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return &c.locks[h.Sum32()%locksCount]
+}
+
+func (c *Cache) count(f func(s *Stats)) {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	f(&c.stats)
+}
+
+func marshalBytes(_ string, value interface{}) ([]byte, error) {
+	data, ok := value.([]byte)
+	if !ok {
+		return nil, fmt.Errorf("value of type %T is not []byte", value)
+	}
+	return data, nil
+}
+
+func unmarshalBytes(_ string, data []byte) (interface{}, error) {
+	return data, nil
+}
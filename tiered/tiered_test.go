@@ -0,0 +1,229 @@
+package tiered_test
+
+import (
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/faroyam/caches/disk"
+	"github.com/faroyam/caches/lru"
+	"github.com/faroyam/caches/tiered"
+)
+
+func newCache(t *testing.T, capacity int, options ...tiered.Option) (*tiered.Cache, *lru.Cache, *disk.Store) {
+	l2, err := disk.Open(t.TempDir())
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	t.Cleanup(func() { l2.Close() })
+
+	var c *tiered.Cache
+	l1, _ := lru.New(capacity, lru.WithEvictionHook(func(key string, value interface{}) {
+		c.Evicted(key, value)
+	}))
+	c = tiered.New(l1, l2, options...)
+
+	return c, l1, l2
+}
+
+func TestCache_Get(t *testing.T) {
+	cache, l1, l2 := newCache(t, 2)
+
+	cache.Put("1", []byte("1"))
+	cache.Put("2", []byte("2"))
+	cache.Put("3", []byte("3"))
+
+	// "1" is demoted to L2
+
+	if l1.Contains("1") || !l2.Contains("1") {
+		t.Errorf("record is not demoted")
+	}
+
+	if value, ok, err := cache.Get("1"); err != nil || !ok || string(value.([]byte)) != "1" {
+		t.Errorf("cached value %v, want %v", value, "1")
+	}
+
+	// "1" is promoted to L1 and "2" is demoted to L2
+
+	if !l1.Contains("1") || l2.Contains("1") || !l2.Contains("2") {
+		t.Errorf("record is not promoted")
+	}
+
+	if value, ok, _ := cache.Get("non-existing-key"); ok {
+		t.Errorf("cached value %v, want %v", value, "nil")
+	}
+
+	stats := cache.Stats()
+	if stats.L2Hits != 1 || stats.Misses != 1 || stats.Demoted != 2 {
+		t.Errorf("stats %+v", stats)
+	}
+}
+
+func TestCache_Put(t *testing.T) {
+	cache, _, l2 := newCache(t, 1)
+
+	cache.Put("1", []byte("1"))
+	cache.Put("2", []byte("2"))
+	cache.Put("1", []byte("1'"))
+
+	// the stale copy is removed from L2
+
+	if value, _, _ := l2.Get("1"); value != nil {
+		t.Errorf("stored value %s, want %v", value, "nil")
+	}
+
+	if value, _, _ := cache.Get("1"); string(value.([]byte)) != "1'" {
+		t.Errorf("cached value %v, want %v", value, "1'")
+	}
+}
+
+func TestCache_Delete(t *testing.T) {
+	cache, l1, l2 := newCache(t, 1)
+
+	cache.Put("1", []byte("1"))
+	cache.Put("2", []byte("2"))
+
+	cache.Delete("1")
+	cache.Delete("2")
+
+	if l1.Len() != 0 || l2.Len() != 0 {
+		t.Errorf("len %v, %v, want %v, %v", l1.Len(), l2.Len(), 0, 0)
+	}
+}
+
+func TestCache_WithMarshaling(t *testing.T) {
+	cache, _, _ := newCache(t, 1, tiered.WithMarshaling(
+		func(value interface{}) ([]byte, error) {
+			n, ok := value.(int)
+			if !ok {
+				return nil, errors.New("not an int")
+			}
+			return []byte(strconv.Itoa(n)), nil
+		},
+		func(data []byte) (interface{}, error) {
+			return strconv.Atoi(string(data))
+		},
+	))
+
+	cache.Put("1", 1)
+	cache.Put("2", 2)
+	cache.Put("3", "3")
+	cache.Put("4", 4)
+
+	if value, ok, _ := cache.Get("1"); !ok || value != 1 {
+		t.Errorf("cached value %v, want %v", value, 1)
+	}
+
+	if value, ok, _ := cache.Get("3"); ok {
+		t.Errorf("cached value %v, want %v", value, "nil")
+	}
+
+	if stats := cache.Stats(); stats.Errors != 1 {
+		t.Errorf("errors %v, want %v", stats.Errors, 1)
+	}
+}
+
+// racingL2 runs put concurrently with the next Get of the key and gives it time to complete
+type racingL2 struct {
+	*disk.Store
+	key  string
+	put  func()
+	done chan struct{}
+}
+
+func (l2 *racingL2) Get(key string) ([]byte, bool, error) {
+	data, ok, err := l2.Store.Get(key)
+	if key == l2.key && l2.put != nil {
+		put := l2.put
+		l2.put = nil
+		go func() {
+			defer close(l2.done)
+			put()
+		}()
+		time.Sleep(time.Millisecond * 10)
+	}
+	return data, ok, err
+}
+
+func TestCache_Get_Race(t *testing.T) {
+	store, err := disk.Open(t.TempDir())
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	defer store.Close()
+
+	l2 := &racingL2{Store: store, key: "1", done: make(chan struct{})}
+
+	var c *tiered.Cache
+	l1, _ := lru.New(1, lru.WithEvictionHook(func(key string, value interface{}) {
+		c.Evicted(key, value)
+	}))
+	c = tiered.New(l1, l2)
+
+	c.Put("1", []byte("1"))
+	c.Put("2", []byte("2"))
+
+	// "1" is put while its old value is being promoted from L2
+
+	l2.put = func() { c.Put("1", []byte("1'")) }
+	c.Get("1")
+	<-l2.done
+
+	if value, ok, _ := c.Get("1"); !ok || string(value.([]byte)) != "1'" {
+		t.Errorf("cached value %s, want %v", value, "1'")
+	}
+}
+
+// blockingL2 blocks writes until release is closed
+type blockingL2 struct {
+	*disk.Store
+	release chan struct{}
+}
+
+func (l2 *blockingL2) Put(key string, value []byte) error {
+	<-l2.release
+	return l2.Store.Put(key, value)
+}
+
+func TestCache_Evicted(t *testing.T) {
+	store, err := disk.Open(t.TempDir())
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	defer store.Close()
+
+	l2 := &blockingL2{Store: store, release: make(chan struct{})}
+
+	var c *tiered.Cache
+	l1, _ := lru.New(1, lru.WithEvictionHook(func(key string, value interface{}) {
+		c.Evicted(key, value)
+	}))
+	c = tiered.New(l1, l2, tiered.WithQueueSize(1))
+
+	// evictions of L1 don't wait for L2
+	l1.Put("1", []byte("1"))
+	l1.Put("2", []byte("2"))
+	l1.Put("3", []byte("3"))
+
+	if stats := c.Stats(); stats.Dropped != 1 || stats.Demoted != 0 {
+		t.Errorf("stats %+v", stats)
+	}
+
+	close(l2.release)
+	if err := c.Flush(); err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+	if !store.Contains("1") || store.Contains("2") {
+		t.Errorf("queued record is not demoted")
+	}
+
+	// queued records are found before they are written
+	l1.Put("4", []byte("4"))
+	if store.Contains("3") {
+		t.Errorf("queued record is written")
+	}
+	if value, ok, _ := c.Get("3"); !ok || string(value.([]byte)) != "3" {
+		t.Errorf("cached value %v, want %v", value, "3")
+	}
+}
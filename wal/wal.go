@@ -0,0 +1,624 @@
+// Package wal implements a write-ahead log that makes caches survive restarts.
+//
+// A cache configured with a log appends every Put, Delete, eviction, expiration and Clear to it,
+// and replays the log into itself when it is created.
+// Compaction folds the log into a snapshot holding only the live records and truncates the log.
+//
+// Every record is protected by a checksum. A torn or corrupted record at the end of the log,
+// e.g. after a crash, is truncated along with everything after it when the log is opened.
+package wal
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	logName      = "wal.log"
+	snapshotName = "wal.snapshot"
+
+	// record header: crc32 of the payload, payload length
+	headerSize = 4 + 4
+
+	// maxPayloadSize limits records, so that a corrupted length is not trusted on reading
+	maxPayloadSize = 1 << 28
+)
+
+const (
+	opPut byte = iota + 1
+	opDelete
+	opExpire
+	opClear
+)
+
+var errCorrupted = errors.New("corrupted record")
+
+// SyncPolicy defines when the log is flushed to stable storage
+type SyncPolicy int
+
+const (
+	// SyncAlways syncs the log after every record
+	SyncAlways SyncPolicy = iota
+	// SyncInterval syncs the log periodically in background
+	SyncInterval
+	// SyncNever leaves syncing to the operating system
+	SyncNever
+)
+
+// Entry represents a live record replayed from the log
+type Entry struct {
+	Key   string
+	Value interface{}
+	// ExpireAt is the expiration time in Unix nanoseconds or 0 if the record never expires
+	ExpireAt int64
+}
+
+// Log represents safe for concurrent use write-ahead log
+type Log struct {
+	m   *sync.Mutex
+	dir string
+	f   *os.File
+
+	size           int64
+	compactionSize int64
+	syncPolicy     SyncPolicy
+	syncInterval   time.Duration
+	unsynced       bool
+	err            error
+	codec          Codec
+	marshal        func(value interface{}) ([]byte, error)
+	unmarshal      func(data []byte) (interface{}, error)
+	stop           chan struct{}
+	done           chan struct{}
+	closeOnce      *sync.Once
+}
+
+// Codec transforms records written to the log and the snapshot, e.g. encrypts them
+type Codec interface {
+	Encode(data []byte) ([]byte, error)
+	Decode(data []byte) ([]byte, error)
+}
+
+// Option configures a log instance
+type Option func(*Log)
+
+// WithSync sets the sync policy of the log.
+// The interval is used by SyncInterval policy only.
+// By default the log is synced after every record.
+func WithSync(policy SyncPolicy, interval time.Duration) Option {
+	return func(l *Log) {
+		l.syncPolicy = policy
+		l.syncInterval = interval
+	}
+}
+
+// WithCompactionSize makes the log compact itself once it grows beyond size bytes
+func WithCompactionSize(size int64) Option {
+	return func(l *Log) {
+		l.compactionSize = size
+	}
+}
+
+// WithMarshaling sets the functions converting values to bytes and back.
+// By default values are encoded with encoding/gob,
+// so types other than the basic ones must be registered with gob.Register.
+func WithMarshaling(marshal func(value interface{}) ([]byte, error), unmarshal func(data []byte) (interface{}, error)) Option {
+	return func(l *Log) {
+		l.marshal = marshal
+		l.unmarshal = unmarshal
+	}
+}
+
+// WithCodec makes the log pass every record, including its key, through the codec
+// before writing it to the log or the snapshot and after reading it back.
+// A record the codec fails to decode makes Entries return the error.
+func WithCodec(codec Codec) Option {
+	return func(l *Log) {
+		l.codec = codec
+	}
+}
+
+// Open opens the log in the directory creating the directory if necessary.
+// A torn tail of the log is truncated.
+func Open(dir string, options ...Option) (*Log, error) {
+	l := &Log{
+		m:          &sync.Mutex{},
+		dir:        dir,
+		syncPolicy: SyncAlways,
+		marshal:    marshalGob,
+		unmarshal:  unmarshalGob,
+		stop:       make(chan struct{}),
+		done:       make(chan struct{}),
+		closeOnce:  &sync.Once{},
+	}
+
+	for _, option := range options {
+		option(l)
+	}
+
+	if l.syncPolicy == SyncInterval && l.syncInterval <= 0 {
+		return nil, fmt.Errorf("sync interval must be positive")
+	}
+	if l.compactionSize < 0 {
+		return nil, fmt.Errorf("compaction size can't be negative")
+	}
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	f, err := os.OpenFile(filepath.Join(dir, logName), os.O_CREATE|os.O_RDWR, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	l.f = f
+
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	size, err := readRecords(f, info.Size(), func([]byte) error { return nil })
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if err := f.Truncate(size); err != nil {
+		f.Close()
+		return nil, err
+	}
+	l.size = size
+
+	if l.syncPolicy == SyncInterval {
+		go l.run()
+	} else {
+		close(l.done)
+	}
+	return l, nil
+}
+
+// Entries returns the live records of the snapshot and the log in the order they were written.
+// Expired records are skipped.
+func (l *Log) Entries() ([]Entry, error) {
+	l.m.Lock()
+	defer l.m.Unlock()
+
+	s, err := l.fold()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UnixNano()
+	entries := make([]Entry, 0, len(s.records))
+	for _, r := range s.live(now) {
+		value, err := l.unmarshal(r.value)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, Entry{Key: r.key, Value: value, ExpireAt: r.expireAt})
+	}
+	return entries, nil
+}
+
+// Put appends a put record.
+// expireAt is the expiration time in Unix nanoseconds or 0 if the record never expires.
+func (l *Log) Put(key string, value interface{}, expireAt int64) error {
+	data, err := l.marshal(value)
+	if err != nil {
+		return l.fail(err)
+	}
+	return l.append(opPut, key, expireAt, data)
+}
+
+// Delete appends a delete record
+func (l *Log) Delete(key string) error {
+	return l.append(opDelete, key, 0, nil)
+}
+
+// Expire appends an expiration record
+func (l *Log) Expire(key string) error {
+	return l.append(opExpire, key, 0, nil)
+}
+
+// Clear appends a record removing all previous ones
+func (l *Log) Clear() error {
+	return l.append(opClear, "", 0, nil)
+}
+
+// Compact folds the log into the snapshot and truncates the log
+func (l *Log) Compact() error {
+	l.m.Lock()
+	defer l.m.Unlock()
+
+	return l.compact()
+}
+
+// Sync flushes the log to stable storage
+func (l *Log) Sync() error {
+	l.m.Lock()
+	defer l.m.Unlock()
+
+	return l.sync()
+}
+
+// Size returns the size of the log not folded into the snapshot yet
+func (l *Log) Size() int64 {
+	l.m.Lock()
+	defer l.m.Unlock()
+
+	return l.size
+}
+
+// Err returns the first error the log has failed with.
+// Caches do not report errors of the log on every write, so it should be checked periodically.
+func (l *Log) Err() error {
+	l.m.Lock()
+	defer l.m.Unlock()
+
+	return l.err
+}
+
+// Close syncs and closes the log
+func (l *Log) Close() error {
+	l.closeOnce.Do(func() {
+		close(l.stop)
+	})
+	<-l.done
+
+	l.m.Lock()
+	defer l.m.Unlock()
+
+	if err := l.sync(); err != nil {
+		l.f.Close()
+		return err
+	}
+	return l.f.Close()
+}
+
+func (l *Log) run() {
+	defer close(l.done)
+
+	ticker := time.NewTicker(l.syncInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			l.Sync()
+		case <-l.stop:
+			return
+		}
+	}
+}
+
+func (l *Log) append(op byte, key string, expireAt int64, value []byte) error {
+	l.m.Lock()
+	defer l.m.Unlock()
+
+	data, err := l.encode(op, key, expireAt, value)
+	if err != nil {
+		return l.failLocked(err)
+	}
+	if _, err := l.f.WriteAt(data, l.size); err != nil {
+		return l.failLocked(err)
+	}
+	l.size += int64(len(data))
+	l.unsynced = true
+
+	if l.syncPolicy == SyncAlways {
+		if err := l.sync(); err != nil {
+			return err
+		}
+	}
+
+	if l.compactionSize > 0 && l.size > l.compactionSize {
+		return l.compact()
+	}
+	return nil
+}
+
+func (l *Log) sync() error {
+	if !l.unsynced || l.syncPolicy == SyncNever {
+		return nil
+	}
+	if err := l.f.Sync(); err != nil {
+		return l.failLocked(err)
+	}
+	l.unsynced = false
+	return nil
+}
+
+// fold applies the snapshot and the log to an empty state
+func (l *Log) fold() (*state, error) {
+	s := newState()
+
+	snapshot, err := os.Open(filepath.Join(l.dir, snapshotName))
+	switch {
+	case err == nil:
+		var info os.FileInfo
+		if info, err = snapshot.Stat(); err == nil {
+			_, err = readRecords(snapshot, info.Size(), l.decode(s))
+		}
+		snapshot.Close()
+		if err != nil {
+			return nil, err
+		}
+	case !os.IsNotExist(err):
+		return nil, err
+	}
+
+	if _, err := readRecords(l.f, l.size, l.decode(s)); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (l *Log) compact() error {
+	s, err := l.fold()
+	if err != nil {
+		return l.failLocked(err)
+	}
+
+	tmp, err := ioutil.TempFile(l.dir, snapshotName+".*")
+	if err != nil {
+		return l.failLocked(err)
+	}
+	defer os.Remove(tmp.Name())
+
+	var buf bytes.Buffer
+	for _, r := range s.live(time.Now().UnixNano()) {
+		data, err := l.encode(opPut, r.key, r.expireAt, r.value)
+		if err != nil {
+			return l.failLocked(err)
+		}
+		buf.Write(data)
+	}
+
+	if _, err := tmp.Write(buf.Bytes()); err != nil {
+		tmp.Close()
+		return l.failLocked(err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return l.failLocked(err)
+	}
+	if err := tmp.Close(); err != nil {
+		return l.failLocked(err)
+	}
+	if err := os.Rename(tmp.Name(), filepath.Join(l.dir, snapshotName)); err != nil {
+		return l.failLocked(err)
+	}
+	if err := syncDir(l.dir); err != nil {
+		return l.failLocked(err)
+	}
+
+	// replaying the log over the new snapshot leads to the same state,
+	// so a crash before the truncation loses nothing
+	if err := l.f.Truncate(0); err != nil {
+		return l.failLocked(err)
+	}
+	l.size = 0
+	l.unsynced = true
+	return l.sync()
+}
+
+// encode returns the record with a header passing its payload through the codec
+func (l *Log) encode(op byte, key string, expireAt int64, value []byte) ([]byte, error) {
+	payload := encodePayload(op, key, expireAt, value)
+	if l.codec != nil {
+		var err error
+		if payload, err = l.codec.Encode(payload); err != nil {
+			return nil, err
+		}
+	}
+	if len(payload) > maxPayloadSize {
+		return nil, fmt.Errorf("record of %d bytes exceeds the limit of %d bytes", len(payload), maxPayloadSize)
+	}
+	return frame(payload), nil
+}
+
+// decode returns a function passing payloads through the codec and applying them to the state
+func (l *Log) decode(s *state) func(payload []byte) error {
+	return func(payload []byte) error {
+		if l.codec != nil {
+			var err error
+			if payload, err = l.codec.Decode(payload); err != nil {
+				return err
+			}
+		}
+		return s.apply(payload)
+	}
+}
+
+func (l *Log) fail(err error) error {
+	l.m.Lock()
+	defer l.m.Unlock()
+
+	return l.failLocked(err)
+}
+
+func (l *Log) failLocked(err error) error {
+	if l.err == nil {
+		l.err = err
+	}
+	return err
+}
+
+// state represents live records folded from the snapshot and the log
+type state struct {
+	records map[string]record
+	seq     int
+}
+
+type record struct {
+	key      string
+	value    []byte
+	expireAt int64
+	seq      int
+}
+
+func newState() *state {
+	return &state{records: make(map[string]record)}
+}
+
+func (s *state) apply(payload []byte) error {
+	op, key, expireAt, value, err := decodePayload(payload)
+	if err != nil {
+		return err
+	}
+
+	switch op {
+	case opPut:
+		s.seq++
+		s.records[key] = record{key: key, value: value, expireAt: expireAt, seq: s.seq}
+	case opDelete, opExpire:
+		delete(s.records, key)
+	case opClear:
+		s.records = make(map[string]record)
+	}
+	return nil
+}
+
+// live returns not expired records in the order they were written
+func (s *state) live(now int64) []record {
+	records := make([]record, 0, len(s.records))
+	for _, r := range s.records {
+		if r.expireAt == 0 || r.expireAt > now {
+			records = append(records, r)
+		}
+	}
+
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].seq < records[j].seq
+	})
+	return records
+}
+
+// readRecords calls f for the payload of every record with a valid checksum
+// among the first size bytes and returns the size of the valid prefix
+func readRecords(r io.ReaderAt, size int64, f func(payload []byte) error) (int64, error) {
+	var offset int64
+	header := make([]byte, headerSize)
+
+	for offset+headerSize <= size {
+		if _, err := r.ReadAt(header, offset); err != nil {
+			if err == io.EOF {
+				return offset, nil
+			}
+			return 0, err
+		}
+
+		// every payload has at least an op, zeroed headers come from preallocated or torn files,
+		// and a length beyond the end or the limit comes from a torn or corrupted header
+		payloadSize := int64(binary.LittleEndian.Uint32(header[4:8]))
+		if payloadSize == 0 || payloadSize > maxPayloadSize || payloadSize > size-offset-headerSize {
+			return offset, nil
+		}
+
+		payload := make([]byte, payloadSize)
+		if _, err := r.ReadAt(payload, offset+headerSize); err != nil {
+			if err == io.EOF {
+				return offset, nil
+			}
+			return 0, err
+		}
+
+		if binary.LittleEndian.Uint32(header[0:4]) != crc32.ChecksumIEEE(payload) {
+			return offset, nil
+		}
+
+		if err := f(payload); err != nil {
+			return 0, err
+		}
+		offset += headerSize + int64(len(payload))
+	}
+	return offset, nil
+}
+
+// encodePayload encodes op, key length, key, expireAt and value
+func encodePayload(op byte, key string, expireAt int64, value []byte) []byte {
+	payload := make([]byte, 0, 1+binary.MaxVarintLen64*2+len(key)+len(value))
+	payload = append(payload, op)
+	payload = appendUvarint(payload, uint64(len(key)))
+	payload = append(payload, key...)
+	payload = appendVarint(payload, expireAt)
+	return append(payload, value...)
+}
+
+// frame prepends the payload with a header
+func frame(payload []byte) []byte {
+	data := make([]byte, headerSize, headerSize+len(payload))
+	data = append(data, payload...)
+
+	binary.LittleEndian.PutUint32(data[0:4], crc32.ChecksumIEEE(payload))
+	binary.LittleEndian.PutUint32(data[4:8], uint32(len(payload)))
+	return data
+}
+
+func decodePayload(payload []byte) (byte, string, int64, []byte, error) {
+	if len(payload) == 0 {
+		return 0, "", 0, nil, errCorrupted
+	}
+	op, payload := payload[0], payload[1:]
+
+	keyLen, n := binary.Uvarint(payload)
+	if n <= 0 || uint64(len(payload)-n) < keyLen {
+		return 0, "", 0, nil, errCorrupted
+	}
+	key := string(payload[n : n+int(keyLen)])
+	payload = payload[n+int(keyLen):]
+
+	expireAt, n := binary.Varint(payload)
+	if n <= 0 {
+		return 0, "", 0, nil, errCorrupted
+	}
+	return op, key, expireAt, payload[n:], nil
+}
+
+func appendUvarint(data []byte, x uint64) []byte {
+	buf := make([]byte, binary.MaxVarintLen64)
+	return append(data, buf[:binary.PutUvarint(buf, x)]...)
+}
+
+func appendVarint(data []byte, x int64) []byte {
+	buf := make([]byte, binary.MaxVarintLen64)
+	return append(data, buf[:binary.PutVarint(buf, x)]...)
+}
+
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+
+	return d.Sync()
+}
+
+func marshalGob(value interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(&value); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func unmarshalGob(data []byte) (interface{}, error) {
+	var value interface{}
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&value); err != nil {
+		return nil, err
+	}
+	return value, nil
+}
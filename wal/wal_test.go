@@ -0,0 +1,281 @@
+package wal_test
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/faroyam/caches/wal"
+)
+
+func keys(t *testing.T, log *wal.Log) []string {
+	t.Helper()
+
+	entries, err := log.Entries()
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	keys := make([]string, 0, len(entries))
+	for _, e := range entries {
+		keys = append(keys, e.Key)
+	}
+	return keys
+}
+
+func TestOpen(t *testing.T) {
+	if _, err := wal.Open(t.TempDir(), wal.WithSync(wal.SyncInterval, 0)); err == nil {
+		t.Errorf("expected error")
+	}
+
+	if _, err := wal.Open(t.TempDir(), wal.WithCompactionSize(-1)); err == nil {
+		t.Errorf("expected error")
+	}
+}
+
+func TestLog_Entries(t *testing.T) {
+	dir := t.TempDir()
+	log, _ := wal.Open(dir)
+
+	log.Put("1", "value1", 0)
+	log.Put("2", 2, 0)
+	log.Put("3", "value3", 0)
+	log.Put("1", "value1'", 0)
+	log.Delete("3")
+	log.Put("4", "value4", time.Now().Add(-time.Second).UnixNano())
+	log.Put("5", "value5", time.Now().Add(time.Hour).UnixNano())
+	log.Expire("5")
+	log.Close()
+
+	log, _ = wal.Open(dir)
+	defer log.Close()
+
+	entries, err := log.Entries()
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	want := []wal.Entry{
+		{Key: "2", Value: 2},
+		{Key: "1", Value: "value1'"},
+	}
+	if !reflect.DeepEqual(entries, want) {
+		t.Errorf("entries %v, want %v", entries, want)
+	}
+}
+
+func TestLog_Clear(t *testing.T) {
+	log, _ := wal.Open(t.TempDir())
+	defer log.Close()
+
+	log.Put("1", "value1", 0)
+	log.Clear()
+	log.Put("2", "value2", 0)
+
+	if keys := keys(t, log); !reflect.DeepEqual(keys, []string{"2"}) {
+		t.Errorf("keys %v, want %v", keys, []string{"2"})
+	}
+}
+
+func TestLog_TornTail(t *testing.T) {
+	for _, tail := range []int64{-1, -10, 100} {
+		dir := t.TempDir()
+		log, _ := wal.Open(dir)
+		log.Put("1", "value1", 0)
+		log.Put("2", "value2", 0)
+		log.Close()
+
+		// a negative tail cuts the last record, a positive one appends zeros
+
+		path := filepath.Join(dir, "wal.log")
+		info, _ := os.Stat(path)
+		os.Truncate(path, info.Size()+tail)
+
+		log, err := wal.Open(dir)
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+
+		want := []string{"1", "2"}
+		if tail < 0 {
+			want = []string{"1"}
+		}
+		if keys := keys(t, log); !reflect.DeepEqual(keys, want) {
+			t.Errorf("keys %v, want %v", keys, want)
+		}
+
+		// new records are appended after the truncated tail
+
+		log.Put("3", "value3", 0)
+		log.Close()
+
+		log, _ = wal.Open(dir)
+		if keys := keys(t, log); !reflect.DeepEqual(keys, append(want, "3")) {
+			t.Errorf("keys %v, want %v", keys, append(want, "3"))
+		}
+		log.Close()
+	}
+}
+
+func TestLog_CorruptedLength(t *testing.T) {
+	dir := t.TempDir()
+	log, _ := wal.Open(dir)
+	log.Put("1", "value1", 0)
+	log.Close()
+
+	// a header claiming a payload far beyond the end of the log
+
+	f, _ := os.OpenFile(filepath.Join(dir, "wal.log"), os.O_APPEND|os.O_WRONLY, 0o644)
+	f.Write([]byte{0, 0, 0, 0, 0xff, 0xff, 0xff, 0x7f, 1})
+	f.Close()
+
+	log, err := wal.Open(dir)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	log.Put("2", "value2", 0)
+	if keys := keys(t, log); !reflect.DeepEqual(keys, []string{"1", "2"}) {
+		t.Errorf("keys %v, want %v", keys, []string{"1", "2"})
+	}
+	log.Close()
+}
+
+func TestLog_Corrupted(t *testing.T) {
+	dir := t.TempDir()
+	log, _ := wal.Open(dir)
+	log.Put("1", "value1", 0)
+	size := log.Size()
+	log.Put("2", "value2", 0)
+	log.Put("3", "value3", 0)
+	log.Close()
+
+	path := filepath.Join(dir, "wal.log")
+	f, _ := os.OpenFile(path, os.O_RDWR, 0)
+	f.WriteAt([]byte{0xff}, size+10)
+	f.Close()
+
+	log, _ = wal.Open(dir)
+	defer log.Close()
+
+	if keys := keys(t, log); !reflect.DeepEqual(keys, []string{"1"}) {
+		t.Errorf("keys %v, want %v", keys, []string{"1"})
+	}
+
+	if log.Size() != size {
+		t.Errorf("size %v, want %v", log.Size(), size)
+	}
+}
+
+func TestLog_Compact(t *testing.T) {
+	dir := t.TempDir()
+	log, _ := wal.Open(dir)
+
+	for i := 0; i < 100; i++ {
+		log.Put(strconv.Itoa(i%5), i, 0)
+	}
+	log.Delete("0")
+
+	size := log.Size()
+	if err := log.Compact(); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	if log.Size() != 0 {
+		t.Errorf("size %v, want %v", log.Size(), 0)
+	}
+
+	info, _ := os.Stat(filepath.Join(dir, "wal.snapshot"))
+	if info.Size() >= size/10 {
+		t.Errorf("snapshot size %v, want < %v", info.Size(), size/10)
+	}
+
+	log.Put("5", 100, 0)
+	log.Close()
+
+	log, _ = wal.Open(dir)
+	defer log.Close()
+
+	entries, _ := log.Entries()
+	want := []wal.Entry{
+		{Key: "1", Value: 96},
+		{Key: "2", Value: 97},
+		{Key: "3", Value: 98},
+		{Key: "4", Value: 99},
+		{Key: "5", Value: 100},
+	}
+	if !reflect.DeepEqual(entries, want) {
+		t.Errorf("entries %v, want %v", entries, want)
+	}
+}
+
+func TestLog_WithCompactionSize(t *testing.T) {
+	log, _ := wal.Open(t.TempDir(), wal.WithCompactionSize(1024))
+	defer log.Close()
+
+	for i := 0; i < 1000; i++ {
+		log.Put(strconv.Itoa(i%10), i, 0)
+	}
+
+	if log.Size() > 1024 {
+		t.Errorf("size %v, want <= %v", log.Size(), 1024)
+	}
+
+	if keys := keys(t, log); len(keys) != 10 {
+		t.Errorf("keys %v, want %v", len(keys), 10)
+	}
+}
+
+func TestLog_WithSync(t *testing.T) {
+	for _, policy := range []wal.SyncPolicy{wal.SyncAlways, wal.SyncInterval, wal.SyncNever} {
+		dir := t.TempDir()
+		log, err := wal.Open(dir, wal.WithSync(policy, time.Millisecond))
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+
+		log.Put("1", "value1", 0)
+		time.Sleep(5 * time.Millisecond)
+		if err := log.Close(); err != nil {
+			t.Errorf("unexpected error %v", err)
+		}
+
+		log, _ = wal.Open(dir)
+		if keys := keys(t, log); !reflect.DeepEqual(keys, []string{"1"}) {
+			t.Errorf("keys %v, want %v", keys, []string{"1"})
+		}
+		log.Close()
+	}
+}
+
+func TestLog_WithMarshaling(t *testing.T) {
+	log, _ := wal.Open(t.TempDir(), wal.WithMarshaling(
+		func(value interface{}) ([]byte, error) {
+			return []byte(value.(string)), nil
+		},
+		func(data []byte) (interface{}, error) {
+			return string(data), nil
+		},
+	))
+	defer log.Close()
+
+	log.Put("1", "value1", 0)
+
+	if entries, _ := log.Entries(); entries[0].Value != "value1" {
+		t.Errorf("value %v, want %v", entries[0].Value, "value1")
+	}
+
+	// unregistered types can't be encoded with gob
+
+	type unregistered struct{ A int }
+
+	log2, _ := wal.Open(t.TempDir())
+	defer log2.Close()
+
+	if err := log2.Put("1", unregistered{1}, 0); err == nil || log2.Err() == nil {
+		t.Errorf("expected error")
+	}
+}
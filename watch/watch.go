@@ -0,0 +1,250 @@
+// Package watch implements change streams of caches.
+//
+// A cache configured with a hub publishes every insertion, update, delete, eviction and expiration to it,
+// and the hub delivers them to watchers through bounded channels.
+// Events are published under the cache lock, so every watcher receives them in the order of changes.
+// The overflow policy of the hub decides what happens when a watcher falls behind.
+//
+//	c, _ := lru.New(1000, lru.WithWatchBuffer(1024, watch.Disconnect))
+//	for e := range c.Watch(ctx, watch.Prefix("user:")) {
+//		if e.Err != nil {
+//			// resynchronize
+//		}
+//		audit.Log(e.Kind, e.Key, e.Value)
+//	}
+package watch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// DefaultBuffer is the number of events buffered for each watcher by default
+const DefaultBuffer = 1024
+
+// ErrOverflow is the error of the last event received by a watcher disconnected by the Disconnect policy
+var ErrOverflow = errors.New("watcher is too slow")
+
+// Kind represents a kind of change
+type Kind uint8
+
+const (
+	// Put is an insertion of a new key
+	Put Kind = iota + 1
+	// Update is a write of an existing key
+	Update
+	// Delete is a removal of a key by the user, including Clear and tag and prefix invalidation
+	Delete
+	// Evict is a removal of a key to free space
+	Evict
+	// Expire is a removal of an expired key
+	Expire
+)
+
+// String returns the name of the kind
+func (k Kind) String() string {
+	switch k {
+	case Put:
+		return "put"
+	case Update:
+		return "update"
+	case Delete:
+		return "delete"
+	case Evict:
+		return "evict"
+	case Expire:
+		return "expire"
+	default:
+		return fmt.Sprintf("kind(%d)", uint8(k))
+	}
+}
+
+// Event represents a change of a cache
+type Event struct {
+	Kind Kind
+	Key  string
+	// Value is the new value of Put and Update events and the removed value of other events
+	Value interface{}
+	// Err is set only in the last event of a disconnected watcher, other fields are empty then
+	Err error
+}
+
+// Filter selects events delivered to a watcher, nil selects all events.
+// Filters are called under the cache lock and must not use the cache.
+type Filter func(e Event) bool
+
+// Kinds returns a filter selecting events of the kinds
+func Kinds(kinds ...Kind) Filter {
+	return func(e Event) bool {
+		for _, kind := range kinds {
+			if e.Kind == kind {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// Prefix returns a filter selecting events of keys starting with the prefix
+func Prefix(prefix string) Filter {
+	return func(e Event) bool {
+		return strings.HasPrefix(e.Key, prefix)
+	}
+}
+
+// Policy defines what happens to events published while the buffer of a watcher is full
+type Policy int
+
+const (
+	// Disconnect sends the watcher an event with ErrOverflow and closes its channel,
+	// so that the watcher can resynchronize knowing that it missed events
+	Disconnect Policy = iota
+	// DropOldest drops the oldest buffered event to make room for the new one
+	DropOldest
+	// Block blocks until the watcher receives an event or its context is done.
+	// It stalls all writes to the cache, and a watcher using the cache while it is blocked deadlocks.
+	Block
+)
+
+// Hub delivers published events to watchers
+type Hub struct {
+	buffer int
+	policy Policy
+
+	m        *sync.Mutex
+	watchers map[*watcher]struct{}
+	// active is the number of watchers read without the lock by Active
+	active int32
+}
+
+type watcher struct {
+	ctx    context.Context
+	filter Filter
+	events chan Event
+	// done is closed when the watcher is disconnected
+	done chan struct{}
+}
+
+// NewHub returns a hub buffering up to buffer events for each watcher and applying the policy to overflows
+func NewHub(buffer int, policy Policy) (*Hub, error) {
+	if buffer <= 0 {
+		return nil, fmt.Errorf("watch buffer must be positive")
+	}
+	if policy < Disconnect || policy > Block {
+		return nil, fmt.Errorf("unknown overflow policy %d", policy)
+	}
+
+	return &Hub{
+		buffer:   buffer,
+		policy:   policy,
+		m:        &sync.Mutex{},
+		watchers: make(map[*watcher]struct{}),
+	}, nil
+}
+
+// Watch returns a channel of events selected by the filter.
+// The channel is closed when ctx is done or the watcher is disconnected.
+func (h *Hub) Watch(ctx context.Context, filter Filter) <-chan Event {
+	size := h.buffer
+	if h.policy == Disconnect {
+		// room for the error event
+		size++
+	}
+
+	w := &watcher{
+		ctx:    ctx,
+		filter: filter,
+		events: make(chan Event, size),
+		done:   make(chan struct{}),
+	}
+
+	h.m.Lock()
+	h.watchers[w] = struct{}{}
+	atomic.AddInt32(&h.active, 1)
+	h.m.Unlock()
+
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-w.done:
+			return
+		}
+
+		h.m.Lock()
+		defer h.m.Unlock()
+
+		if _, ok := h.watchers[w]; ok {
+			h.remove(w)
+			close(w.events)
+		}
+	}()
+
+	return w.events
+}
+
+// Active reports whether the hub has watchers,
+// so that callers can skip building events nobody receives
+func (h *Hub) Active() bool {
+	return atomic.LoadInt32(&h.active) > 0
+}
+
+// Publish delivers the event to the watchers it is selected by
+func (h *Hub) Publish(kind Kind, key string, value interface{}) {
+	if !h.Active() {
+		return
+	}
+
+	e := Event{Kind: kind, Key: key, Value: value}
+
+	h.m.Lock()
+	defer h.m.Unlock()
+
+	for w := range h.watchers {
+		if w.filter == nil || w.filter(e) {
+			h.send(w, e)
+		}
+	}
+}
+
+// send applies the overflow policy, the hub lock makes Publish the only sender
+func (h *Hub) send(w *watcher, e Event) {
+	switch h.policy {
+	case Disconnect:
+		if len(w.events) < h.buffer {
+			w.events <- e
+			return
+		}
+
+		h.remove(w)
+		close(w.done)
+		w.events <- Event{Err: ErrOverflow}
+		close(w.events)
+	case DropOldest:
+		for {
+			select {
+			case w.events <- e:
+				return
+			default:
+			}
+
+			select {
+			case <-w.events:
+			default:
+			}
+		}
+	case Block:
+		select {
+		case w.events <- e:
+		case <-w.ctx.Done():
+		}
+	}
+}
+
+func (h *Hub) remove(w *watcher) {
+	delete(h.watchers, w)
+	atomic.AddInt32(&h.active, -1)
+}
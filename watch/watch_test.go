@@ -0,0 +1,155 @@
+package watch_test
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/faroyam/caches/watch"
+)
+
+// drain returns the buffered events and whether the channel is closed
+func drain(events <-chan watch.Event) ([]watch.Event, bool) {
+	var drained []watch.Event
+	for {
+		select {
+		case e, ok := <-events:
+			if !ok {
+				return drained, true
+			}
+			drained = append(drained, e)
+		default:
+			return drained, false
+		}
+	}
+}
+
+func put(key string) watch.Event {
+	return watch.Event{Kind: watch.Put, Key: key, Value: key}
+}
+
+func publish(h *watch.Hub, keys ...string) {
+	for _, key := range keys {
+		h.Publish(watch.Put, key, key)
+	}
+}
+
+func TestNewHub(t *testing.T) {
+	if _, err := watch.NewHub(0, watch.Disconnect); err == nil {
+		t.Errorf("expected error")
+	}
+	if _, err := watch.NewHub(1, watch.Policy(10)); err == nil {
+		t.Errorf("expected error")
+	}
+}
+
+func TestHub_Disconnect(t *testing.T) {
+	h, _ := watch.NewHub(2, watch.Disconnect)
+	events := h.Watch(context.Background(), nil)
+
+	publish(h, "a", "b", "c", "d")
+
+	drained, closed := drain(events)
+	want := []watch.Event{put("a"), put("b"), {Err: watch.ErrOverflow}}
+	if !closed || !reflect.DeepEqual(drained, want) {
+		t.Errorf("events %v, closed %v, want %v, %v", drained, closed, want, true)
+	}
+	if h.Active() {
+		t.Errorf("disconnected watcher is active")
+	}
+}
+
+func TestHub_DropOldest(t *testing.T) {
+	h, _ := watch.NewHub(2, watch.DropOldest)
+	events := h.Watch(context.Background(), nil)
+
+	publish(h, "a", "b", "c", "d")
+
+	drained, closed := drain(events)
+	want := []watch.Event{put("c"), put("d")}
+	if closed || !reflect.DeepEqual(drained, want) {
+		t.Errorf("events %v, closed %v, want %v, %v", drained, closed, want, false)
+	}
+}
+
+func TestHub_Block(t *testing.T) {
+	h, _ := watch.NewHub(1, watch.Block)
+	ctx, cancel := context.WithCancel(context.Background())
+	events := h.Watch(ctx, nil)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		publish(h, "a", "b", "c")
+	}()
+
+	for _, key := range []string{"a", "b", "c"} {
+		if e := <-events; !reflect.DeepEqual(e, put(key)) {
+			t.Errorf("event %v, want %v", e, put(key))
+		}
+	}
+	<-done
+
+	// a blocked publisher is released when the watcher is done
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		cancel()
+	}()
+	publish(h, "d", "e")
+
+	for range events {
+	}
+	if h.Active() {
+		t.Errorf("canceled watcher is active")
+	}
+}
+
+func TestHub_Filter(t *testing.T) {
+	h, _ := watch.NewHub(10, watch.Disconnect)
+	kinds := h.Watch(context.Background(), watch.Kinds(watch.Delete, watch.Expire))
+	prefix := h.Watch(context.Background(), watch.Prefix("user:"))
+
+	h.Publish(watch.Put, "user:1", 1)
+	h.Publish(watch.Delete, "user:1", 1)
+	h.Publish(watch.Expire, "session:1", 2)
+
+	if drained, _ := drain(kinds); len(drained) != 2 || drained[0].Kind != watch.Delete || drained[1].Kind != watch.Expire {
+		t.Errorf("events %v, want delete and expire", drained)
+	}
+	if drained, _ := drain(prefix); len(drained) != 2 || drained[0].Key != "user:1" || drained[1].Key != "user:1" {
+		t.Errorf("events %v, want events of %v", drained, "user:1")
+	}
+}
+
+func TestHub_Watch_Cancel(t *testing.T) {
+	h, _ := watch.NewHub(10, watch.Disconnect)
+	ctx, cancel := context.WithCancel(context.Background())
+	events := h.Watch(ctx, nil)
+
+	cancel()
+	if _, ok := <-events; ok {
+		t.Errorf("channel isn't closed")
+	}
+	if h.Active() {
+		t.Errorf("canceled watcher is active")
+	}
+
+	// publishing without watchers is a no-op
+	publish(h, "a")
+}
+
+func TestKind_String(t *testing.T) {
+	for kind, name := range map[watch.Kind]string{
+		watch.Put:    "put",
+		watch.Update: "update",
+		watch.Delete: "delete",
+		watch.Evict:  "evict",
+		watch.Expire: "expire",
+		0:            "kind(0)",
+	} {
+		if kind.String() != name {
+			t.Errorf("name %v, want %v", kind.String(), name)
+		}
+	}
+}